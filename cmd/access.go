@@ -0,0 +1,220 @@
+// kbot-app/cmd/access.go
+// This file contains allowlist/denylist access control applied to every incoming update.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Access rule kinds, used in admin commands and in the denied metric reason
+const (
+	accessKindUser     = "user"
+	accessKindUsername = "username"
+	accessKindChat     = "chat"
+)
+
+// accessRules is a set of user IDs, usernames (lowercase, without '@') and chat IDs
+type accessRules struct {
+	UserIDs   map[int64]bool
+	Usernames map[string]bool
+	ChatIDs   map[int64]bool
+}
+
+// newAccessRules builds a rule set from lists of IDs and usernames
+func newAccessRules(userIDs []int64, usernames []string, chatIDs []int64) accessRules {
+	r := accessRules{UserIDs: map[int64]bool{}, Usernames: map[string]bool{}, ChatIDs: map[int64]bool{}}
+	for _, id := range userIDs {
+		r.UserIDs[id] = true
+	}
+	for _, name := range usernames {
+		r.Usernames[normalizeUsername(name)] = true
+	}
+	for _, id := range chatIDs {
+		r.ChatIDs[id] = true
+	}
+	return r
+}
+
+// empty reports whether the rule set has no entries
+func (r accessRules) empty() bool {
+	return len(r.UserIDs) == 0 && len(r.Usernames) == 0 && len(r.ChatIDs) == 0
+}
+
+// match returns the kind of the first rule matching the update origin
+func (r accessRules) match(userID int64, username string, chatID int64) (string, bool) {
+	switch {
+	case r.UserIDs[userID]:
+		return accessKindUser, true
+	case username != "" && r.Usernames[normalizeUsername(username)]:
+		return accessKindUsername, true
+	case chatID != 0 && r.ChatIDs[chatID]:
+		return accessKindChat, true
+	}
+	return "", false
+}
+
+// set adds or removes a single rule; value is parsed according to kind
+func (r accessRules) set(kind, value string, present bool) error {
+	switch kind {
+	case accessKindUser, accessKindChat:
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s ID %q", kind, value)
+		}
+		target := r.UserIDs
+		if kind == accessKindChat {
+			target = r.ChatIDs
+		}
+		if present {
+			target[id] = true
+		} else {
+			delete(target, id)
+		}
+	case accessKindUsername:
+		name := normalizeUsername(value)
+		if name == "" {
+			return fmt.Errorf("empty username")
+		}
+		if present {
+			r.Usernames[name] = true
+		} else {
+			delete(r.Usernames, name)
+		}
+	default:
+		return fmt.Errorf("unknown rule kind %q (use user, username or chat)", kind)
+	}
+	return nil
+}
+
+// describe lists the rules in a stable, human-readable form
+func (r accessRules) describe() string {
+	var items []string
+	for id := range r.UserIDs {
+		items = append(items, accessKindUser+":"+strconv.FormatInt(id, 10))
+	}
+	for name := range r.Usernames {
+		items = append(items, accessKindUsername+":@"+name)
+	}
+	for id := range r.ChatIDs {
+		items = append(items, accessKindChat+":"+strconv.FormatInt(id, 10))
+	}
+	if len(items) == 0 {
+		return "-"
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
+
+// normalizeUsername lowercases a username and strips the leading '@'
+func normalizeUsername(name string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+}
+
+// runtimeAccess holds rules added by admins at runtime, on top of the configured ones.
+// They survive /admin reload, which only replaces the configured rules.
+var runtimeAccess = struct {
+	mu    sync.RWMutex
+	allow accessRules
+	deny  accessRules
+}{
+	allow: newAccessRules(nil, nil, nil),
+	deny:  newAccessRules(nil, nil, nil),
+}
+
+// checkAccess decides whether an update may be processed. Admins are always allowed,
+// denylists win over allowlists, and an empty allowlist allows everyone.
+func checkAccess(userID int64, username string, chatID int64) (bool, string) {
+	cfg := currentConfig()
+	if cfg.IsAdmin(userID) {
+		return true, ""
+	}
+
+	runtimeAccess.mu.RLock()
+	defer runtimeAccess.mu.RUnlock()
+
+	for _, deny := range []accessRules{cfg.Deny, runtimeAccess.deny} {
+		if kind, ok := deny.match(userID, username, chatID); ok {
+			return false, "denylist_" + kind
+		}
+	}
+	if cfg.Allow.empty() && runtimeAccess.allow.empty() {
+		return true, ""
+	}
+	for _, allow := range []accessRules{cfg.Allow, runtimeAccess.allow} {
+		if _, ok := allow.match(userID, username, chatID); ok {
+			return true, ""
+		}
+	}
+	return false, "not_allowlisted"
+}
+
+// updateRuntimeAccess adds or removes a runtime allow ("allow") or deny ("deny") rule
+func updateRuntimeAccess(list, kind, value string, present bool) error {
+	runtimeAccess.mu.Lock()
+	defer runtimeAccess.mu.Unlock()
+	rules := runtimeAccess.allow
+	if list == "deny" {
+		rules = runtimeAccess.deny
+	}
+	return rules.set(kind, value, present)
+}
+
+// isUserDenied reports whether the user ID is on the runtime denylist (i.e. banned by an admin)
+func isUserDenied(userID int64) bool {
+	runtimeAccess.mu.RLock()
+	defer runtimeAccess.mu.RUnlock()
+	return runtimeAccess.deny.UserIDs[userID]
+}
+
+// accessControlMiddleware drops updates that do not pass checkAccess before they reach a handler
+func accessControlMiddleware(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		sender := c.Sender()
+		if sender == nil {
+			return next(c)
+		}
+		var chatID int64
+		if chat := c.Chat(); chat != nil {
+			chatID = chat.ID
+		}
+		if allowed, reason := checkAccess(sender.ID, sender.Username, chatID); !allowed {
+			accessDeniedCounter.Add(context.Background(), 1, metric.WithAttributes(attribute.String("access.reason", reason)))
+			log.Printf("Access denied for user %d (%s) in chat %d: %s", sender.ID, sender.Username, chatID, reason)
+			return nil
+		}
+		return next(c)
+	}
+}
+
+// adminAccess handles "/admin access", "/admin allow|unallow|deny|undeny <kind> <value>"
+func adminAccess(locale, action string, args []string) (string, error) {
+	if action == "access" {
+		cfg := currentConfig()
+		runtimeAccess.mu.RLock()
+		defer runtimeAccess.mu.RUnlock()
+		return tr(locale, "admin.access.list",
+			cfg.Allow.describe(), cfg.Deny.describe(),
+			runtimeAccess.allow.describe(), runtimeAccess.deny.describe()), nil
+	}
+
+	if len(args) < 2 {
+		return "", fmt.Errorf("usage: /admin %s user|username|chat <value>", action)
+	}
+	list, present := strings.TrimPrefix(action, "un"), !strings.HasPrefix(action, "un")
+	if err := updateRuntimeAccess(list, strings.ToLower(args[0]), args[1], present); err != nil {
+		return "", err
+	}
+	return tr(locale, "admin.access.updated"), nil
+}
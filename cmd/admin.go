@@ -0,0 +1,190 @@
+// kbot-app/cmd/admin.go
+// This file contains the /admin command set available to operators listed in KBOT_ADMIN_IDS.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// isAdmin reports whether the user may use /admin
+func isAdmin(userID int64) bool {
+	return currentConfig().IsAdmin(userID)
+}
+
+// handleAdmin dispatches /admin subcommands. It is registered with scopeAdmin, so the registry
+// has already rejected non-admins: their message is logged as "not_admin" and dropped unanswered.
+func handleAdmin(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleAdmin",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	locale := userLocale(c)
+	args := c.Args()
+	if len(args) == 0 {
+		return c.Send(tr(locale, "admin.usage"))
+	}
+
+	action := strings.ToLower(args[0])
+	span.SetAttributes(attribute.String("admin.action", action))
+
+	var reply string
+	var err error
+	switch action {
+	case "stats":
+		reply, err = adminStats(ctx, locale)
+	case "user":
+		reply, err = adminUser(ctx, locale, args[1:])
+	case "ban":
+		reply, err = adminBan(ctx, locale, args[1:], true)
+	case "unban":
+		reply, err = adminBan(ctx, locale, args[1:], false)
+	case "reload":
+		reply, err = adminReload(locale)
+	case "access", "allow", "unallow", "deny", "undeny":
+		reply, err = adminAccess(locale, action, args[1:])
+	case "broadcast":
+		reply, err = adminBroadcast(c.Bot(), c.Sender().ID, locale, c.Message().Text)
+	default:
+		return c.Send(tr(locale, "admin.usage"))
+	}
+
+	auditAdminAction(ctx, c, action, strings.Join(args[1:], " "), err)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Admin command failed")
+		return c.Send(tr(locale, "admin.error", err))
+	}
+	return c.Send(reply)
+}
+
+// adminStats reports counters collected from the process metrics and live store sizes
+func adminStats(ctx context.Context, locale string) (string, error) {
+	totals, err := collectMetricTotals(ctx)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString(tr(locale, "admin.stats.header"))
+	for _, name := range names {
+		fmt.Fprintf(&sb, "\n%s: %v", name, totals[name])
+	}
+
+	knownUsers := 0
+	userSettingsStore.Range(func(_, _ interface{}) bool { knownUsers++; return true })
+	runtimeAccess.mu.RLock()
+	bannedCount := len(runtimeAccess.deny.UserIDs)
+	runtimeAccess.mu.RUnlock()
+	sb.WriteString("\n\n")
+	sb.WriteString(tr(locale, "admin.stats.live", knownUsers, sessions.Count(), bannedCount))
+	return sb.String(), nil
+}
+
+// adminUser shows a user's settings and session, or resets them with "user <id> reset"
+func adminUser(ctx context.Context, locale string, args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: /admin user <id> [reset]")
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid user ID %q", args[0])
+	}
+
+	if len(args) > 1 && strings.EqualFold(args[1], "reset") {
+		userSettingsStore.Delete(userID)
+		settingsHistory.forget(userID)
+		recentImages.forget(userID)
+		sessions.Exit(ctx, userID)
+		return tr(locale, "admin.user.reset", userID), nil
+	}
+
+	settingsRaw, ok := userSettingsStore.Load(userID)
+	if !ok {
+		return tr(locale, "admin.user.unknown", userID), nil
+	}
+	settings := settingsRaw.(UserSettings)
+	return tr(locale, "admin.user.info", userID, settings.TextColor, settings.BgColor, settings.Lang,
+		sessions.Get(userID).State, isUserDenied(userID)), nil
+}
+
+// adminBan bans or unbans a user by adding or removing a runtime denylist rule; admins cannot be banned
+func adminBan(ctx context.Context, locale string, args []string, ban bool) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("usage: /admin ban|unban <id>")
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid user ID %q", args[0])
+	}
+
+	if !ban {
+		if err := updateRuntimeAccess("deny", accessKindUser, args[0], false); err != nil {
+			return "", err
+		}
+		return tr(locale, "admin.unbanned", userID), nil
+	}
+	if isAdmin(userID) {
+		return "", fmt.Errorf("user %d is an admin and cannot be banned", userID)
+	}
+	if err := updateRuntimeAccess("deny", accessKindUser, args[0], true); err != nil {
+		return "", err
+	}
+	sessions.Exit(ctx, userID)
+	return tr(locale, "admin.banned", userID), nil
+}
+
+// adminReload rebuilds the configuration to pick up changed files: the moderation word list
+// (KBOT_MODERATION_WORDS_FILE) and the watermark logo (KBOT_WATERMARK_LOGO). The environment of a
+// running process does not change, so every other setting stays as it was at startup.
+func adminReload(locale string) (string, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return "", err
+	}
+	appConfig.Store(cfg)
+	log.Println("Configuration reloaded.")
+	return tr(locale, "admin.reloaded"), nil
+}
+
+// auditAdminAction writes an audit record for an admin command to the log, the trace and metrics
+func auditAdminAction(ctx context.Context, c tele.Context, action, target string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	log.Printf("AUDIT: admin %d (%s) action=%s target=%q result=%s err=%v",
+		c.Sender().ID, c.Sender().Username, action, target, result, err)
+	trace.SpanFromContext(ctx).AddEvent("admin.audit", trace.WithAttributes(
+		attribute.String("admin.action", action),
+		attribute.String("admin.target", target),
+		attribute.String("admin.result", result),
+	))
+	adminCommandCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("admin.action", action),
+		attribute.String("admin.result", result),
+	))
+}
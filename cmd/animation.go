@@ -0,0 +1,362 @@
+// kbot-app/cmd/animation.go
+// This file contains /animate: typewriter, fade-in and color-cycle text animations rendered as GIF or MP4.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Animation styles accepted by /animate
+const (
+	animTypewriter = "typewriter" // Text appears rune by rune
+	animFade       = "fade"       // Text fades in
+	animCycle      = "cycle"      // Text color cycles through the hue wheel
+)
+
+// Animation output formats of KBOT_ANIMATION_FORMAT
+const (
+	animFormatGIF = "gif"
+	animFormatMP4 = "mp4" // Converted from GIF with ffmpeg, which must be in PATH
+)
+
+// Animation limits: they keep encoding time and upload size reasonable
+const (
+	animMaxFrames   = 30
+	animFrameDelay  = 8  // Delay between frames, in 1/100 s
+	animHoldFrames  = 10 // The last frame is shown this many frame delays longer
+	animMaxWidth    = 512
+	animFontSize    = 36
+	animMaxBytes    = 8 << 20
+	animFFmpegLimit = 30 * time.Second
+)
+
+// handleAnimate handles /animate [typewriter|fade|cycle] <text> with the user's saved settings
+func handleAnimate(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleAnimate",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	mainMenu := mainMenuFor(locale)
+	style, text := parseAnimateArgs(c.Message().Text)
+	if text == "" {
+		return c.Send(tr(locale, "cmd.usage", commandUsage("animate")), mainMenu)
+	}
+	if ok, wait := renderLimiter.Allow(ctx, telegramRequester(senderID), "telegram"); !ok {
+		span.AddEvent("Rate limited")
+		return c.Send(tr(locale, "image.rate_limited", retrySeconds(wait)), mainMenu)
+	}
+	if blocked, err := moderationBlocked(ctx, c, text, sourceAnimation); blocked {
+		span.SetStatus(codes.Error, "Blocked by moderation")
+		return err
+	}
+	format := currentConfig().AnimationFormat
+	span.SetAttributes(attribute.String("animation.style", style), attribute.String("animation.format", format))
+
+	imageGenRequestCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("image.output", "animation")))
+	startTime := time.Now()
+
+	// Animations are always drawn by the local renderer: remote providers only return still images
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	settings := settingsRaw.(UserSettings)
+	settings.Size = "" // Fixed canvases are too large for animations; the frames fit the text
+	if layout := settings.layout(); layout.MaxWidth > animMaxWidth {
+		settings.MaxWidth = fmt.Sprint(animMaxWidth)
+	}
+	req := RenderRequest{Text: text, Settings: settings}
+	if settings.BgMode == bgPhoto && settings.BgPhoto != "" {
+		photo, err := loadBackgroundPhoto(ctx, c.Bot(), settings.BgPhoto)
+		if err != nil {
+			log.Printf("Using background color for user %d: %v", senderID, err)
+		}
+		req.Photo = photo
+	}
+
+	data, err := renderAnimation(ctx, req, style, format)
+	if err != nil {
+		log.Printf("Animation failed for user %d: %v", senderID, err)
+		rerr, ok := err.(*renderError)
+		if !ok {
+			rerr = &renderError{Type: "unknown", Key: "image.error.failed", Err: err}
+		}
+		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", rerr.Type), attribute.String("image.output", "animation")))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return c.Send(tr(locale, rerr.Key, rerr.Args...), mainMenu)
+	}
+	imageGenerationDuration.Record(ctx, time.Since(startTime).Seconds(),
+		metric.WithAttributes(attribute.Bool("success", true), attribute.String("image.renderer", rendererLocal), attribute.String("image.output", "animation")))
+	imageGenSuccessCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("image.output", "animation")))
+	span.SetAttributes(attribute.Int("animation.bytes", len(data)))
+
+	animation := &tele.Animation{
+		File:     tele.FromReader(bytes.NewReader(data)),
+		FileName: "animation." + format,
+		Caption:  imageCaption(locale, text),
+	}
+	if format == animFormatMP4 {
+		animation.MIME = "video/mp4"
+	}
+	log.Printf("Sending %s animation (%s, %d bytes) to user %d (%s)", style, format, len(data), senderID, c.Sender().Username)
+	if err := c.Send(animation, mainMenu); err != nil {
+		log.Printf("Error sending animation to user %d: %v", senderID, err)
+		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "telegram_send_error"), attribute.String("image.output", "animation")))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to send animation to Telegram")
+		return c.Send(tr(locale, "image.error.send"), mainMenu)
+	}
+	return nil
+}
+
+// parseAnimateArgs splits "/animate [style] <text>" taken from the raw message, so that line breaks are kept
+func parseAnimateArgs(raw string) (string, string) {
+	body := commandText(raw)
+	if fields := strings.Fields(body); len(fields) > 0 {
+		switch style := strings.ToLower(fields[0]); style {
+		case animTypewriter, animFade, animCycle:
+			return style, strings.TrimSpace(body[len(fields[0]):])
+		}
+	}
+	return animTypewriter, body
+}
+
+// renderAnimation draws the frames of the style and encodes them in format
+func renderAnimation(ctx context.Context, req RenderRequest, style, format string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "renderAnimation")
+	defer span.End()
+
+	req.Settings = brandSettings(req.Settings)
+	scene, err := newLocalScene(req, req.Settings.fontSize(animFontSize))
+	if err != nil {
+		return nil, err
+	}
+	defer scene.Close()
+
+	frames := animationFrames(scene, style)
+	span.SetAttributes(attribute.Int("animation.frames", len(frames)))
+	for _, frame := range frames {
+		if err := applyWatermark(frame); err != nil {
+			return nil, &renderError{Type: "watermark_error", Key: "image.error.failed", Err: err}
+		}
+	}
+	data, err := encodeGIF(frames)
+	if err != nil {
+		return nil, &renderError{Type: "encode_error", Key: "image.error.failed", Err: err}
+	}
+	if format == animFormatMP4 {
+		if data, err = convertToMP4(ctx, data); err != nil {
+			return nil, &renderError{Type: "ffmpeg_error", Key: "animation.error.mp4", Err: err}
+		}
+	}
+	if len(data) > animMaxBytes {
+		return nil, &renderError{Type: "too_large", Key: "animation.error.too_large", Err: fmt.Errorf("animation is %d bytes", len(data))}
+	}
+	return data, nil
+}
+
+// animationFrames draws at most animMaxFrames frames of the style
+func animationFrames(scene *localScene, style string) []*image.RGBA {
+	var frames []*image.RGBA
+	switch style {
+	case animFade:
+		for i := 1; i <= animMaxFrames; i++ {
+			frames = append(frames, scene.frame(-1, float64(i)/animMaxFrames, scene.textColor))
+		}
+	case animCycle:
+		for i := 0; i < animMaxFrames; i++ {
+			frames = append(frames, scene.frame(-1, 1, rotateHue(scene.textColor, float64(i)/animMaxFrames)))
+		}
+	default:
+		total := 0
+		for _, line := range scene.lines {
+			total += len([]rune(line))
+		}
+		// Reveal several runes per frame when the text is longer than the frame budget
+		step := int(math.Ceil(float64(total) / animMaxFrames))
+		if step < 1 {
+			step = 1
+		}
+		for visible := step; visible < total+step; visible += step {
+			frames = append(frames, scene.frame(min(visible, total), 1, scene.textColor))
+		}
+	}
+	return frames
+}
+
+// encodeGIF quantizes the frames to a shared palette and encodes a looping GIF
+func encodeGIF(frames []*image.RGBA) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames")
+	}
+	palette := buildPalette(frames)
+	anim := &gif.GIF{}
+	cache := make(map[color.RGBA]uint8)
+	for i, frame := range frames {
+		anim.Image = append(anim.Image, quantize(frame, palette, cache))
+		delay := animFrameDelay
+		if i == len(frames)-1 {
+			delay *= animHoldFrames
+		}
+		anim.Delay = append(anim.Delay, delay)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// buildPalette picks up to 256 colors of the frames by popularity, counting colors at 5 bits per channel
+func buildPalette(frames []*image.RGBA) color.Palette {
+	type bucket struct {
+		r, g, b, n int
+	}
+	buckets := make(map[uint16]*bucket)
+	for _, img := range frames {
+		for i := 0; i < len(img.Pix); i += 4 {
+			r, g, b := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+			key := uint16(r>>3)<<10 | uint16(g>>3)<<5 | uint16(b>>3)
+			bk := buckets[key]
+			if bk == nil {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.r, bk.g, bk.b, bk.n = bk.r+r, bk.g+g, bk.b+b, bk.n+1
+		}
+	}
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	// Most frequent first; ties are broken by the color so the palette is deterministic
+	sort.Slice(sorted, func(i, k int) bool {
+		if sorted[i].n != sorted[k].n {
+			return sorted[i].n > sorted[k].n
+		}
+		return sorted[i].r*65536+sorted[i].g*256+sorted[i].b < sorted[k].r*65536+sorted[k].g*256+sorted[k].b
+	})
+	palette := color.Palette{}
+	for _, bk := range sorted {
+		if len(palette) == 256 {
+			break
+		}
+		palette = append(palette, color.RGBA{R: uint8(bk.r / bk.n), G: uint8(bk.g / bk.n), B: uint8(bk.b / bk.n), A: 0xff})
+	}
+	return palette
+}
+
+// quantize maps every pixel to the nearest palette color; cache remembers lookups across frames
+func quantize(img *image.RGBA, palette color.Palette, cache map[color.RGBA]uint8) *image.Paletted {
+	out := image.NewPaletted(img.Bounds(), palette)
+	for i, j := 0, 0; i < len(img.Pix); i, j = i+4, j+1 {
+		c := color.RGBA{R: img.Pix[i], G: img.Pix[i+1], B: img.Pix[i+2], A: 0xff}
+		idx, ok := cache[c]
+		if !ok {
+			idx = uint8(palette.Index(c))
+			cache[c] = idx
+		}
+		out.Pix[j] = idx
+	}
+	return out
+}
+
+// rotateHue turns the color around the hue wheel by turn (0..1 is a full turn)
+func rotateHue(c color.RGBA, turn float64) color.RGBA {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+	maxC, minC := math.Max(r, math.Max(g, b)), math.Min(r, math.Min(g, b))
+	v, s := maxC, 0.0
+	if maxC > 0 {
+		s = (maxC - minC) / maxC
+	}
+	if s == 0 {
+		// Grey has no hue: cycle through fully saturated colors of the same brightness instead
+		s, v = 1, math.Max(v, 0.5)
+	}
+	var h float64
+	switch d := maxC - minC; {
+	case d == 0:
+		h = 0
+	case maxC == r:
+		h = math.Mod((g-b)/d, 6)
+	case maxC == g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h = math.Mod(h/6+turn+1, 1) * 6
+
+	i := math.Floor(h)
+	f := h - i
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var rr, gg, bb float64
+	switch int(i) % 6 {
+	case 0:
+		rr, gg, bb = v, t, p
+	case 1:
+		rr, gg, bb = q, v, p
+	case 2:
+		rr, gg, bb = p, v, t
+	case 3:
+		rr, gg, bb = p, q, v
+	case 4:
+		rr, gg, bb = t, p, v
+	default:
+		rr, gg, bb = v, p, q
+	}
+	return color.RGBA{R: uint8(rr * 255), G: uint8(gg * 255), B: uint8(bb * 255), A: 0xff}
+}
+
+// convertToMP4 converts a GIF to an H.264 MP4 with ffmpeg
+func convertToMP4(ctx context.Context, gifData []byte) ([]byte, error) {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not found: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "kbot-anim-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in.gif"), filepath.Join(dir, "out.mp4")
+	if err := os.WriteFile(in, gifData, 0o600); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, animFFmpegLimit)
+	defer cancel()
+	// yuv420p and even dimensions are required by most players, Telegram included
+	cmd := exec.CommandContext(ctx, ffmpeg, "-y", "-loglevel", "error", "-i", in,
+		"-movflags", "faststart", "-pix_fmt", "yuv420p", "-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.ReadFile(out)
+}
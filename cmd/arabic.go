@@ -0,0 +1,123 @@
+// kbot-app/cmd/arabic.go
+// This file contains Arabic letter joining for the local renderer. The font renderer has no
+// OpenType shaping, so letters are replaced by their contextual presentation forms (U+FE70-U+FEFF),
+// which fonts such as DejaVu Sans provide.
+
+package cmd
+
+import "unicode"
+
+// arabicForms maps a letter to its presentation forms: isolated, final, initial, medial.
+// Right-joining letters only have the first two; zero means the form does not exist.
+var arabicForms = buildArabicForms()
+
+// buildArabicForms derives the table from the layout of the Arabic Presentation Forms-B block,
+// which lists the forms of U+0621-U+063A and U+0641-U+064A in code point order
+func buildArabicForms() map[rune][4]rune {
+	// Joining type of every letter in block order: U (non-joining), R (right-joining) or D (dual-joining)
+	letters := []struct {
+		first, last rune
+		types       string
+	}{
+		{0x0621, 0x063A, "URRRRDRDRDDDDDRRRRDDDDDDDD"},
+		{0x0641, 0x064A, "DDDDDDDRRD"},
+	}
+	forms := make(map[rune][4]rune)
+	next := rune(0xFE80)
+	for _, block := range letters {
+		for i, joining := range block.types {
+			var f [4]rune
+			count := map[rune]int{'U': 1, 'R': 2, 'D': 4}[joining]
+			for j := 0; j < count; j++ {
+				f[j] = next + rune(j)
+			}
+			forms[block.first+rune(i)] = f
+			next += rune(count)
+		}
+	}
+	return forms
+}
+
+// lamAlef maps the alef that follows a lam to the isolated form of their ligature; the final form follows it
+var lamAlef = map[rune]rune{
+	0x0622: 0xFEF5, // Alef with madda above
+	0x0623: 0xFEF7, // Alef with hamza above
+	0x0625: 0xFEF9, // Alef with hamza below
+	0x0627: 0xFEFB, // Alef
+}
+
+const (
+	arabicLam     = 0x0644
+	arabicTatweel = 0x0640 // Joins on both sides and has no forms of its own
+)
+
+// shapeArabic replaces Arabic letters with the forms that join them to their neighbours.
+// Text without Arabic letters is returned unchanged. It works on logical order, before visualOrder.
+func shapeArabic(line string) string {
+	runes := []rune(line)
+	hasArabic := false
+	for _, r := range runes {
+		if _, ok := arabicForms[r]; ok {
+			hasArabic = true
+			break
+		}
+	}
+	if !hasArabic {
+		return line
+	}
+
+	out := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		prev, next := arabicNeighbour(runes, i, -1), arabicNeighbour(runes, i, 1)
+		joinsPrev := joinsForward(prev) && forms[1] != 0
+		if r == arabicLam && i+1 < len(runes) {
+			if ligature, ok := lamAlef[runes[i+1]]; ok {
+				if joinsPrev {
+					ligature++ // Final form
+				}
+				out = append(out, ligature)
+				i++ // The alef is part of the ligature
+				continue
+			}
+		}
+		joinsNext := forms[2] != 0 && joinsBackward(next)
+		switch {
+		case joinsPrev && joinsNext:
+			out = append(out, forms[3])
+		case joinsPrev:
+			out = append(out, forms[1])
+		case joinsNext:
+			out = append(out, forms[2])
+		default:
+			out = append(out, forms[0])
+		}
+	}
+	return string(out)
+}
+
+// arabicNeighbour returns the closest rune before (dir -1) or after (dir 1) position i,
+// skipping combining marks, which are transparent for joining; 0 at the line edges
+func arabicNeighbour(runes []rune, i, dir int) rune {
+	for j := i + dir; j >= 0 && j < len(runes); j += dir {
+		if !unicode.In(runes[j], unicode.Mn, unicode.Me) {
+			return runes[j]
+		}
+	}
+	return 0
+}
+
+// joinsForward reports whether r connects to the letter after it (dual-joining letters and tatweel)
+func joinsForward(r rune) bool {
+	return r == arabicTatweel || arabicForms[r][2] != 0
+}
+
+// joinsBackward reports whether r connects to the letter before it (right- and dual-joining letters and tatweel)
+func joinsBackward(r rune) bool {
+	return r == arabicTatweel || arabicForms[r][1] != 0
+}
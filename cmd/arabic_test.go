@@ -0,0 +1,37 @@
+// kbot-app/cmd/arabic_test.go
+// This file contains the tests of Arabic letter joining.
+
+package cmd
+
+import "testing"
+
+func TestShapeArabic(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"latin unchanged", "Hello", "Hello"},
+		{"cyrillic unchanged", "Привіт", "Привіт"},
+		{"hebrew unchanged", "שלום", "שלום"},
+		{"isolated letter", "ب", "ﺏ"},
+		{"two letters", "بب", "ﺑﺐ"},
+		{"initial medial final", "ببب", "ﺑﺒﺐ"},
+		{"right-joining letter breaks the word", "بابا", "ﺑﺎﺑﺎ"},
+		{"words", "مرحبا بالعالم", "ﻣﺮﺣﺒﺎ ﺑﺎﻟﻌﺎﻟﻢ"},
+		{"lam-alef ligature", "لا", "ﻻ"},
+		{"final lam-alef ligature", "سلام", "ﺳﻼﻡ"},
+		{"digits do not join", "ب1ب", "ﺏ1ﺏ"},
+		{"tatweel joins", "بـب", "ﺑـﺐ"},
+		// Harakat are transparent: the letters around them still join
+		{"harakat", "مَرْحَبًا", "ﻣَﺮْﺣَﺒًﺎ"},
+		{"mixed with latin", "abc سلام", "abc ﺳﻼﻡ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shapeArabic(tt.line); got != tt.want {
+				t.Errorf("shapeArabic(%q) = %U, want %U", tt.line, []rune(got), []rune(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// kbot-app/cmd/background.go
+// This file contains the background modes of generated images: solid color, gradients and user photos.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg" // Telegram delivers photos as JPEG
+	_ "image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Background modes of UserSettings.BgMode
+const (
+	bgSolid  = "solid"
+	bgLinear = "linear" // Linear gradient from BgColor to BgColor2 at BgAngle
+	bgRadial = "radial" // Radial gradient from BgColor in the center to BgColor2 in the corners
+	bgPhoto  = "photo"  // Photo uploaded in settings mode, stored as a Telegram file_id
+
+	bgTransparent = "transparent" // Used for stickers; users cannot select it
+)
+
+// Background defaults used while a setting is unset (empty)
+const (
+	defaultBgColor2 = "000000"
+	defaultBgAngle  = 90 // Degrees; 0 runs left to right, 90 top to bottom
+)
+
+// backgroundStyle is UserSettings' background with defaults applied
+type backgroundStyle struct {
+	Mode  string
+	From  color.RGBA
+	To    color.RGBA
+	Angle float64 // Degrees
+}
+
+// background resolves the background settings, replacing unset values with defaults
+func (s UserSettings) background() (backgroundStyle, error) {
+	style := backgroundStyle{Mode: s.BgMode, Angle: defaultBgAngle}
+	if style.Mode == "" {
+		style.Mode = bgSolid
+	}
+	var err error
+	if style.From, err = parseHexColor(s.BgColor); err != nil {
+		return style, err
+	}
+	color2 := s.BgColor2
+	if color2 == "" {
+		color2 = defaultBgColor2
+	}
+	if style.To, err = parseHexColor(color2); err != nil {
+		return style, err
+	}
+	if angle, err := strconv.Atoi(s.BgAngle); err == nil {
+		style.Angle = float64(angle)
+	}
+	return style, nil
+}
+
+// backgroundSummary describes the background settings for the settings mode greeting
+func backgroundSummary(s UserSettings) string {
+	mode, color2, angle := s.BgMode, s.BgColor2, s.BgAngle
+	if mode == "" {
+		mode = bgSolid
+	}
+	if color2 == "" {
+		color2 = defaultBgColor2
+	}
+	if angle == "" {
+		angle = strconv.Itoa(defaultBgAngle)
+	}
+	switch mode {
+	case bgLinear:
+		return fmt.Sprintf("%s #%s -> #%s, %s°", mode, s.BgColor, color2, angle)
+	case bgRadial:
+		return fmt.Sprintf("%s #%s -> #%s", mode, s.BgColor, color2)
+	case bgPhoto:
+		if s.BgPhoto == "" {
+			return mode + " (-)" // No photo uploaded yet: rendered with the background color
+		}
+	}
+	return mode
+}
+
+// parseBgModeValue accepts solid, linear, radial or photo
+func parseBgModeValue(raw string) (string, bool) {
+	value := strings.ToLower(strings.TrimSpace(raw))
+	switch value {
+	case bgSolid, bgLinear, bgRadial, bgPhoto:
+		return value, true
+	}
+	return value, false
+}
+
+// drawBackground fills dst according to style. photo is the decoded user photo; without it
+// the photo mode falls back to the solid color.
+func drawBackground(dst *image.RGBA, style backgroundStyle, photo image.Image) {
+	bounds := dst.Bounds()
+	switch {
+	case style.Mode == bgTransparent:
+		draw.Draw(dst, bounds, image.Transparent, image.Point{}, draw.Src)
+	case style.Mode == bgPhoto && photo != nil:
+		xdraw.CatmullRom.Scale(dst, bounds, photo, coverRect(photo.Bounds(), bounds), draw.Src, nil)
+	case style.Mode == bgLinear || style.Mode == bgRadial:
+		w, h := float64(bounds.Dx()), float64(bounds.Dy())
+		cx, cy := w/2, h/2
+		rad := style.Angle * math.Pi / 180
+		cos, sin := math.Cos(rad), math.Sin(rad)
+		// Half of the image extent along the gradient direction, so the gradient spans the whole image
+		halfExtent := (w*math.Abs(cos) + h*math.Abs(sin)) / 2
+		halfDiagonal := math.Hypot(cx, cy)
+		for y := 0; y < bounds.Dy(); y++ {
+			for x := 0; x < bounds.Dx(); x++ {
+				dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+				var t float64
+				if style.Mode == bgLinear {
+					t = ((dx*cos+dy*sin)/halfExtent + 1) / 2
+				} else {
+					t = math.Hypot(dx, dy) / halfDiagonal
+				}
+				dst.SetRGBA(bounds.Min.X+x, bounds.Min.Y+y, lerpColor(style.From, style.To, t))
+			}
+		}
+	default:
+		draw.Draw(dst, bounds, image.NewUniform(style.From), image.Point{}, draw.Src)
+	}
+}
+
+// coverRect returns the centered part of src with the aspect ratio of dst, so that scaling it fills dst without distortion
+func coverRect(src, dst image.Rectangle) image.Rectangle {
+	sw, sh := src.Dx(), src.Dy()
+	if sw*dst.Dy() > sh*dst.Dx() {
+		// The source is wider: crop the sides
+		w := sh * dst.Dx() / dst.Dy()
+		x := src.Min.X + (sw-w)/2
+		return image.Rect(x, src.Min.Y, x+w, src.Max.Y)
+	}
+	h := sw * dst.Dy() / dst.Dx()
+	y := src.Min.Y + (sh-h)/2
+	return image.Rect(src.Min.X, y, src.Max.X, y+h)
+}
+
+// lerpColor interpolates between two colors; t is clamped to [0, 1]
+func lerpColor(from, to color.RGBA, t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	mix := func(a, b uint8) uint8 { return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t)) }
+	return color.RGBA{R: mix(from.R, to.R), G: mix(from.G, to.G), B: mix(from.B, to.B), A: 0xff}
+}
+
+// loadBackgroundPhoto downloads and decodes a photo stored by its Telegram file_id
+func loadBackgroundPhoto(ctx context.Context, b tele.API, fileID string) (image.Image, error) {
+	_, span := tracer.Start(ctx, "loadBackgroundPhoto")
+	defer span.End()
+
+	reader, err := b.File(&tele.File{FileID: fileID})
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("downloading background photo: %w", err)
+	}
+	defer reader.Close()
+	img, _, err := image.Decode(reader)
+	if err != nil {
+		span.RecordError(err)
+		return nil, fmt.Errorf("decoding background photo: %w", err)
+	}
+	return img, nil
+}
@@ -0,0 +1,505 @@
+// kbot-app/cmd/batch.go
+// This file contains batch generation: a .txt or .csv document becomes one image per line (or row),
+// sent back as albums or as a ZIP archive.
+//
+// A .txt file has one text per line; "\n" in a line is a line break. A .csv file has the text in the
+// first column and optional text and background colors in the next two. With a header row the columns
+// are named instead: "text" plus any inline option name (fg, bg, align, size, ...), see options.go.
+// The caption of the document may hold inline options for every image and the word "zip".
+
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Batch outputs, chosen by the caption of the document
+const (
+	batchAlbum = "album" // Albums of up to batchAlbumSize photos
+	batchZIP   = "zip"   // One ZIP archive of PNG files
+)
+
+// Batch limits: they keep a batch within a few minutes of rendering and Telegram's upload limits
+const (
+	batchMaxFileBytes = 256 << 10
+	batchMaxItems     = 50
+	batchMaxTextRunes = 1000
+	batchAlbumSize    = 10 // Telegram's limit of photos in a media group
+	batchMaxZIPBytes  = 50 << 20
+	batchProgressStep = 5 // The progress message is updated after this many images
+)
+
+// batchItem is one image of a batch: its text, the line it came from and its own options
+type batchItem struct {
+	Line    int
+	Text    string
+	Options inlineOptions
+}
+
+// batchesRunning holds the users with a batch in progress; a user runs one batch at a time
+var batchesRunning sync.Map // Key: int64 (UserID)
+
+// handleBatchDocument handles .txt and .csv documents sent in private chats, and .json settings exports
+func handleBatchDocument(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleBatchDocument",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.document.name", c.Message().Document.FileName),
+		))
+	defer span.End()
+
+	if c.Chat().Type != tele.ChatPrivate {
+		return nil // Documents in groups are not meant for the bot
+	}
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	mainMenu := mainMenuFor(locale)
+	doc := c.Message().Document
+
+	// A JSON document is a settings export sent back to the bot
+	if isSettingsDocument(doc) {
+		return importSettingsDocument(ctx, c, span)
+	}
+	kind := batchFileKind(doc)
+	if kind == "" {
+		return c.Send(tr(locale, "batch.unsupported"), mainMenu)
+	}
+	if sessions.Get(senderID).State.InSettings() {
+		return c.Send(tr(locale, "batch.in_settings", tr(locale, "btn.save"), tr(locale, "btn.cancel")), settingsMenuFor(locale))
+	}
+	if doc.FileSize > batchMaxFileBytes {
+		return c.Send(tr(locale, "batch.too_large", batchMaxFileBytes>>10), mainMenu)
+	}
+
+	// The caption holds options for every image and the output
+	common, rest, err := parseInlineOptions(c.Message().Caption)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return c.Send(err.(*optionError).message(locale), mainMenu)
+	}
+	output := batchAlbum
+	switch strings.ToLower(strings.TrimSpace(rest)) {
+	case "", batchAlbum:
+	case batchZIP:
+		output = batchZIP
+	default:
+		return c.Send(tr(locale, "batch.invalid_caption", rest), mainMenu)
+	}
+
+	reader, err := c.Bot().File(&doc.File)
+	if err != nil {
+		log.Printf("Failed to download batch file of user %d: %v", senderID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to download the document")
+		return c.Send(tr(locale, "batch.error.download"), mainMenu)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, batchMaxFileBytes+1))
+	reader.Close()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to download the document")
+		return c.Send(tr(locale, "batch.error.download"), mainMenu)
+	}
+
+	var items []batchItem
+	if kind == "csv" {
+		items, err = parseBatchCSV(data)
+	} else {
+		items, err = parseBatchText(data)
+	}
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return c.Send(batchErrorMessage(locale, err), mainMenu)
+	}
+	if len(items) == 0 {
+		return c.Send(tr(locale, "batch.empty"), mainMenu)
+	}
+	if len(items) > batchMaxItems {
+		return c.Send(tr(locale, "batch.too_many", len(items), batchMaxItems), mainMenu)
+	}
+
+	if _, running := batchesRunning.LoadOrStore(senderID, true); running {
+		return c.Send(tr(locale, "batch.busy"), mainMenu)
+	}
+	defer batchesRunning.Delete(senderID)
+	// Every image takes a token; the first one is taken before the batch starts
+	if ok, wait := renderLimiter.Allow(ctx, telegramRequester(senderID), "telegram"); !ok {
+		return c.Send(tr(locale, "image.rate_limited", retrySeconds(wait)), mainMenu)
+	}
+
+	span.SetAttributes(attribute.Int("batch.items", len(items)), attribute.String("batch.output", output))
+	log.Printf("User %d (%s) started a batch of %d images (%s)", senderID, c.Sender().Username, len(items), output)
+	return runBatch(ctx, c, span, items, common, output)
+}
+
+// runBatch renders the items one by one, reporting progress, and sends the results
+func runBatch(ctx context.Context, c tele.Context, span trace.Span, items []batchItem, common inlineOptions, output string) error {
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	mainMenu := mainMenuFor(locale)
+	generator := currentGenerator()
+	outputAttr := attribute.String("image.output", "batch")
+
+	progress, err := c.Bot().Send(c.Recipient(), tr(locale, "batch.progress", 0, len(items)))
+	if err != nil {
+		log.Printf("Failed to send batch progress to user %d: %v", senderID, err)
+	}
+
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	saved := settingsRaw.(UserSettings)
+	var photo image.Image // Background photo, downloaded once for the whole batch
+	photoLoaded := false
+	var photos []*tele.Photo
+	var archive []batchFile
+	failed := 0
+	var limitedWait time.Duration // Set when the rate limit stopped the batch
+	for i, item := range items {
+		if i > 0 {
+			if ok, wait := renderLimiter.Allow(ctx, telegramRequester(senderID), "telegram"); !ok {
+				log.Printf("Batch of user %d stopped by the rate limit after %d of %d images", senderID, i, len(items))
+				limitedWait = wait
+				failed += len(items) - i
+				break
+			}
+		}
+		rendered, rerr := RenderedImage{}, &renderError{Type: "moderation_blocked", Key: "moderation.blocked"}
+		if moderateTelegram(ctx, c, item.Text, sourceBatch).Decision != decisionBlock {
+			settings := saved
+			common.apply(&settings)
+			item.Options.apply(&settings)
+			req := RenderRequest{Text: item.Text, Settings: settings}
+			if settings.BgMode == bgPhoto && settings.BgPhoto != "" {
+				if !photoLoaded {
+					// A photo that can no longer be downloaded falls back to the background color
+					if photo, err = loadBackgroundPhoto(ctx, c.Bot(), settings.BgPhoto); err != nil {
+						log.Printf("Using background color for the batch of user %d: %v", senderID, err)
+					}
+					photoLoaded = true
+				}
+				req.Photo = photo
+			}
+			rendered, rerr = renderImage(ctx, generator, req, outputAttr)
+		}
+		if rerr != nil {
+			log.Printf("Batch image %d of user %d failed: %v", item.Line, senderID, rerr)
+			span.RecordError(rerr)
+			failed++
+		} else if output == batchZIP {
+			data, err := renderedPNG(ctx, rendered)
+			if err != nil {
+				log.Printf("Batch image %d of user %d failed: %v", item.Line, senderID, err)
+				span.RecordError(err)
+				failed++
+			} else {
+				archive = append(archive, batchFile{Name: fmt.Sprintf("kbot-%03d.png", item.Line), Data: data})
+			}
+		} else {
+			photos = append(photos, batchPhoto(locale, item.Text, rendered))
+		}
+
+		if progress != nil && ((i+1)%batchProgressStep == 0 || i+1 == len(items)) {
+			c.Bot().Edit(progress, tr(locale, "batch.progress", i+1, len(items))) // Best effort
+		}
+	}
+	span.SetAttributes(attribute.Int("batch.failed", failed))
+	batchJobCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("batch.output", output)))
+
+	if err := sendBatch(c, photos, archive, output); err != nil {
+		log.Printf("Error sending batch to user %d: %v", senderID, err)
+		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "telegram_send_error"), outputAttr))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to send the batch to Telegram")
+		return c.Send(tr(locale, "batch.error.send"), mainMenu)
+	}
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d images failed", failed, len(items)))
+	}
+	done := tr(locale, "batch.done", len(items)-failed, failed)
+	if limitedWait > 0 {
+		span.AddEvent("Rate limited")
+		done += "\n" + tr(locale, "batch.rate_limited", retrySeconds(limitedWait))
+	}
+	return c.Send(done, mainMenu)
+}
+
+// batchFile is a PNG file of a ZIP archive
+type batchFile struct {
+	Name string
+	Data []byte
+}
+
+// sendBatch sends the photos as albums, or the files as a ZIP archive
+func sendBatch(c tele.Context, photos []*tele.Photo, files []batchFile, output string) error {
+	if output == batchZIP {
+		if len(files) == 0 {
+			return nil
+		}
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, f := range files {
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, Method: zip.Store, Modified: time.Now()}) // PNG is already compressed
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(f.Data); err != nil {
+				return err
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if buf.Len() > batchMaxZIPBytes {
+			return fmt.Errorf("archive of %d bytes exceeds the upload limit", buf.Len())
+		}
+		return c.Send(&tele.Document{File: tele.FromReader(&buf), FileName: "kbot-images.zip", MIME: "application/zip"})
+	}
+	for _, chunk := range batchAlbums(photos) {
+		if len(chunk) == 1 {
+			if err := c.Send(chunk[0]); err != nil {
+				return err
+			}
+			continue
+		}
+		album := make(tele.Album, 0, len(chunk))
+		for _, p := range chunk {
+			album = append(album, p)
+		}
+		if err := c.SendAlbum(album); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchAlbums splits the photos into albums of at most batchAlbumSize. A media group needs at least
+// two photos, so the last album takes one from the one before instead of being left with a single
+// photo; only a batch of one image gives a chunk of one, which is sent as a plain photo.
+func batchAlbums(photos []*tele.Photo) [][]*tele.Photo {
+	var albums [][]*tele.Photo
+	for start := 0; start < len(photos); start += batchAlbumSize {
+		albums = append(albums, photos[start:min(start+batchAlbumSize, len(photos))])
+	}
+	if n := len(albums); n > 1 && len(albums[n-1]) == 1 {
+		prev := albums[n-2]
+		albums[n-2], albums[n-1] = prev[:len(prev)-1], photos[len(photos)-2:]
+	}
+	return albums
+}
+
+// batchPhoto is a rendered image of an album, captioned like a single image
+func batchPhoto(locale, text string, rendered RenderedImage) *tele.Photo {
+	photo := &tele.Photo{File: tele.FromURL(rendered.URL), Caption: imageCaption(locale, text)}
+	if rendered.PNG != nil {
+		photo.File = tele.FromReader(bytes.NewReader(rendered.PNG))
+	}
+	return photo
+}
+
+// renderedMaxPNGBytes caps the download of a rendered image, Telegram's upload limit of a photo
+const renderedMaxPNGBytes = 10 << 20
+
+// renderedPNG returns the PNG of a rendered image, downloading it when the provider returned a URL
+func renderedPNG(ctx context.Context, rendered RenderedImage) ([]byte, error) {
+	if rendered.PNG != nil {
+		return rendered.PNG, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rendered.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: 20 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: status %d", rendered.URL, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, renderedMaxPNGBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > renderedMaxPNGBytes {
+		return nil, fmt.Errorf("downloading %s: image exceeds %d bytes", rendered.URL, renderedMaxPNGBytes)
+	}
+	return data, nil
+}
+
+// batchFileKind returns "txt" or "csv" for supported documents, by extension or MIME type
+func batchFileKind(doc *tele.Document) string {
+	switch strings.ToLower(filepath.Ext(doc.FileName)) {
+	case ".txt":
+		return "txt"
+	case ".csv":
+		return "csv"
+	}
+	switch doc.MIME {
+	case "text/plain":
+		return "txt"
+	case "text/csv":
+		return "csv"
+	}
+	return ""
+}
+
+// batchLineError is a line of a batch file that cannot be turned into an image
+type batchLineError struct {
+	Line   int
+	Key    string       // Catalog key of the reason, used when Option is nil
+	Option *optionError // Invalid option of a CSV column
+}
+
+func (e *batchLineError) Error() string {
+	if e.Option != nil {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Option)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Key)
+}
+
+// batchErrorMessage formats a parse error of a batch file for the user
+func batchErrorMessage(locale string, err error) string {
+	lerr, ok := err.(*batchLineError)
+	if !ok {
+		return tr(locale, "batch.error.parse", err.Error())
+	}
+	reason := tr(locale, lerr.Key, batchMaxTextRunes)
+	if lerr.Option != nil {
+		reason = lerr.Option.message(locale)
+	}
+	return tr(locale, "batch.invalid_line", lerr.Line, reason)
+}
+
+// batchText decodes a batch file as UTF-8 without a byte order mark
+func batchText(data []byte) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("the file is not UTF-8 text")
+	}
+	return string(data), nil
+}
+
+// parseBatchText reads one text per non-empty line; "\n" in a line is a line break
+func parseBatchText(data []byte) ([]batchItem, error) {
+	text, err := batchText(data)
+	if err != nil {
+		return nil, err
+	}
+	var items []batchItem
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		item := batchItem{Line: i + 1, Text: strings.ReplaceAll(line, `\n`, "\n")}
+		if utf8.RuneCountInString(item.Text) > batchMaxTextRunes {
+			return nil, &batchLineError{Line: item.Line, Key: "batch.text_too_long"}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseBatchCSV reads rows of text and options. The separator (comma, semicolon or tab) is taken
+// from the first line, as spreadsheets in many locales export semicolons.
+func parseBatchCSV(data []byte) ([]batchItem, error) {
+	text, err := batchText(data)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(strings.NewReader(text))
+	r.FieldsPerRecord = -1
+	r.Comma = csvSeparator(text)
+
+	columns := []string{"text", "tx_color", "bg_color"} // Without a header row
+	var items []batchItem
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && isBatchHeader(record) {
+			columns = record
+			continue
+		}
+		line, _ := r.FieldPos(0) // Quoted cells may span several lines
+		item := batchItem{Line: line}
+		for col, cell := range record {
+			if col >= len(columns) || strings.TrimSpace(cell) == "" {
+				continue // Extra columns are ignored; empty cells keep the settings
+			}
+			name := strings.ToLower(strings.TrimSpace(columns[col]))
+			if name == "text" {
+				item.Text = strings.TrimSpace(cell)
+				continue
+			}
+			option, oerr := resolveInlineOption(name, cell, name)
+			if oerr != nil {
+				return nil, &batchLineError{Line: line, Option: oerr}
+			}
+			item.Options = append(item.Options, option)
+		}
+		if item.Text == "" {
+			continue // Empty rows, or rows with options only
+		}
+		if utf8.RuneCountInString(item.Text) > batchMaxTextRunes {
+			return nil, &batchLineError{Line: line, Key: "batch.text_too_long"}
+		}
+		items = append(items, item)
+	}
+}
+
+// isBatchHeader reports whether a CSV record names its columns: "text" and inline option names
+func isBatchHeader(record []string) bool {
+	hasText := false
+	for _, cell := range record {
+		name := strings.ToLower(strings.TrimSpace(cell))
+		if name == "text" {
+			hasText = true
+			continue
+		}
+		if _, err := resolveInlineOption(name, "", name); err != nil && err.Key == "options.unknown" {
+			return false
+		}
+	}
+	return hasText
+}
+
+// csvSeparator picks the most frequent separator of the first line
+func csvSeparator(text string) rune {
+	first, _, _ := strings.Cut(text, "\n")
+	separator, count := ',', strings.Count(first, ",")
+	for _, candidate := range []rune{';', '\t'} {
+		if n := strings.Count(first, string(candidate)); n > count {
+			separator, count = candidate, n
+		}
+	}
+	return separator
+}
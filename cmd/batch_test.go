@@ -0,0 +1,173 @@
+// kbot-app/cmd/batch_test.go
+// This file contains the tests of batch file parsing, album splitting and the download of rendered images.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+func TestBatchAlbums(t *testing.T) {
+	tests := []struct {
+		photos int
+		want   []int // Sizes of the albums
+	}{
+		{0, nil},
+		{1, []int{1}},
+		{2, []int{2}},
+		{10, []int{10}},
+		{11, []int{9, 2}},
+		{12, []int{10, 2}},
+		{20, []int{10, 10}},
+		{21, []int{10, 9, 2}},
+		{50, []int{10, 10, 10, 10, 10}},
+	}
+	for _, tt := range tests {
+		photos := make([]*tele.Photo, tt.photos)
+		for i := range photos {
+			photos[i] = &tele.Photo{Caption: string(rune('a' + i))}
+		}
+		albums := batchAlbums(photos)
+
+		var sizes []int
+		var order []*tele.Photo
+		for _, album := range albums {
+			sizes = append(sizes, len(album))
+			order = append(order, album...)
+		}
+		if !reflect.DeepEqual(sizes, tt.want) {
+			t.Errorf("batchAlbums(%d photos) sizes = %v, want %v", tt.photos, sizes, tt.want)
+		}
+		if len(order) != len(photos) {
+			t.Errorf("batchAlbums(%d photos) holds %d photos", tt.photos, len(order))
+			continue
+		}
+		for i := range photos {
+			if order[i] != photos[i] {
+				t.Errorf("batchAlbums(%d photos) changed the order at photo %d", tt.photos, i)
+				break
+			}
+		}
+	}
+}
+
+func TestParseBatchText(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string // "line: text" followed by "setting=value" options
+		wantErr string   // Catalog key of a line error, or "error" for any other error
+	}{
+		{"lines", "first\nsecond\n", []string{"1: first", "2: second"}, ""},
+		{"blank lines keep the numbering", "\n  first  \n\n\r\nsecond", []string{"2: first", "5: second"}, ""},
+		{"escaped line break", `one\ntwo`, []string{"1: one\ntwo"}, ""},
+		{"byte order mark", "\ufeffHi", []string{"1: Hi"}, ""},
+		{"empty file", "\n\n", nil, ""},
+		{"not utf-8", "\xff\xfe", nil, "error"},
+		{"text too long", "ok\n" + string(bytes.Repeat([]byte("я"), batchMaxTextRunes+1)), nil, "batch.text_too_long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parseBatchText([]byte(tt.data))
+			checkBatchResult(t, items, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+func TestParseBatchCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string // "line: text" followed by "setting=value" options
+		wantErr string   // Catalog key of a line error or of the invalid option, or "error" for any other error
+	}{
+		{"text only", "Hello\nWorld\n", []string{"1: Hello", "2: World"}, ""},
+		{"text and colors", "Hi,red,#000\n", []string{"1: Hi tx_color=FF0000 bg_color=000"}, ""},
+		{"semicolons", "Hi;;blue\n", []string{"1: Hi bg_color=0000FF"}, ""},
+		{"header row", "size,text\nog,Hi\n,Bye\n", []string{"2: Hi size=og", "3: Bye"}, ""},
+		{"quoted cell over two lines", "\"a\nb\",red\nc\n", []string{"1: a\nb tx_color=FF0000", "3: c"}, ""},
+		{"extra columns and empty rows", "Hi,,,ignored\n,\n,red\n", []string{"1: Hi"}, ""},
+		{"option names without a text column are not a header", "fg,bg\n", nil, "options.invalid"},
+		{"invalid option", "text,fg\nHi,pinkish\n", nil, "options.invalid"},
+		{"text too long", string(bytes.Repeat([]byte("x"), batchMaxTextRunes+1)), nil, "batch.text_too_long"},
+		{"malformed quotes", "\"Hi\n", nil, "error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parseBatchCSV([]byte(tt.data))
+			checkBatchResult(t, items, err, tt.want, tt.wantErr)
+		})
+	}
+}
+
+// checkBatchResult compares the result of a batch parser with the wanted items or error
+func checkBatchResult(t *testing.T, items []batchItem, err error, want []string, wantErr string) {
+	t.Helper()
+	if wantErr != "" {
+		lerr, ok := err.(*batchLineError)
+		switch {
+		case err == nil:
+			t.Fatalf("items = %+v, want error %s", items, wantErr)
+		case wantErr == "error":
+			if ok {
+				t.Fatalf("error = %v, want an error that is not about a line", err)
+			}
+		case !ok:
+			t.Fatalf("error = %v, want %s", err, wantErr)
+		case lerr.Option != nil && lerr.Option.Key != wantErr, lerr.Option == nil && lerr.Key != wantErr:
+			t.Fatalf("error = %v, want %s", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		desc := fmt.Sprintf("%d: %s", item.Line, item.Text)
+		for _, option := range item.Options {
+			desc += " " + option.Field.Name + "=" + option.Value
+		}
+		got = append(got, desc)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("items = %q, want %q", got, want)
+	}
+}
+
+func TestRenderedPNG(t *testing.T) {
+	small := bytes.Repeat([]byte{1}, 1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.png":
+			w.Write(small)
+		case "/huge.png":
+			w.Write(make([]byte, renderedMaxPNGBytes+1))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	if data, err := renderedPNG(ctx, RenderedImage{PNG: small}); err != nil || !bytes.Equal(data, small) {
+		t.Errorf("renderedPNG of a local image = %d bytes, %v", len(data), err)
+	}
+	if data, err := renderedPNG(ctx, RenderedImage{URL: server.URL + "/small.png"}); err != nil || !bytes.Equal(data, small) {
+		t.Errorf("renderedPNG of a small download = %d bytes, %v", len(data), err)
+	}
+	if _, err := renderedPNG(ctx, RenderedImage{URL: server.URL + "/huge.png"}); err == nil {
+		t.Error("renderedPNG accepted a download over the limit")
+	}
+	if _, err := renderedPNG(ctx, RenderedImage{URL: server.URL + "/missing.png"}); err == nil {
+		t.Error("renderedPNG accepted a 404")
+	}
+}
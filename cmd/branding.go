@@ -0,0 +1,234 @@
+// kbot-app/cmd/branding.go
+// This file contains deployment-wide branding: the default colors of new users, an optional palette
+// that limits the colors users can choose, and an optional watermark drawn on generated images.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"slices"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Colors of new users when KBOT_DEFAULT_TEXT_COLOR and KBOT_DEFAULT_BG_COLOR are not set
+const (
+	builtinTextColor = "000000"
+	builtinBgColor   = "FFFFFF"
+)
+
+// Watermark placement
+const (
+	watermarkAlpha     = 178 // Opacity of the logo and the text, 70%
+	watermarkLogoShare = 0.2 // Largest share of the image width and height taken by the logo
+	watermarkMinMargin = 6   // Pixels between the watermark and the image edge, at least
+)
+
+// loadBranding reads the default colors, the palette and the watermark into cfg
+func loadBranding(cfg *Config) error {
+	for _, v := range []struct {
+		name string
+		dst  *string
+		def  string
+	}{{"KBOT_DEFAULT_TEXT_COLOR", &cfg.DefaultTextColor, builtinTextColor}, {"KBOT_DEFAULT_BG_COLOR", &cfg.DefaultBgColor, builtinBgColor}} {
+		raw := strings.TrimSpace(os.Getenv(v.name))
+		if raw == "" {
+			*v.dst = v.def
+			continue
+		}
+		hex, ok := parseColorValue(raw)
+		if !ok {
+			return fmt.Errorf("invalid %s %q: expected a hex color or a color name", v.name, raw)
+		}
+		*v.dst = canonicalColor(hex)
+	}
+	for _, raw := range envList("KBOT_PALETTE") {
+		hex, ok := parseColorValue(raw)
+		if !ok {
+			return fmt.Errorf("invalid KBOT_PALETTE entry %q: expected a hex color or a color name", raw)
+		}
+		cfg.Palette = append(cfg.Palette, canonicalColor(hex))
+	}
+	// New users must be able to keep their defaults
+	if !cfg.allowsColor(cfg.DefaultTextColor) || !cfg.allowsColor(cfg.DefaultBgColor) {
+		return fmt.Errorf("default colors #%s and #%s must be in KBOT_PALETTE", cfg.DefaultTextColor, cfg.DefaultBgColor)
+	}
+
+	cfg.Watermark = strings.TrimSpace(os.Getenv("KBOT_WATERMARK"))
+	if cfg.WatermarkLogo = strings.TrimSpace(os.Getenv("KBOT_WATERMARK_LOGO")); cfg.WatermarkLogo != "" {
+		data, err := os.ReadFile(cfg.WatermarkLogo)
+		if err != nil {
+			return fmt.Errorf("invalid KBOT_WATERMARK_LOGO: %w", err)
+		}
+		logo, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("invalid KBOT_WATERMARK_LOGO: decoding %s: %w", cfg.WatermarkLogo, err)
+		}
+		sum := sha256.Sum256(data)
+		cfg.watermarkLogo, cfg.watermarkLogoSum = logo, hex.EncodeToString(sum[:8])
+	}
+	return nil
+}
+
+// defaultUserSettings returns the settings of a user who has not changed anything
+func defaultUserSettings() UserSettings {
+	settings := UserSettings{TextColor: builtinTextColor, BgColor: builtinBgColor}
+	if cfg := currentConfig(); cfg != nil {
+		if cfg.DefaultTextColor != "" {
+			settings.TextColor = cfg.DefaultTextColor
+		}
+		if cfg.DefaultBgColor != "" {
+			settings.BgColor = cfg.DefaultBgColor
+		}
+	}
+	return settings
+}
+
+// canonicalColor expands a valid hex color to six upper-case digits, so that "fff" equals "FFFFFF"
+func canonicalColor(hex string) string {
+	hex = strings.ToUpper(strings.TrimPrefix(hex, "#"))
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	return hex
+}
+
+// allowsColor reports whether users may choose the hex color
+func (c *Config) allowsColor(hex string) bool {
+	if c == nil || len(c.Palette) == 0 {
+		return true
+	}
+	return slices.Contains(c.Palette, canonicalColor(hex))
+}
+
+// paletteList formats the palette for messages, e.g. "#000000, #FFFFFF"
+func paletteList() string {
+	return "#" + strings.Join(currentConfig().Palette, ", #")
+}
+
+// brandSettings replaces colors outside the palette, e.g. chosen before the palette was configured.
+// Text and background colors fall back to the defaults; the second gradient color and the outline, which
+// default to black, use the default text and background colors; shadow and box are dropped.
+func brandSettings(s UserSettings) UserSettings {
+	cfg := currentConfig()
+	if cfg == nil || len(cfg.Palette) == 0 {
+		return s
+	}
+	defaults := defaultUserSettings()
+	allowed := func(hex string) bool { return hex == "" || hex == "none" || cfg.allowsColor(hex) }
+	if !allowed(s.TextColor) {
+		s.TextColor = defaults.TextColor
+	}
+	if !allowed(s.BgColor) {
+		s.BgColor = defaults.BgColor
+	}
+	if !allowed(s.BgColor2) || (s.BgColor2 == "" && !cfg.allowsColor(defaultBgColor2)) {
+		s.BgColor2 = defaults.TextColor
+	}
+	if !allowed(s.OutlineColor) || (s.OutlineColor == "" && !cfg.allowsColor(defaultOutlineColor)) {
+		s.OutlineColor = defaults.BgColor
+	}
+	if !allowed(s.ShadowColor) {
+		s.ShadowColor = ""
+	}
+	if !allowed(s.BoxColor) {
+		s.BoxColor = ""
+	}
+	return s
+}
+
+// watermarkKey identifies the watermark in cache keys, so that images cached before /admin reload
+// read another logo file are not served with the old logo
+func watermarkKey() string {
+	cfg := currentConfig()
+	if cfg == nil {
+		return ""
+	}
+	return cfg.Watermark + "\x00" + cfg.watermarkLogoSum
+}
+
+// hasWatermark reports whether a watermark is configured
+func hasWatermark() bool {
+	cfg := currentConfig()
+	return cfg != nil && (cfg.Watermark != "" || cfg.watermarkLogo != nil)
+}
+
+// watermarkRendered draws the watermark on a rendered image; remote images are downloaded and returned as PNG
+func watermarkRendered(ctx context.Context, rendered RenderedImage) (RenderedImage, error) {
+	if !hasWatermark() {
+		return rendered, nil
+	}
+	data, err := renderedPNG(ctx, rendered)
+	if err != nil {
+		return rendered, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return rendered, err
+	}
+	img := image.NewRGBA(src.Bounds())
+	draw.Draw(img, img.Bounds(), src, src.Bounds().Min, draw.Src)
+	if err := applyWatermark(img); err != nil {
+		return rendered, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return rendered, err
+	}
+	return RenderedImage{PNG: buf.Bytes()}, nil
+}
+
+// applyWatermark draws the logo in the bottom right corner of img and the watermark text to its left
+func applyWatermark(img *image.RGBA) error {
+	if !hasWatermark() {
+		return nil
+	}
+	cfg := currentConfig()
+	b := img.Bounds()
+	margin := max(watermarkMinMargin, min(b.Dx(), b.Dy())/50)
+	opacity := image.NewUniform(color.Alpha{A: watermarkAlpha})
+	right, bottom := b.Max.X-margin, b.Max.Y-margin
+
+	if logo := cfg.watermarkLogo; logo != nil {
+		lb := logo.Bounds()
+		scale := math.Min(1, math.Min(float64(b.Dx())*watermarkLogoShare/float64(lb.Dx()), float64(b.Dy())*watermarkLogoShare/float64(lb.Dy())))
+		scaled := image.NewRGBA(image.Rect(0, 0, max(1, int(float64(lb.Dx())*scale)), max(1, int(float64(lb.Dy())*scale))))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), logo, lb, draw.Src, nil)
+		at := image.Rect(right-scaled.Rect.Dx(), bottom-scaled.Rect.Dy(), right, bottom)
+		draw.DrawMask(img, at, scaled, image.Point{}, opacity, image.Point{}, draw.Over)
+		right = at.Min.X - margin
+	}
+
+	if cfg.Watermark != "" {
+		face, err := localFace(math.Max(10, float64(min(b.Dx(), b.Dy()))/25))
+		if err != nil {
+			return err
+		}
+		defer face.Close()
+		alpha := uint8(watermarkAlpha)
+		dot := fixed.P(right-font.MeasureString(face, cfg.Watermark).Ceil(), bottom-face.Metrics().Descent.Ceil())
+		// Light text with a dark halo stays readable on any background
+		drawer := font.Drawer{Dst: img, Src: image.NewUniform(color.NRGBA{A: alpha / 2}), Face: face}
+		for _, d := range []fixed.Point26_6{fixed.P(-1, 0), fixed.P(1, 0), fixed.P(0, -1), fixed.P(0, 1)} {
+			drawer.Dot = dot.Add(d)
+			drawer.DrawString(cfg.Watermark)
+		}
+		drawer.Src = image.NewUniform(color.NRGBA{R: 255, G: 255, B: 255, A: alpha})
+		drawer.Dot = dot
+		drawer.DrawString(cfg.Watermark)
+	}
+	return nil
+}
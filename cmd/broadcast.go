@@ -0,0 +1,291 @@
+// kbot-app/cmd/broadcast.go
+// This file contains the admin-triggered broadcast of announcements to all known users.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const (
+	broadcastFileName     = "broadcast.json" // Progress of the current broadcast, used to resume after restart
+	broadcastSaveEvery    = 20               // Persist dry-run progress after this many recipients
+	broadcastDryRunFlag   = "--dry-run"
+	broadcastMaxFloodWait = time.Minute // Upper bound for a single flood-control pause
+)
+
+// broadcastJob is a broadcast in progress or the last finished one
+type broadcastJob struct {
+	ID         string    `json:"id"`
+	Text       string    `json:"text"`
+	DryRun     bool      `json:"dry_run"`
+	Recipients []int64   `json:"recipients"` // Snapshot of known users taken when the broadcast started
+	Next       int       `json:"next"`       // Index of the next recipient to process
+	Sending    bool      `json:"sending"`    // The message to Recipients[Next] may have been sent; it is skipped on resume
+	Delivered  int       `json:"delivered"`
+	Blocked    int       `json:"blocked"` // Users who blocked the bot or deleted their account
+	Failed     int       `json:"failed"`
+	StartedBy  int64     `json:"started_by"`
+	Locale     string    `json:"locale"` // Locale of the admin who started the broadcast, for the final report
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Cancelled  bool      `json:"cancelled"`
+}
+
+// done reports whether the job no longer needs processing
+func (j *broadcastJob) done() bool {
+	return !j.FinishedAt.IsZero()
+}
+
+// broadcaster guards the single broadcast that may run at a time
+var broadcaster = struct {
+	mu     sync.Mutex
+	job    *broadcastJob
+	cancel context.CancelFunc
+}{}
+
+// adminBroadcast handles "/admin broadcast [--dry-run] <text>", "/admin broadcast status" and "/admin broadcast cancel".
+// The text is taken from the raw message so that line breaks are preserved.
+func adminBroadcast(b tele.API, adminID int64, locale, rawText string) (string, error) {
+	idx := strings.Index(strings.ToLower(rawText), "broadcast")
+	body := strings.TrimSpace(rawText[idx+len("broadcast"):])
+
+	switch strings.ToLower(body) {
+	case "", "status":
+		broadcaster.mu.Lock()
+		defer broadcaster.mu.Unlock()
+		if broadcaster.job == nil {
+			return tr(locale, "broadcast.none"), nil
+		}
+		return broadcastStatus(locale, broadcaster.job), nil
+	case "cancel":
+		broadcaster.mu.Lock()
+		defer broadcaster.mu.Unlock()
+		if broadcaster.job == nil || broadcaster.job.done() {
+			return "", fmt.Errorf("no broadcast is running")
+		}
+		broadcaster.cancel()
+		return tr(locale, "broadcast.cancelling"), nil
+	}
+
+	dryRun := false
+	if strings.HasPrefix(body, broadcastDryRunFlag) {
+		dryRun = true
+		body = strings.TrimSpace(strings.TrimPrefix(body, broadcastDryRunFlag))
+	}
+	if body == "" {
+		return "", fmt.Errorf("usage: /admin broadcast [--dry-run] <text>")
+	}
+
+	recipients := knownUserIDs()
+	sort.Slice(recipients, func(i, k int) bool { return recipients[i] < recipients[k] })
+	job := &broadcastJob{
+		ID:         time.Now().UTC().Format("20060102T150405Z"),
+		Text:       body,
+		DryRun:     dryRun,
+		Recipients: recipients,
+		StartedBy:  adminID,
+		Locale:     locale,
+		StartedAt:  time.Now(),
+	}
+	if err := startBroadcast(b, job); err != nil {
+		return "", err
+	}
+	if dryRun {
+		return tr(locale, "broadcast.dry_run_started", len(recipients)), nil
+	}
+	return tr(locale, "broadcast.started", len(recipients)), nil
+}
+
+// startBroadcast runs job in the background unless another broadcast is running
+func startBroadcast(b tele.API, job *broadcastJob) error {
+	broadcaster.mu.Lock()
+	defer broadcaster.mu.Unlock()
+	if broadcaster.job != nil && !broadcaster.job.done() {
+		return fmt.Errorf("broadcast %s is still running", broadcaster.job.ID)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	broadcaster.job, broadcaster.cancel = job, cancel
+	saveBroadcastJob(job)
+	go runBroadcast(ctx, b, job)
+	return nil
+}
+
+// resumeBroadcast continues an unfinished broadcast saved before the last shutdown
+func resumeBroadcast(b tele.API) {
+	path := dataPath(broadcastFileName)
+	if path == "" {
+		return
+	}
+	var job broadcastJob
+	found, err := readJSONFile(path, &job)
+	if err != nil {
+		log.Printf("Failed to read saved broadcast: %v", err)
+		return
+	}
+	if !found {
+		return
+	}
+	if job.done() {
+		broadcaster.mu.Lock()
+		broadcaster.job = &job // Keep the last result available for /admin broadcast status
+		broadcaster.mu.Unlock()
+		return
+	}
+	if job.Sending {
+		// The bot stopped while sending to this recipient: the message may have arrived, and a
+		// resumed broadcast must not send it twice, so the recipient is counted as failed
+		log.Printf("Broadcast %s: skipping user %d, who may already have the announcement", job.ID, job.Recipients[job.Next])
+		job.Next++
+		job.Failed++
+		job.Sending = false
+	}
+	log.Printf("Resuming broadcast %s at recipient %d of %d", job.ID, job.Next, len(job.Recipients))
+	if err := startBroadcast(b, &job); err != nil {
+		log.Printf("Failed to resume broadcast %s: %v", job.ID, err)
+	}
+}
+
+// runBroadcast sends the job's text to the remaining recipients, respecting the configured rate
+func runBroadcast(ctx context.Context, b tele.API, job *broadcastJob) {
+	ctx, span := tracer.Start(ctx, "runBroadcast")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("broadcast.id", job.ID),
+		attribute.Bool("broadcast.dry_run", job.DryRun),
+		attribute.Int("broadcast.recipients", len(job.Recipients)),
+	)
+
+	ticker := time.NewTicker(time.Second / time.Duration(currentConfig().BroadcastRate))
+	defer ticker.Stop()
+
+	for {
+		broadcaster.mu.Lock()
+		if job.Next >= len(job.Recipients) {
+			broadcaster.mu.Unlock()
+			break
+		}
+		userID := job.Recipients[job.Next]
+		broadcaster.mu.Unlock()
+
+		status := "dry_run"
+		if !job.DryRun {
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			// Record the recipient before sending, so that a restart never sends it twice
+			broadcaster.mu.Lock()
+			job.Sending = true
+			saveBroadcastJob(job)
+			broadcaster.mu.Unlock()
+			status = sendBroadcastMessage(ctx, b, userID, job.Text)
+		}
+		broadcastMessageCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("broadcast.status", status)))
+
+		broadcaster.mu.Lock()
+		switch status {
+		case "delivered", "dry_run":
+			job.Delivered++
+		case "blocked":
+			job.Blocked++
+		default:
+			job.Failed++
+		}
+		job.Next++
+		job.Sending = false
+		if job.DryRun && job.Next%broadcastSaveEvery == 0 {
+			saveBroadcastJob(job) // A dry run sends nothing, so repeating a few recipients after a restart is harmless
+		}
+		broadcaster.mu.Unlock()
+	}
+
+	broadcaster.mu.Lock()
+	job.Cancelled = ctx.Err() != nil
+	job.FinishedAt = time.Now()
+	saveBroadcastJob(job)
+	report := broadcastStatus(job.Locale, job)
+	broadcaster.mu.Unlock()
+
+	if job.Cancelled {
+		span.SetStatus(codes.Error, "Broadcast cancelled")
+	}
+	log.Printf("Broadcast %s finished: delivered=%d blocked=%d failed=%d cancelled=%t",
+		job.ID, job.Delivered, job.Blocked, job.Failed, job.Cancelled)
+	if _, err := b.Send(&tele.User{ID: job.StartedBy}, report); err != nil {
+		log.Printf("Failed to report broadcast result to admin %d: %v", job.StartedBy, err)
+	}
+}
+
+// sendBroadcastMessage delivers text to one user and classifies the outcome
+// as "delivered", "blocked" or "failed". Flood-control errors are waited out and retried.
+func sendBroadcastMessage(ctx context.Context, b tele.API, userID int64, text string) string {
+	for {
+		_, err := b.Send(&tele.User{ID: userID}, text)
+		if err == nil {
+			return "delivered"
+		}
+
+		var flood tele.FloodError
+		if errors.As(err, &flood) {
+			wait := time.Duration(flood.RetryAfter) * time.Second
+			if wait <= 0 || wait > broadcastMaxFloodWait {
+				wait = broadcastMaxFloodWait
+			}
+			log.Printf("Broadcast hit flood control, waiting %s", wait)
+			select {
+			case <-ctx.Done():
+				return "failed"
+			case <-time.After(wait):
+			}
+			continue
+		}
+
+		var apiErr *tele.Error
+		if errors.Is(err, tele.ErrChatNotFound) || (errors.As(err, &apiErr) && apiErr.Code == 403) {
+			return "blocked"
+		}
+		log.Printf("Broadcast to user %d failed: %v", userID, err)
+		return "failed"
+	}
+}
+
+// saveBroadcastJob persists job progress; the caller must hold broadcaster.mu
+func saveBroadcastJob(job *broadcastJob) {
+	path := dataPath(broadcastFileName)
+	if path == "" {
+		return
+	}
+	if err := writeJSONFile(path, job); err != nil {
+		log.Printf("Failed to save broadcast progress: %v", err)
+	}
+}
+
+// broadcastStatus describes job progress; the caller must hold broadcaster.mu
+func broadcastStatus(locale string, job *broadcastJob) string {
+	state := tr(locale, "broadcast.state.running")
+	switch {
+	case job.Cancelled:
+		state = tr(locale, "broadcast.state.cancelled")
+	case job.done():
+		state = tr(locale, "broadcast.state.finished")
+	}
+	return tr(locale, "broadcast.status", job.ID, state, job.DryRun,
+		job.Next, len(job.Recipients), job.Delivered, job.Blocked, job.Failed)
+}
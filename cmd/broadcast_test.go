@@ -0,0 +1,148 @@
+// kbot-app/cmd/broadcast_test.go
+// This file contains the tests of broadcast delivery and of resuming a broadcast after a restart.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// fakeAPI is a tele.API whose Send is answered by send; other methods are not implemented
+type fakeAPI struct {
+	tele.API
+	send func(to tele.Recipient, what interface{}) error
+}
+
+func (f *fakeAPI) Send(to tele.Recipient, what interface{}, opts ...interface{}) (*tele.Message, error) {
+	if err := f.send(to, what); err != nil {
+		return nil, err
+	}
+	return &tele.Message{}, nil
+}
+
+func TestSendBroadcastMessage(t *testing.T) {
+	tests := []struct {
+		name  string
+		errs  []error // Answers to the successive attempts; nil delivers
+		want  string
+		tries int
+	}{
+		{"delivered", []error{nil}, "delivered", 1},
+		{"blocked by the user", []error{tele.ErrBlockedByUser}, "blocked", 1},
+		{"deactivated user", []error{tele.ErrUserIsDeactivated}, "blocked", 1},
+		{"chat not found", []error{tele.ErrChatNotFound}, "blocked", 1},
+		{"other forbidden error", []error{&tele.Error{Code: 403, Description: "Forbidden: bot can't initiate conversation"}}, "blocked", 1},
+		{"bad request", []error{&tele.Error{Code: 400, Description: "Bad Request: message is too long"}}, "failed", 1},
+		{"network error", []error{errors.New("connection reset")}, "failed", 1},
+		{"flood control is waited out", []error{tele.FloodError{RetryAfter: 1}, nil}, "delivered", 2},
+		{"blocked after flood control", []error{tele.FloodError{RetryAfter: 1}, tele.ErrBlockedByUser}, "blocked", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tries := 0
+			api := &fakeAPI{send: func(to tele.Recipient, what interface{}) error {
+				if to.Recipient() != "42" || what != "News" {
+					t.Errorf("sent %v to %s, want News to 42", what, to.Recipient())
+				}
+				tries++
+				return tt.errs[min(tries, len(tt.errs))-1]
+			}}
+			if got := sendBroadcastMessage(context.Background(), api, 42, "News"); got != tt.want {
+				t.Errorf("sendBroadcastMessage = %q, want %q", got, tt.want)
+			}
+			if tries != tt.tries {
+				t.Errorf("sent %d times, want %d", tries, tt.tries)
+			}
+		})
+	}
+}
+
+func TestSendBroadcastMessageCancelledDuringFloodWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	tries := 0
+	api := &fakeAPI{send: func(tele.Recipient, interface{}) error {
+		tries++
+		cancel()
+		return tele.FloodError{RetryAfter: 30}
+	}}
+	start := time.Now()
+	if got := sendBroadcastMessage(ctx, api, 42, "News"); got != "failed" {
+		t.Errorf("sendBroadcastMessage = %q, want failed", got)
+	}
+	if tries != 1 || time.Since(start) > 5*time.Second {
+		t.Errorf("sent %d times in %v, want one attempt and no wait", tries, time.Since(start))
+	}
+}
+
+func TestResumeBroadcast(t *testing.T) {
+	cfg := *currentConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.BroadcastRate = 30
+	useConfig(t, &cfg)
+	path := filepath.Join(cfg.DataDir, broadcastFileName)
+
+	// The bot stopped while sending to user 2: it may have the announcement already
+	const admin = 99
+	saved := broadcastJob{
+		ID: "test", Text: "News", Recipients: []int64{1, 2, 3, 4, 5}, Next: 1, Sending: true,
+		Delivered: 1, StartedBy: admin, Locale: "en", StartedAt: time.Now(),
+	}
+	if err := writeJSONFile(path, &saved); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var sent []int64
+	reported := make(chan string, 1)
+	api := &fakeAPI{send: func(to tele.Recipient, what interface{}) error {
+		userID, _ := strconv.ParseInt(to.Recipient(), 10, 64)
+		if userID == admin {
+			reported <- what.(string)
+			return nil
+		}
+		// Progress on disk names this recipient before the message goes out
+		var onDisk broadcastJob
+		if _, err := readJSONFile(path, &onDisk); err != nil {
+			t.Error(err)
+		} else if !onDisk.Sending || onDisk.Recipients[onDisk.Next] != userID {
+			t.Errorf("sending to %d while the saved progress is at recipient %d (sending %v)", userID, onDisk.Next, onDisk.Sending)
+		}
+		mu.Lock()
+		sent = append(sent, userID)
+		mu.Unlock()
+		if userID == 4 {
+			return tele.ErrBlockedByUser
+		}
+		return nil
+	}}
+
+	resumeBroadcast(api)
+	select {
+	case <-reported:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the broadcast did not finish")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int64{3, 4, 5}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent to %v, want %v", sent, want)
+	}
+	var final broadcastJob
+	if _, err := readJSONFile(path, &final); err != nil {
+		t.Fatal(err)
+	}
+	if !final.done() || final.Sending || final.Next != 5 || final.Delivered != 3 || final.Blocked != 1 || final.Failed != 1 {
+		t.Errorf("saved result = next %d, delivered %d, blocked %d, failed %d, sending %v, finished %v; want 5, 3, 1, 1, false, true",
+			final.Next, final.Delivered, final.Blocked, final.Failed, final.Sending, final.done())
+	}
+}
@@ -0,0 +1,312 @@
+// kbot-app/cmd/commands.go
+// This file contains the declarative command registry: handler registration, guards,
+// /help and the Telegram command menu are all derived from it.
+
+package cmd
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// commandScope says in which chats a command is offered in the Telegram command menu and /help
+type commandScope int
+
+const (
+	scopePrivate commandScope = 1 << iota // Private chats with the bot
+	scopeGroup                            // Group chats the bot was added to
+	scopeAdmin                            // Admins only (KBOT_ADMIN_IDS); hidden from everyone else
+)
+
+// commandRequirement is a precondition checked before the handler runs
+type commandRequirement int
+
+const (
+	requireNone     commandRequirement = iota
+	requireSettings                    // The user must be in settings mode
+)
+
+// commandArg describes one positional argument of a command
+type commandArg struct {
+	Name     string // Shown in usage, e.g. "hex"
+	Optional bool
+	Rest     bool // Takes all remaining words; must be the last argument
+}
+
+// botCommand describes a slash command. Its description is the catalog key "cmd.<Name>".
+type botCommand struct {
+	Name     string   // Command name without the leading slash
+	Aliases  []string // Extra names handled the same way; not shown in the Telegram menu
+	Args     []commandArg
+	Requires commandRequirement
+	Scopes   commandScope
+	Handler  tele.HandlerFunc
+}
+
+// invokedCommandKey is the context key under which the canonical command name is stored
+const invokedCommandKey = "kbot.command"
+
+// botCommands is the single list of commands; registration, /help and setMyCommands are derived from it
+var botCommands []botCommand
+
+func init() {
+	// Filled in init() because some handlers (e.g. /help) refer back to the list
+	botCommands = []botCommand{
+		{Name: "start", Args: []commandArg{{Name: "payload", Optional: true}}, Scopes: scopePrivate | scopeGroup, Handler: handleStart},
+		{Name: "help", Scopes: scopePrivate | scopeGroup, Handler: handleHelp},
+		{Name: "settings", Scopes: scopePrivate, Handler: handleSettingsEnter},
+		{Name: "tx_color", Aliases: []string{"text_color"}, Args: []commandArg{{Name: "hex", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "bg_color", Aliases: []string{"background_color"}, Args: []commandArg{{Name: "hex", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "align", Args: []commandArg{{Name: "left|center|right", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "line_spacing", Args: []commandArg{{Name: "multiplier", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "padding", Args: []commandArg{{Name: "px", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "max_width", Args: []commandArg{{Name: "px", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "size", Args: []commandArg{{Name: "preset|WxH|W:H|auto", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "font_size", Args: []commandArg{{Name: "px", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "bg_mode", Args: []commandArg{{Name: "solid|linear|radial|photo", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "bg_color2", Args: []commandArg{{Name: "hex", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "bg_angle", Args: []commandArg{{Name: "degrees", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "outline_color", Args: []commandArg{{Name: "hex", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "outline_width", Args: []commandArg{{Name: "px", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "shadow_color", Args: []commandArg{{Name: "hex|none", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "shadow_offset", Args: []commandArg{{Name: "dx,dy", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "shadow_blur", Args: []commandArg{{Name: "px", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "box_color", Args: []commandArg{{Name: "hex|none", Optional: true}},
+			Requires: requireSettings, Scopes: scopePrivate, Handler: handleSetSetting},
+		{Name: "save_settings", Aliases: []string{"save"}, Scopes: scopePrivate, Handler: handleSettingsSave},
+		{Name: "cancel_settings", Aliases: []string{"cancel"}, Scopes: scopePrivate, Handler: handleSettingsCancel},
+		{Name: "img", Args: []commandArg{{Name: "--option=value", Optional: true}, {Name: "text", Rest: true}},
+			Scopes: scopePrivate | scopeGroup, Handler: handleImg},
+		{Name: "animate", Args: []commandArg{{Name: "typewriter|fade|cycle", Optional: true}, {Name: "text", Rest: true}},
+			Scopes: scopePrivate | scopeGroup, Handler: handleAnimate},
+		{Name: "sticker", Args: []commandArg{{Name: "text", Rest: true}}, Scopes: scopePrivate | scopeGroup, Handler: handleSticker},
+		{Name: "sticker_pack", Scopes: scopePrivate | scopeGroup, Handler: handleStickerPack},
+		{Name: "export", Scopes: scopePrivate, Handler: handleExport},
+		{Name: "import", Args: []commandArg{{Name: "code", Optional: true, Rest: true}}, Scopes: scopePrivate, Handler: handleImport},
+		{Name: "share_style", Scopes: scopePrivate | scopeGroup, Handler: handleShareStyle},
+		{Name: "history", Scopes: scopePrivate, Handler: handleHistory},
+		{Name: "undo", Scopes: scopePrivate, Handler: handleUndo},
+		{Name: "recent", Scopes: scopePrivate, Handler: handleRecent},
+		{Name: "lang", Args: []commandArg{{Name: "code|auto", Optional: true}}, Scopes: scopePrivate | scopeGroup, Handler: handleLang},
+		{Name: "admin", Args: []commandArg{{Name: "subcommand", Optional: true}, {Name: "args", Optional: true, Rest: true}},
+			Scopes: scopeAdmin, Handler: handleAdmin},
+	}
+}
+
+// usage returns the usage line of the command, e.g. "/tx_color [hex]"
+func (cmd botCommand) usage() string {
+	parts := []string{"/" + cmd.Name}
+	for _, arg := range cmd.Args {
+		name := arg.Name
+		if arg.Rest {
+			name += "..."
+		}
+		if arg.Optional {
+			parts = append(parts, "["+name+"]")
+		} else {
+			parts = append(parts, "<"+name+">")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkArgs validates the number of arguments against the schema
+func (cmd botCommand) checkArgs(args []string) bool {
+	required, rest := 0, false
+	for _, arg := range cmd.Args {
+		if !arg.Optional {
+			required++
+		}
+		rest = rest || arg.Rest
+	}
+	if len(args) < required {
+		return false
+	}
+	return rest || len(args) <= len(cmd.Args)
+}
+
+// visibleTo reports whether the command is offered to the user
+func (cmd botCommand) visibleTo(userID int64) bool {
+	return cmd.Scopes&scopeAdmin == 0 || isAdmin(userID)
+}
+
+// wrap applies the declared guards before calling the handler
+func (cmd botCommand) wrap() tele.HandlerFunc {
+	return func(c tele.Context) error {
+		// Admin commands do not exist for other users: their message is dropped without an answer,
+		// neither drawn nor taken as a settings value
+		if !cmd.visibleTo(c.Sender().ID) {
+			rejectCommand(c, cmd, "not_admin")
+			return nil
+		}
+
+		locale := userLocale(c)
+		if cmd.Requires == requireSettings && !sessions.Get(c.Sender().ID).State.InSettings() {
+			rejectCommand(c, cmd, "not_in_settings_mode")
+			return c.Send(tr(locale, "settings.only_in_settings_mode", tr(locale, "btn.settings")), mainMenuFor(locale))
+		}
+		if !cmd.checkArgs(c.Args()) {
+			rejectCommand(c, cmd, "invalid_args")
+			markup := mainMenuFor(locale)
+			if sessions.Get(c.Sender().ID).State.InSettings() {
+				markup = settingsMenuFor(locale)
+			}
+			return c.Send(tr(locale, "cmd.usage", cmd.usage()), markup)
+		}
+
+		c.Set(invokedCommandKey, cmd.Name)
+		return cmd.Handler(c)
+	}
+}
+
+// commandUsage returns the usage line of a registered command
+func commandUsage(name string) string {
+	for _, cmd := range botCommands {
+		if cmd.Name == name {
+			return cmd.usage()
+		}
+	}
+	return "/" + name
+}
+
+// rejectCommand records a command stopped by a registry guard
+func rejectCommand(c tele.Context, cmd botCommand, reason string) {
+	log.Printf("Command /%s from user %d (%s) rejected: %s", cmd.Name, c.Sender().ID, c.Sender().Username, reason)
+	commandRejectedCounter.Add(context.Background(), 1, metric.WithAttributes(
+		attribute.String("command.name", cmd.Name),
+		attribute.String("command.reason", reason),
+	))
+}
+
+// invokedCommand returns the canonical name of the command being handled, even if an alias was used
+func invokedCommand(c tele.Context) string {
+	name, _ := c.Get(invokedCommandKey).(string)
+	return name
+}
+
+// registerCommands registers every command and its aliases with the bot
+func registerCommands(b *tele.Bot) {
+	for _, cmd := range botCommands {
+		handler := cmd.wrap()
+		b.Handle("/"+cmd.Name, handler)
+		for _, alias := range cmd.Aliases {
+			b.Handle("/"+alias, handler)
+		}
+	}
+}
+
+// handleHelp handles /help: lists the commands available to the user, generated from the registry
+func handleHelp(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	_, span := tracer.Start(context.Background(), "handleHelp",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	locale := userLocale(c)
+	scope := scopePrivate
+	if c.Chat().Type != tele.ChatPrivate {
+		scope = scopeGroup
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(locale, "help.header"))
+	for _, cmd := range botCommands {
+		if !cmd.visibleTo(c.Sender().ID) || (cmd.Scopes&scope == 0 && cmd.Scopes&scopeAdmin == 0) {
+			continue
+		}
+		sb.WriteString("\n\n")
+		sb.WriteString(cmd.usage())
+		sb.WriteString(" - ")
+		sb.WriteString(tr(locale, "cmd."+cmd.Name))
+		if len(cmd.Aliases) > 0 {
+			sb.WriteString("\n")
+			sb.WriteString(tr(locale, "help.aliases", "/"+strings.Join(cmd.Aliases, ", /")))
+		}
+	}
+
+	markup := mainMenuFor(locale)
+	if sessions.Get(c.Sender().ID).State.InSettings() {
+		markup = settingsMenuFor(locale)
+	}
+	return c.Send(sb.String(), markup)
+}
+
+// commandMenu is the list of commands shown in one Telegram command scope
+type commandMenu struct {
+	scope    tele.CommandScope
+	commands []tele.Command
+}
+
+// menuCommands returns the menu entries, in the given locale, for commands offered in any of scopes
+func menuCommands(locale string, scopes commandScope) []tele.Command {
+	var commands []tele.Command
+	for _, cmd := range botCommands {
+		if cmd.Scopes&scopes != 0 {
+			commands = append(commands, tele.Command{Text: cmd.Name, Description: tr(locale, "cmd."+cmd.Name)})
+		}
+	}
+	return commands
+}
+
+// publishCommands sends the command menu to Telegram (setMyCommands) for every locale
+// and scope: all private chats, all group chats, and each admin's private chat.
+func publishCommands(b *tele.Bot) {
+	// An empty language code is the fallback for users whose language has no catalog
+	languages := append([]string{""}, availableLocales()...)
+	published := 0
+	for _, lang := range languages {
+		locale := lang
+		if locale == "" {
+			locale = defaultLocale
+		}
+
+		menus := []commandMenu{
+			{tele.CommandScope{Type: tele.CommandScopeAllPrivateChats}, menuCommands(locale, scopePrivate)},
+			{tele.CommandScope{Type: tele.CommandScopeAllGroupChats}, menuCommands(locale, scopeGroup)},
+		}
+		for _, adminID := range currentConfig().AdminIDs {
+			// The private chat of a user has the same ID as the user
+			menus = append(menus, commandMenu{
+				tele.CommandScope{Type: tele.CommandScopeChat, ChatID: adminID},
+				menuCommands(locale, scopePrivate|scopeAdmin),
+			})
+		}
+
+		for _, menu := range menus {
+			if err := b.SetCommands(menu.commands, menu.scope, lang); err != nil {
+				log.Printf("Failed to publish commands (scope %s, chat %d, language %q): %v", menu.scope.Type, menu.scope.ChatID, lang, err)
+				continue
+			}
+			published++
+		}
+	}
+	log.Printf("Command menu published (%d scope/language combinations).", published)
+}
@@ -0,0 +1,230 @@
+// kbot-app/cmd/config.go
+// This file contains the runtime configuration of the bot, read from environment variables.
+
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Config holds the tunable settings of the bot
+type Config struct {
+	SettingsTTL           time.Duration // KBOT_SETTINGS_TTL: idle time after which settings mode is closed (0 disables)
+	InputTTL              time.Duration // KBOT_INPUT_TTL: idle time after which a pending color prompt is dropped (0 disables)
+	NotifyOnSessionExpiry bool          // KBOT_SESSION_EXPIRY_NOTIFY: tell users when their settings session expired
+	AdminIDs              []int64       // KBOT_ADMIN_IDS: comma-separated Telegram user IDs allowed to use /admin
+	Allow                 accessRules   // KBOT_ALLOW_USER_IDS, KBOT_ALLOW_USERNAMES, KBOT_ALLOW_CHAT_IDS: if set, only these may use the bot
+	Deny                  accessRules   // KBOT_DENY_USER_IDS, KBOT_DENY_USERNAMES, KBOT_DENY_CHAT_IDS: never served
+	DataDir               string        // KBOT_DATA_DIR: directory for persisted state; empty keeps everything in memory
+	BroadcastRate         int           // KBOT_BROADCAST_RATE: broadcast messages per second (Telegram allows ~30)
+	Renderer              string        // KBOT_RENDERER: image generator, "imgbun" (default) or "local"
+	AnimationFormat       string        // KBOT_ANIMATION_FORMAT: /animate output, "gif" (default) or "mp4" (needs ffmpeg)
+	FontPaths             []string      // KBOT_FONT_PATHS: comma-separated TTF/OTF/TTC files tried after the built-in font
+	RenderRate            int           // KBOT_RENDER_RATE: images per minute per Telegram user or HTTP client (0 disables the limit)
+	RenderCacheMB         int           // KBOT_RENDER_CACHE_MB: memory for recently rendered images (0 disables the cache)
+	HTTPAddr              string        // KBOT_HTTP_ADDR: listen address of the HTTP render API, e.g. ":8080"; empty disables it (read at startup)
+	HTTPToken             string        // KBOT_HTTP_TOKEN: bearer token required by the HTTP render API; empty allows every client
+	HTTPTrustedProxies    proxyList     // KBOT_HTTP_TRUSTED_PROXIES: comma-separated proxy IPs or CIDRs whose X-Forwarded-For is believed
+	RecentImages          int           // KBOT_RECENT_IMAGES: generated images remembered per user for /recent (0 disables /recent)
+	RecentDays            int           // KBOT_RECENT_DAYS: days after which a generated image is forgotten (0 keeps them)
+	DefaultTextColor      string        // KBOT_DEFAULT_TEXT_COLOR: text color of new users (hex or name)
+	DefaultBgColor        string        // KBOT_DEFAULT_BG_COLOR: background color of new users (hex or name)
+	Palette               []string      // KBOT_PALETTE: comma-separated colors users may choose; empty allows any color
+	Watermark             string        // KBOT_WATERMARK: text drawn in the corner of every generated image
+	WatermarkLogo         string        // KBOT_WATERMARK_LOGO: PNG or JPEG file drawn in the corner of every generated image
+	ModerationWords       []string      // KBOT_MODERATION_WORDS: comma-separated words and phrases refused (or flagged) before drawing
+	ModerationWordsFile   string        // KBOT_MODERATION_WORDS_FILE: file with one word or phrase per line, added to the list above
+	ModerationAction      string        // KBOT_MODERATION_ACTION: decision on a word list match, "block" (default) or "flag"
+	ModerationURL         string        // KBOT_MODERATION_URL: moderation webhook asked about every text; empty disables it
+	ModerationToken       string        // KBOT_MODERATION_TOKEN: bearer token sent to the moderation webhook
+	ModerationTimeout     time.Duration // KBOT_MODERATION_TIMEOUT: time the webhook has to answer
+	ModerationOnError     string        // KBOT_MODERATION_ON_ERROR: decision when the webhook fails, "allow" (default), "flag" or "block"
+	ModerationNotify      bool          // KBOT_MODERATION_NOTIFY: send flagged texts to the admins
+
+	watermarkLogo    image.Image // Decoded WatermarkLogo
+	watermarkLogoSum string      // Hash of the WatermarkLogo file, for cache keys
+	moderators       []Moderator // Word list and webhook, in the order they run
+}
+
+// appConfig is the active configuration; replaced atomically when the configuration is reloaded
+var appConfig atomic.Pointer[Config]
+
+// currentConfig returns the active configuration
+func currentConfig() *Config {
+	return appConfig.Load()
+}
+
+// loadConfig reads the configuration from the environment, applying defaults for unset variables
+func loadConfig() (*Config, error) {
+	cfg := &Config{}
+	var err error
+
+	if cfg.SettingsTTL, err = envDuration("KBOT_SETTINGS_TTL", 30*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.InputTTL, err = envDuration("KBOT_INPUT_TTL", 5*time.Minute); err != nil {
+		return nil, err
+	}
+	if cfg.NotifyOnSessionExpiry, err = envBool("KBOT_SESSION_EXPIRY_NOTIFY", true); err != nil {
+		return nil, err
+	}
+	if cfg.AdminIDs, err = envInt64List("KBOT_ADMIN_IDS"); err != nil {
+		return nil, err
+	}
+	if cfg.Allow, err = envAccessRules("KBOT_ALLOW"); err != nil {
+		return nil, err
+	}
+	if cfg.Deny, err = envAccessRules("KBOT_DENY"); err != nil {
+		return nil, err
+	}
+	cfg.DataDir = os.Getenv("KBOT_DATA_DIR")
+	if cfg.BroadcastRate, err = envInt("KBOT_BROADCAST_RATE", 25, 1, 30); err != nil {
+		return nil, err
+	}
+	cfg.Renderer = strings.ToLower(strings.TrimSpace(os.Getenv("KBOT_RENDERER")))
+	if cfg.Renderer == "" {
+		cfg.Renderer = rendererImgbun
+	}
+	if _, ok := imageGenerators[cfg.Renderer]; !ok {
+		return nil, fmt.Errorf("invalid KBOT_RENDERER %q: expected %s or %s", cfg.Renderer, rendererImgbun, rendererLocal)
+	}
+	if cfg.Renderer == rendererImgbun && ImgbunAPIKey == "" {
+		return nil, fmt.Errorf("IMGBUN_API_KEY environment variable not set (required by the %s renderer)", rendererImgbun)
+	}
+	cfg.AnimationFormat = strings.ToLower(strings.TrimSpace(os.Getenv("KBOT_ANIMATION_FORMAT")))
+	switch cfg.AnimationFormat {
+	case "":
+		cfg.AnimationFormat = animFormatGIF
+	case animFormatGIF:
+	case animFormatMP4:
+		if _, err := exec.LookPath("ffmpeg"); err != nil {
+			return nil, fmt.Errorf("KBOT_ANIMATION_FORMAT=%s requires ffmpeg in PATH", animFormatMP4)
+		}
+	default:
+		return nil, fmt.Errorf("invalid KBOT_ANIMATION_FORMAT %q: expected %s or %s", cfg.AnimationFormat, animFormatGIF, animFormatMP4)
+	}
+	cfg.FontPaths = envList("KBOT_FONT_PATHS")
+	for _, path := range cfg.FontPaths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("invalid KBOT_FONT_PATHS entry: %w", err)
+		}
+	}
+	if cfg.RenderRate, err = envInt("KBOT_RENDER_RATE", 20, 0, 600); err != nil {
+		return nil, err
+	}
+	if cfg.RenderCacheMB, err = envInt("KBOT_RENDER_CACHE_MB", 32, 0, 1024); err != nil {
+		return nil, err
+	}
+	cfg.HTTPAddr = strings.TrimSpace(os.Getenv("KBOT_HTTP_ADDR"))
+	cfg.HTTPToken = os.Getenv("KBOT_HTTP_TOKEN")
+	if cfg.HTTPTrustedProxies, err = parseProxyList(envList("KBOT_HTTP_TRUSTED_PROXIES")); err != nil {
+		return nil, err
+	}
+	if cfg.RecentImages, err = envInt("KBOT_RECENT_IMAGES", 20, 0, 100); err != nil {
+		return nil, err
+	}
+	if cfg.RecentDays, err = envInt("KBOT_RECENT_DAYS", 30, 0, 365); err != nil {
+		return nil, err
+	}
+	if err := loadBranding(cfg); err != nil {
+		return nil, err
+	}
+	if err := loadModeration(cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// IsAdmin reports whether the user ID is in the admin allowlist
+func (c *Config) IsAdmin(userID int64) bool {
+	for _, id := range c.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// envDuration parses a duration variable such as "30m" or "1h30m"
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return def, nil
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q: expected a non-negative duration like 30m", name, raw)
+	}
+	return value, nil
+}
+
+// envInt parses an integer variable and checks that it lies within [min, max]
+func envInt(name string, def, min, max int) (int, error) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return def, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < min || value > max {
+		return 0, fmt.Errorf("invalid %s %q: expected an integer between %d and %d", name, raw, min, max)
+	}
+	return value, nil
+}
+
+// envBool parses a boolean variable such as "true", "0" or "false"
+func envBool(name string, def bool) (bool, error) {
+	raw, ok := os.LookupEnv(name)
+	if !ok || raw == "" {
+		return def, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: expected true or false", name, raw)
+	}
+	return value, nil
+}
+
+// envInt64List parses a comma-separated list of integers such as "123,456"
+func envInt64List(name string) ([]int64, error) {
+	var values []int64
+	for _, item := range envList(name) {
+		value, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: expected an integer ID", name, item)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// envList splits a comma-separated variable into trimmed, non-empty items
+func envList(name string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envAccessRules reads <prefix>_USER_IDS, <prefix>_USERNAMES and <prefix>_CHAT_IDS into a rule set
+func envAccessRules(prefix string) (accessRules, error) {
+	userIDs, err := envInt64List(prefix + "_USER_IDS")
+	if err != nil {
+		return accessRules{}, err
+	}
+	chatIDs, err := envInt64List(prefix + "_CHAT_IDS")
+	if err != nil {
+		return accessRules{}, err
+	}
+	return newAccessRules(userIDs, envList(prefix+"_USERNAMES"), chatIDs), nil
+}
@@ -0,0 +1,245 @@
+// kbot-app/cmd/effects.go
+// This file contains text effects of the local renderer: outline, drop shadow and a box behind the text.
+
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Effect limits accepted from users
+const (
+	maxOutlineWidth = 10
+	maxShadowOffset = 30
+	maxShadowBlur   = 20
+	effectNone      = "none" // Disables an optional color (shadow, box)
+)
+
+// Effect defaults used while a setting is unset (empty)
+const (
+	defaultOutlineColor = "000000"
+	defaultShadowOffset = 4
+	boxOpacity          = 0xcc // The box lets a little of the background through
+	boxMarginFrac       = 0.5  // Part of the padding left between the box and the image edge
+)
+
+// textEffects is UserSettings' effects with defaults applied
+type textEffects struct {
+	OutlineColor color.RGBA
+	OutlineWidth int // 0 disables the outline
+	Shadow       bool
+	ShadowColor  color.RGBA
+	ShadowOffset image.Point
+	ShadowBlur   int
+	Box          bool
+	BoxColor     color.RGBA
+}
+
+// effects resolves the effect settings, replacing unset values with defaults
+func (s UserSettings) effects() (textEffects, error) {
+	fx := textEffects{ShadowOffset: image.Pt(defaultShadowOffset, defaultShadowOffset)}
+	var err error
+	outlineColor := s.OutlineColor
+	if outlineColor == "" {
+		outlineColor = defaultOutlineColor
+	}
+	if fx.OutlineColor, err = parseHexColor(outlineColor); err != nil {
+		return fx, err
+	}
+	fx.OutlineWidth, _ = strconv.Atoi(s.OutlineWidth)
+
+	if s.ShadowColor != "" && s.ShadowColor != effectNone {
+		fx.Shadow = true
+		if fx.ShadowColor, err = parseHexColor(s.ShadowColor); err != nil {
+			return fx, err
+		}
+	}
+	if dx, dy, ok := parseOffset(s.ShadowOffset); ok {
+		fx.ShadowOffset = image.Pt(dx, dy)
+	}
+	fx.ShadowBlur, _ = strconv.Atoi(s.ShadowBlur)
+
+	if s.BoxColor != "" && s.BoxColor != effectNone {
+		fx.Box = true
+		if fx.BoxColor, err = parseHexColor(s.BoxColor); err != nil {
+			return fx, err
+		}
+		fx.BoxColor.A = boxOpacity
+	}
+	return fx, nil
+}
+
+// enabled reports whether any effect is drawn
+func (fx textEffects) enabled() bool {
+	return fx.OutlineWidth > 0 || fx.Shadow || fx.Box
+}
+
+// effectsSummary describes the effect settings for the settings mode greeting
+func effectsSummary(s UserSettings) string {
+	fx, err := s.effects()
+	if err != nil || !fx.enabled() {
+		return effectNone
+	}
+	var parts []string
+	if fx.OutlineWidth > 0 {
+		parts = append(parts, fmt.Sprintf("outline %dpx", fx.OutlineWidth))
+	}
+	if fx.Shadow {
+		parts = append(parts, fmt.Sprintf("shadow %d,%d blur %d", fx.ShadowOffset.X, fx.ShadowOffset.Y, fx.ShadowBlur))
+	}
+	if fx.Box {
+		parts = append(parts, "box #"+s.BoxColor)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// parseOptionalColorValue accepts a hex color or "none"
+func parseOptionalColorValue(raw string) (string, bool) {
+	if strings.EqualFold(strings.TrimSpace(raw), effectNone) {
+		return effectNone, true
+	}
+	return parseColorValue(raw)
+}
+
+// parseOffsetValue accepts a shadow offset "dx,dy" in pixels
+func parseOffsetValue(raw string) (string, bool) {
+	dx, dy, ok := parseOffset(raw)
+	if !ok || abs(dx) > maxShadowOffset || abs(dy) > maxShadowOffset {
+		return raw, false
+	}
+	return fmt.Sprintf("%d,%d", dx, dy), true
+}
+
+// parseOffset splits "dx,dy" into two integers
+func parseOffset(raw string) (int, int, bool) {
+	parts := strings.Split(strings.TrimSpace(raw), ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	dx, errX := strconv.Atoi(strings.TrimSpace(parts[0]))
+	dy, errY := strconv.Atoi(strings.TrimSpace(parts[1]))
+	return dx, dy, errX == nil && errY == nil
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// drawText composes the text mask onto dst with the effects, from back to front:
+// box, shadow, outline and the text itself. textRect is the text block and padding the layout padding around it.
+func drawText(dst *image.RGBA, mask *image.Alpha, textColor color.RGBA, fx textEffects, textRect image.Rectangle, padding int) {
+	bounds := dst.Bounds()
+	if fx.Box {
+		margin := int(float64(padding) * boxMarginFrac)
+		box := textRect.Inset(-(padding - margin)).Intersect(bounds)
+		draw.Draw(dst, box, image.NewUniform(fx.BoxColor), image.Point{}, draw.Over)
+	}
+
+	// The shadow is cast by everything drawn in front of it, outline included
+	shape := mask
+	if fx.OutlineWidth > 0 {
+		shape = dilateMask(mask, fx.OutlineWidth)
+	}
+	if fx.Shadow {
+		shadow := shiftMask(shape, fx.ShadowOffset)
+		if fx.ShadowBlur > 0 {
+			shadow = blurMask(shadow, fx.ShadowBlur)
+		}
+		draw.DrawMask(dst, bounds, image.NewUniform(fx.ShadowColor), image.Point{}, shadow, image.Point{}, draw.Over)
+	}
+	if fx.OutlineWidth > 0 {
+		draw.DrawMask(dst, bounds, image.NewUniform(fx.OutlineColor), image.Point{}, shape, image.Point{}, draw.Over)
+	}
+	draw.DrawMask(dst, bounds, image.NewUniform(textColor), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// dilateMask grows the mask by radius pixels in every direction (a disk-shaped maximum filter)
+func dilateMask(m *image.Alpha, radius int) *image.Alpha {
+	b := m.Bounds()
+	out := image.NewAlpha(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var maxA uint8
+			for dy := -radius; dy <= radius && maxA < 0xff; dy++ {
+				if y+dy < b.Min.Y || y+dy >= b.Max.Y {
+					continue
+				}
+				half := int(math.Sqrt(float64(radius*radius - dy*dy)))
+				row := m.Pix[(y+dy-b.Min.Y)*m.Stride:]
+				for dx := -half; dx <= half; dx++ {
+					if x+dx < b.Min.X || x+dx >= b.Max.X {
+						continue
+					}
+					if a := row[x+dx-b.Min.X]; a > maxA {
+						maxA = a
+					}
+				}
+			}
+			out.Pix[(y-b.Min.Y)*out.Stride+(x-b.Min.X)] = maxA
+		}
+	}
+	return out
+}
+
+// shiftMask moves the mask by offset; parts moved outside the bounds are dropped
+func shiftMask(m *image.Alpha, offset image.Point) *image.Alpha {
+	out := image.NewAlpha(m.Bounds())
+	draw.Draw(out, m.Bounds().Add(offset), m, m.Bounds().Min, draw.Src)
+	return out
+}
+
+// blurMask approximates a Gaussian blur with three passes of a box blur in each direction
+func blurMask(m *image.Alpha, radius int) *image.Alpha {
+	b := m.Bounds()
+	w, h := b.Dx(), b.Dy()
+	buf := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			buf[y*w+x] = float64(m.Pix[y*m.Stride+x])
+		}
+	}
+	tmp := make([]float64, w*h)
+	for pass := 0; pass < 3; pass++ {
+		boxBlur(buf, tmp, h, w, radius, 1, w) // Rows
+		boxBlur(tmp, buf, w, h, radius, w, 1) // Columns
+	}
+	out := image.NewAlpha(b)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Pix[y*out.Stride+x] = uint8(math.Round(math.Min(255, buf[y*w+x])))
+		}
+	}
+	return out
+}
+
+// boxBlur averages src into dst along lines of length n; there are count lines, step is the
+// distance between neighbours on a line and lineStep the distance between lines.
+// Pixels outside the image count as transparent.
+func boxBlur(src, dst []float64, count, n, radius, step, lineStep int) {
+	window := float64(2*radius + 1)
+	for line := 0; line < count; line++ {
+		base := line * lineStep
+		sum := 0.0
+		for i := 0; i < radius && i < n; i++ {
+			sum += src[base+i*step]
+		}
+		for i := 0; i < n; i++ {
+			if j := i + radius; j < n {
+				sum += src[base+j*step]
+			}
+			if j := i - radius - 1; j >= 0 {
+				sum -= src[base+j*step]
+			}
+			dst[base+i*step] = sum / window
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// kbot-app/cmd/effects_test.go
+// This file contains the golden-image tests of the text effects. After an intended change of the
+// drawing, regenerate the images in testdata with: go test ./cmd -run TestDrawText -update
+
+package cmd
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden images in testdata")
+
+// goldenTolerance is the largest channel difference accepted, for floating-point differences between platforms
+const goldenTolerance = 2
+
+// textMask rasterizes text with the built-in font, like the local renderer does
+func textMask(t *testing.T, size image.Point, text string) (*image.Alpha, image.Rectangle) {
+	t.Helper()
+	face, err := localFace(40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+	mask := image.NewAlpha(image.Rectangle{Max: size})
+	drawer := &font.Drawer{Dst: mask, Src: image.Opaque, Face: face, Dot: fixed.P(24, 56)}
+	bounds, _ := drawer.BoundString(text)
+	drawer.DrawString(text)
+	return mask, image.Rect(bounds.Min.X.Floor(), bounds.Min.Y.Floor(), bounds.Max.X.Ceil(), bounds.Max.Y.Ceil())
+}
+
+// checkGolden compares img with testdata/<name>.png, or rewrites the file with -update
+func checkGolden(t *testing.T, name string, img image.Image) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".png")
+	if *updateGolden {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll("testdata", 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("%v (run the test with -update to create it)", err)
+	}
+	defer file.Close()
+	want, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compareImages(img, want); err != nil {
+		t.Errorf("%s: %v (run the test with -update if the change is intended)", path, err)
+	}
+}
+
+// compareImages reports the first pixel that differs by more than goldenTolerance
+func compareImages(got, want image.Image) error {
+	if got.Bounds() != want.Bounds() {
+		return fmt.Errorf("bounds %v, want %v", got.Bounds(), want.Bounds())
+	}
+	b := got.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			g := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+			w := color.NRGBAModel.Convert(want.At(x, y)).(color.NRGBA)
+			if abs(int(g.R)-int(w.R)) > goldenTolerance || abs(int(g.G)-int(w.G)) > goldenTolerance ||
+				abs(int(g.B)-int(w.B)) > goldenTolerance || abs(int(g.A)-int(w.A)) > goldenTolerance {
+				return fmt.Errorf("pixel (%d, %d) is %v, want %v", x, y, g, w)
+			}
+		}
+	}
+	return nil
+}
+
+func TestDrawText(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings UserSettings
+	}{
+		{"plain", UserSettings{}},
+		{"outline", UserSettings{OutlineColor: "E63946", OutlineWidth: "3"}},
+		{"shadow", UserSettings{ShadowColor: "000000", ShadowOffset: "4,4"}},
+		{"shadow_blur", UserSettings{ShadowColor: "000000", ShadowOffset: "3,-2", ShadowBlur: "4"}},
+		{"box", UserSettings{BoxColor: "1D3557"}},
+		{"all", UserSettings{OutlineColor: "FFFFFF", OutlineWidth: "2", ShadowColor: "000000", ShadowBlur: "3", BoxColor: "1D3557"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fx, err := tt.settings.effects()
+			if err != nil {
+				t.Fatal(err)
+			}
+			mask, textRect := textMask(t, image.Pt(160, 80), "Kbot")
+			dst := image.NewRGBA(mask.Bounds())
+			draw.Draw(dst, dst.Bounds(), image.NewUniform(color.RGBA{0xF1, 0xFA, 0xEE, 0xFF}), image.Point{}, draw.Src)
+			drawText(dst, mask, color.RGBA{0x45, 0x7B, 0x9D, 0xFF}, fx, textRect, 16)
+			checkGolden(t, "effects_"+tt.name, dst)
+		})
+	}
+}
+
+func TestDilateMask(t *testing.T) {
+	tests := []struct {
+		name   string
+		radius int
+		want   []string // Rows of the dilated mask, '#' for opaque
+	}{
+		{"radius 0", 0, []string{
+			".......",
+			".......",
+			".......",
+			"...#...",
+			".......",
+			".......",
+			".......",
+		}},
+		{"radius 1", 1, []string{
+			".......",
+			".......",
+			"...#...",
+			"..###..",
+			"...#...",
+			".......",
+			".......",
+		}},
+		{"radius 2", 2, []string{
+			".......",
+			"...#...",
+			"..###..",
+			".#####.",
+			"..###..",
+			"...#...",
+			".......",
+		}},
+		{"radius 3 is clipped to the bounds", 3, []string{
+			"...#...",
+			".#####.",
+			".#####.",
+			"#######",
+			".#####.",
+			".#####.",
+			"...#...",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask := image.NewAlpha(image.Rect(0, 0, 7, 7))
+			mask.SetAlpha(3, 3, color.Alpha{A: 0xff})
+			got := dilateMask(mask, tt.radius)
+			for y, row := range tt.want {
+				for x, c := range row {
+					if want := c == '#'; (got.AlphaAt(x, y).A == 0xff) != want {
+						t.Errorf("pixel (%d, %d) = %d, want opaque %v", x, y, got.AlphaAt(x, y).A, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBlurMask(t *testing.T) {
+	tests := []struct {
+		name   string
+		radius int
+	}{
+		{"radius 1", 1},
+		{"radius 3", 3},
+		{"radius 6", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A square wider than the blur and far from the edges: nothing is lost outside the image
+			mask := image.NewAlpha(image.Rect(0, 0, 128, 128))
+			draw.Draw(mask, image.Rect(40, 40, 88, 88), image.Opaque, image.Point{}, draw.Src)
+			got := blurMask(mask, tt.radius)
+
+			var before, after int
+			for i := range mask.Pix {
+				before += int(mask.Pix[i])
+				after += int(got.Pix[i])
+			}
+			if diff := abs(after - before); diff > len(mask.Pix)/2 {
+				t.Errorf("blur changed the total alpha from %d to %d", before, after)
+			}
+			if a := got.AlphaAt(64, 64).A; a != 0xff {
+				t.Errorf("centre alpha = %d, want 255", a)
+			}
+			if a := got.AlphaAt(39, 64).A; a == 0 || a == 0xff {
+				t.Errorf("alpha next to the edge = %d, want partly transparent", a)
+			}
+			if a := got.AlphaAt(40-3*tt.radius-1, 64).A; a != 0 {
+				t.Errorf("alpha beyond the blur radius = %d, want 0", a)
+			}
+			if got.AlphaAt(36, 64) != got.AlphaAt(91, 64) || got.AlphaAt(64, 36) != got.AlphaAt(64, 91) {
+				t.Error("blur is not symmetric")
+			}
+		})
+	}
+}
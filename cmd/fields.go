@@ -0,0 +1,188 @@
+// kbot-app/cmd/fields.go
+// This file contains the table of user-editable settings: their commands, validation and awaiting states.
+
+package cmd
+
+import (
+	"strconv"
+	"strings"
+)
+
+// fieldKind groups settings that share catalog messages
+type fieldKind string
+
+const (
+	fieldColor      fieldKind = "color"      // Hex colors: messages under "color.*"
+	fieldLayout     fieldKind = "layout"     // Text layout: messages under "layout.*"
+	fieldBackground fieldKind = "background" // Background mode: messages under "background.*"
+	fieldEffect     fieldKind = "effect"     // Text effects: messages under "effect.*"
+)
+
+// settingField describes a setting the user can change in settings mode.
+// Its command is "/<Name>", its label is the catalog key "field.<Name>".
+type settingField struct {
+	Name  string
+	Kind  fieldKind
+	State SessionState                    // State awaiting the value when the command is sent without one
+	Parse func(raw string) (string, bool) // Validates user input and returns the normalized value
+	Apply func(s *UserSettings, value string)
+	Value func(s UserSettings) string // Current value, as stored
+}
+
+// Layout limits accepted from users
+const (
+	minLineSpacing = 0.8
+	maxLineSpacing = 3.0
+	maxPadding     = 200
+	minMaxWidth    = 100
+	maxMaxWidth    = 2000
+	minFontSize    = 8
+	maxFontSize    = 200
+)
+
+// settingFields lists every editable setting, in the order they are shown to users
+var settingFields = []settingField{
+	{Name: "tx_color", Kind: fieldColor, State: StateAwaitingTextColor, Parse: parseColorValue,
+		Apply: func(s *UserSettings, v string) { s.TextColor = v },
+		Value: func(s UserSettings) string { return s.TextColor }},
+	{Name: "bg_color", Kind: fieldColor, State: StateAwaitingBgColor, Parse: parseColorValue,
+		Apply: func(s *UserSettings, v string) { s.BgColor = v },
+		Value: func(s UserSettings) string { return s.BgColor }},
+	{Name: "align", Kind: fieldLayout, State: StateAwaitingAlign, Parse: parseAlignValue,
+		Apply: func(s *UserSettings, v string) { s.Align = v },
+		Value: func(s UserSettings) string { return s.Align }},
+	{Name: "line_spacing", Kind: fieldLayout, State: StateAwaitingLineSpacing, Parse: parseLineSpacingValue,
+		Apply: func(s *UserSettings, v string) { s.LineSpacing = v },
+		Value: func(s UserSettings) string { return s.LineSpacing }},
+	{Name: "padding", Kind: fieldLayout, State: StateAwaitingPadding, Parse: intRangeParser(0, maxPadding),
+		Apply: func(s *UserSettings, v string) { s.Padding = v },
+		Value: func(s UserSettings) string { return s.Padding }},
+	{Name: "max_width", Kind: fieldLayout, State: StateAwaitingMaxWidth, Parse: intRangeParser(minMaxWidth, maxMaxWidth),
+		Apply: func(s *UserSettings, v string) { s.MaxWidth = v },
+		Value: func(s UserSettings) string { return s.MaxWidth }},
+	{Name: "size", Kind: fieldLayout, State: StateAwaitingSize, Parse: parseSizeValue,
+		Apply: func(s *UserSettings, v string) { s.Size = v },
+		Value: func(s UserSettings) string { return s.Size }},
+	{Name: "font_size", Kind: fieldLayout, State: StateAwaitingFontSize, Parse: intRangeParser(minFontSize, maxFontSize),
+		Apply: func(s *UserSettings, v string) { s.FontSize = v },
+		Value: func(s UserSettings) string { return s.FontSize }},
+	{Name: "bg_mode", Kind: fieldBackground, State: StateAwaitingBgMode, Parse: parseBgModeValue,
+		Apply: func(s *UserSettings, v string) { s.BgMode = v },
+		Value: func(s UserSettings) string { return s.BgMode }},
+	{Name: "bg_color2", Kind: fieldColor, State: StateAwaitingBgColor2, Parse: parseColorValue,
+		Apply: func(s *UserSettings, v string) { s.BgColor2 = v },
+		Value: func(s UserSettings) string { return s.BgColor2 }},
+	{Name: "bg_angle", Kind: fieldBackground, State: StateAwaitingBgAngle, Parse: intRangeParser(0, 359),
+		Apply: func(s *UserSettings, v string) { s.BgAngle = v },
+		Value: func(s UserSettings) string { return s.BgAngle }},
+	{Name: "outline_color", Kind: fieldColor, State: StateAwaitingOutlineColor, Parse: parseColorValue,
+		Apply: func(s *UserSettings, v string) { s.OutlineColor = v },
+		Value: func(s UserSettings) string { return s.OutlineColor }},
+	{Name: "outline_width", Kind: fieldEffect, State: StateAwaitingOutlineWidth, Parse: intRangeParser(0, maxOutlineWidth),
+		Apply: func(s *UserSettings, v string) { s.OutlineWidth = v },
+		Value: func(s UserSettings) string { return s.OutlineWidth }},
+	{Name: "shadow_color", Kind: fieldEffect, State: StateAwaitingShadowColor, Parse: parseOptionalColorValue,
+		Apply: func(s *UserSettings, v string) { s.ShadowColor = v },
+		Value: func(s UserSettings) string { return s.ShadowColor }},
+	{Name: "shadow_offset", Kind: fieldEffect, State: StateAwaitingShadowOffset, Parse: parseOffsetValue,
+		Apply: func(s *UserSettings, v string) { s.ShadowOffset = v },
+		Value: func(s UserSettings) string { return s.ShadowOffset }},
+	{Name: "shadow_blur", Kind: fieldEffect, State: StateAwaitingShadowBlur, Parse: intRangeParser(0, maxShadowBlur),
+		Apply: func(s *UserSettings, v string) { s.ShadowBlur = v },
+		Value: func(s UserSettings) string { return s.ShadowBlur }},
+	{Name: "box_color", Kind: fieldEffect, State: StateAwaitingBoxColor, Parse: parseOptionalColorValue,
+		Apply: func(s *UserSettings, v string) { s.BoxColor = v },
+		Value: func(s UserSettings) string { return s.BoxColor }},
+}
+
+// lookupField returns the setting with the given name
+func lookupField(name string) (settingField, bool) {
+	for _, field := range settingFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return settingField{}, false
+}
+
+// display formats a normalized value for messages
+func (f settingField) display(value string) string {
+	if f.Kind == fieldColor || (f.Kind == fieldEffect && isValidHexColor(value)) {
+		return "#" + value
+	}
+	return value
+}
+
+// inPalette reports whether a parsed value is allowed by KBOT_PALETTE; only colors are limited
+func (f settingField) inPalette(value string) bool {
+	takesColor := f.Kind == fieldColor || f.Name == "shadow_color" || f.Name == "box_color"
+	return !takesColor || value == effectNone || currentConfig().allowsColor(value)
+}
+
+// promptKey is the catalog key asking the user for a value
+func (f settingField) promptKey() string {
+	return string(f.Kind) + ".prompt." + f.Name
+}
+
+// invalidKey is the catalog key rejecting a value; colors use "color.invalid" and "color.invalid_waiting"
+func (f settingField) invalidKey() string {
+	return string(f.Kind) + ".invalid." + f.Name
+}
+
+// colorNames are the color names accepted besides hex values
+var colorNames = map[string]string{
+	"black":  "000000",
+	"white":  "FFFFFF",
+	"gray":   "808080",
+	"grey":   "808080",
+	"red":    "FF0000",
+	"orange": "FFA500",
+	"yellow": "FFFF00",
+	"green":  "008000",
+	"lime":   "00FF00",
+	"cyan":   "00FFFF",
+	"blue":   "0000FF",
+	"navy":   "000080",
+	"purple": "800080",
+	"pink":   "FFC0CB",
+	"brown":  "A52A2A",
+}
+
+// parseColorValue accepts 3 or 6 digit hex colors with an optional '#', or a name from colorNames
+func parseColorValue(raw string) (string, bool) {
+	if hex, ok := colorNames[strings.ToLower(strings.TrimSpace(raw))]; ok {
+		return hex, true
+	}
+	value := strings.TrimPrefix(strings.TrimSpace(raw), "#") // Remove '#' if present
+	return value, isValidHexColor(value)
+}
+
+// parseAlignValue accepts left, center or right
+func parseAlignValue(raw string) (string, bool) {
+	value := strings.ToLower(strings.TrimSpace(raw))
+	switch value {
+	case alignLeft, alignCenter, alignRight:
+		return value, true
+	}
+	return value, false
+}
+
+// parseLineSpacingValue accepts a line height multiplier such as 1.5
+func parseLineSpacingValue(raw string) (string, bool) {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(raw), ",", "."), 64)
+	if err != nil || value < minLineSpacing || value > maxLineSpacing {
+		return raw, false
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64), true
+}
+
+// intRangeParser returns a parser accepting integers (optionally suffixed with "px") within [min, max]
+func intRangeParser(min, max int) func(string) (string, bool) {
+	return func(raw string) (string, bool) {
+		value, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(raw)), "px"))
+		if err != nil || value < min || value > max {
+			return raw, false
+		}
+		return strconv.Itoa(value), true
+	}
+}
@@ -0,0 +1,167 @@
+// kbot-app/cmd/fonts.go
+// This file contains the font fallback chain and the Unicode text preparation (normalization, bidi) of the local renderer.
+
+package cmd
+
+import (
+	"image"
+	"log"
+	"os"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/norm"
+)
+
+// localFonts is the fallback chain: the built-in Go font (Latin, Cyrillic, Greek) followed by
+// the fonts of KBOT_FONT_PATHS in order. It is loaded on first use.
+// Only outline glyphs are drawn: color emoji fonts (bitmap CBDT/sbix, COLR layers, SVG) are not
+// supported, so emoji need a monochrome font such as Noto Emoji.
+var localFonts struct {
+	once  sync.Once
+	fonts []*opentype.Font
+	err   error
+}
+
+// loadLocalFonts parses the built-in font and the configured fallback fonts.
+// A fallback font that cannot be read is skipped, so it never breaks rendering.
+func loadLocalFonts() ([]*opentype.Font, error) {
+	localFonts.once.Do(func() {
+		builtin, err := opentype.Parse(goregular.TTF)
+		if err != nil {
+			localFonts.err = err
+			return
+		}
+		localFonts.fonts = []*opentype.Font{builtin}
+		for _, path := range currentConfig().FontPaths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("Skipping fallback font %s: %v", path, err)
+				continue
+			}
+			// Collections (.ttc, .otc) contribute their first font
+			collection, err := opentype.ParseCollection(data)
+			if err != nil || collection.NumFonts() == 0 {
+				log.Printf("Skipping fallback font %s: %v", path, err)
+				continue
+			}
+			f, err := collection.Font(0)
+			if err != nil {
+				log.Printf("Skipping fallback font %s: %v", path, err)
+				continue
+			}
+			localFonts.fonts = append(localFonts.fonts, f)
+			log.Printf("Loaded fallback font %s", path)
+		}
+	})
+	return localFonts.fonts, localFonts.err
+}
+
+// localFace returns a face of the fallback chain at the given size; the caller must close it
+func localFace(size float64) (font.Face, error) {
+	fonts, err := loadLocalFonts()
+	if err != nil {
+		return nil, err
+	}
+	face := &fallbackFace{fonts: fonts, byRune: make(map[rune]int)}
+	for _, f := range fonts {
+		ff, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			face.Close()
+			return nil, err
+		}
+		face.faces = append(face.faces, ff)
+	}
+	return face, nil
+}
+
+// fallbackFace draws every rune with the first font of the chain that has a glyph for it.
+// Line metrics come from the first font. Runes that no font covers are drawn as the first
+// font's missing-glyph box, except invisible format characters (joiners, variation selectors),
+// which are skipped.
+type fallbackFace struct {
+	fonts  []*opentype.Font
+	faces  []font.Face
+	byRune map[rune]int // Index of the face chosen for a rune
+	buf    sfnt.Buffer
+}
+
+// faceFor returns the face that draws r, or nil if r is invisible
+func (f *fallbackFace) faceFor(r rune) font.Face {
+	if i, ok := f.byRune[r]; ok {
+		if i < 0 {
+			return nil
+		}
+		return f.faces[i]
+	}
+	index := 0
+	if unicode.Is(unicode.Cf, r) || unicode.Is(unicode.Variation_Selector, r) {
+		index = -1
+	}
+	for i, fnt := range f.fonts {
+		if glyph, err := fnt.GlyphIndex(&f.buf, r); err == nil && glyph != 0 {
+			index = i
+			break
+		}
+	}
+	f.byRune[r] = index
+	if index < 0 {
+		return nil
+	}
+	return f.faces[index]
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (image.Rectangle, image.Image, image.Point, fixed.Int26_6, bool) {
+	face := f.faceFor(r)
+	if face == nil {
+		return image.Rectangle{}, nil, image.Point{}, 0, false
+	}
+	return face.Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (fixed.Rectangle26_6, fixed.Int26_6, bool) {
+	face := f.faceFor(r)
+	if face == nil {
+		return fixed.Rectangle26_6{}, 0, false
+	}
+	return face.GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (fixed.Int26_6, bool) {
+	face := f.faceFor(r)
+	if face == nil {
+		return 0, false
+	}
+	return face.GlyphAdvance(r)
+}
+
+// Kern applies kerning only between runes drawn by the same font
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	face := f.faceFor(r0)
+	if face == nil || face != f.faceFor(r1) {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+func (f *fallbackFace) Metrics() font.Metrics {
+	return f.faces[0].Metrics()
+}
+
+// normalizeText composes combining sequences (e.g. "й" typed as "и" + U+0306),
+// so that fonts draw the precomposed glyph instead of a detached mark
+func normalizeText(text string) string {
+	return norm.NFC.String(text)
+}
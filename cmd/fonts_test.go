@@ -0,0 +1,65 @@
+// kbot-app/cmd/fonts_test.go
+// This file contains the tests of the font fallback chain and the text normalization.
+
+package cmd
+
+import "testing"
+
+func TestFallbackFace(t *testing.T) {
+	face, err := localFace(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer face.Close()
+	fallback := face.(*fallbackFace)
+
+	tests := []struct {
+		name    string
+		r       rune
+		visible bool
+	}{
+		{"latin", 'A', true},
+		{"cyrillic", 'ї', true},
+		{"greek", 'Ω', true},
+		// Without fallback fonts uncovered runes are drawn as the built-in font's missing-glyph box
+		{"cjk without fallback", '你', true},
+		{"emoji without fallback", '👍', true},
+		{"zero width joiner", '\u200d', false},
+		{"emoji presentation selector", '\ufe0f', false},
+		{"right-to-left mark", '\u200f', false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fallback.faceFor(tt.r) != nil; got != tt.visible {
+				t.Errorf("faceFor(%U) visible = %v, want %v", tt.r, got, tt.visible)
+			}
+			if advance, _ := face.GlyphAdvance(tt.r); tt.visible && advance <= 0 {
+				t.Errorf("GlyphAdvance(%U) = %v, want a positive advance", tt.r, advance)
+			}
+		})
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"latin", "Hello", "Hello"},
+		{"latin acute", "e\u0301", "\u00e9"},
+		{"cyrillic short i", "\u0438\u0306", "\u0439"},
+		{"ukrainian yi", "\u0456\u0308\u0436\u0430\u043a", "\u0457\u0436\u0430\u043a"},
+		{"already composed", "\u0457\u0436\u0430\u043a", "\u0457\u0436\u0430\u043a"},
+		{"hebrew points are ordered, not composed", "\u05e9\u05c1\u05b8", "\u05e9\u05b8\u05c1"},
+		{"arabic harakat are ordered", "\u0628\u0651\u064e", "\u0628\u064e\u0651"},
+		{"cjk", "你好", "你好"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeText(tt.text); got != tt.want {
+				t.Errorf("normalizeText(%q) = %U, want %U", tt.text, []rune(got), []rune(tt.want))
+			}
+		})
+	}
+}
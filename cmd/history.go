@@ -0,0 +1,181 @@
+// kbot-app/cmd/history.go
+// This file contains the history of saved settings: every change keeps the settings it replaced,
+// /history lists them and /undo restores the last one.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v4"
+)
+
+const maxSettingsHistory = 10 // Snapshots kept per user; older ones are dropped
+
+// Changes of saved settings, shown in /history as "history.change.<name>"
+const (
+	changeSave   = "save"
+	changeLang   = "lang"
+	changeImport = "import"
+	changeStyle  = "style"
+	changeUndo   = "undo"
+)
+
+// settingsSnapshot is saved settings as they were before a change
+type settingsSnapshot struct {
+	Settings   UserSettings `json:"settings"`
+	ReplacedAt time.Time    `json:"replaced_at"`
+	Change     string       `json:"change"` // What replaced them, e.g. "save"
+}
+
+// historyStore keeps the snapshots of every user, oldest first (thread-safe)
+type historyStore struct {
+	mu      sync.Mutex
+	entries map[int64][]settingsSnapshot
+}
+
+var settingsHistory = &historyStore{entries: make(map[int64][]settingsSnapshot)}
+
+// push appends a snapshot, dropping the oldest beyond maxSettingsHistory
+func (h *historyStore) push(userID int64, snapshot settingsSnapshot) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := append(h.entries[userID], snapshot)
+	if len(entries) > maxSettingsHistory {
+		entries = entries[len(entries)-maxSettingsHistory:]
+	}
+	h.entries[userID] = entries
+}
+
+// pop removes and returns the latest snapshot
+func (h *historyStore) pop(userID int64) (settingsSnapshot, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := h.entries[userID]
+	if len(entries) == 0 {
+		return settingsSnapshot{}, false
+	}
+	last := entries[len(entries)-1]
+	if len(entries) == 1 {
+		delete(h.entries, userID)
+	} else {
+		h.entries[userID] = entries[:len(entries)-1]
+	}
+	return last, true
+}
+
+// list returns a copy of the user's snapshots, oldest first
+func (h *historyStore) list(userID int64) []settingsSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]settingsSnapshot(nil), h.entries[userID]...)
+}
+
+// forget drops the user's history
+func (h *historyStore) forget(userID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.entries, userID)
+}
+
+// storeUserSettings replaces the saved settings of a user, keeping the old ones in the history
+func storeUserSettings(userID int64, settings UserSettings, change string) {
+	previous, ok := userSettingsStore.Swap(userID, settings)
+	if ok && previous.(UserSettings) != settings {
+		settingsHistory.push(userID, settingsSnapshot{Settings: previous.(UserSettings), ReplacedAt: time.Now().UTC(), Change: change})
+	}
+}
+
+// settingsDigest describes settings on one line, e.g. "tx_color=#FF0000, align=left"
+func settingsDigest(s UserSettings) string {
+	var parts []string
+	for _, field := range settingFields {
+		if value := field.Value(s); value != "" {
+			parts = append(parts, field.Name+"="+field.display(value))
+		}
+	}
+	if s.Lang != "" {
+		parts = append(parts, "lang="+s.Lang)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// handleHistory handles /history: lists the earlier settings, the latest first
+func handleHistory(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	_, span := tracer.Start(context.Background(), "handleHistory",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	locale := userLocale(c)
+	markup := mainMenuFor(locale)
+	if sessions.Get(c.Sender().ID).State.InSettings() {
+		markup = settingsMenuFor(locale)
+	}
+	entries := settingsHistory.list(c.Sender().ID)
+	span.SetAttributes(attribute.Int("settings.history", len(entries)))
+	if len(entries) == 0 {
+		return c.Send(tr(locale, "history.empty"), markup)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(locale, "history.header", len(entries)))
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		fmt.Fprintf(&sb, "\n\n%d. %s, %s\n%s", len(entries)-i, entry.ReplacedAt.Format("2006-01-02 15:04 UTC"),
+			tr(locale, "history.change."+entry.Change), settingsDigest(entry.Settings))
+	}
+	return c.Send(sb.String(), markup)
+}
+
+// handleUndo handles /undo: restores the settings saved before the last change
+func handleUndo(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleUndo",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	// Saving the draft would overwrite the restored settings
+	if sessions.Get(senderID).State.InSettings() {
+		return c.Send(tr(locale, "undo.in_settings", tr(locale, "btn.save"), tr(locale, "btn.cancel")), settingsMenuFor(locale))
+	}
+	snapshot, ok := settingsHistory.pop(senderID)
+	if !ok {
+		span.SetStatus(codes.Error, "Nothing to undo")
+		return c.Send(tr(locale, "undo.empty"), mainMenuFor(locale))
+	}
+
+	restored := snapshot.Settings
+	if currentRaw, ok := userSettingsStore.Load(senderID); ok {
+		// The photo and the sticker pack belong to the account, not to the look: keep the current ones
+		current := currentRaw.(UserSettings)
+		restored.BgPhoto, restored.StickerSet = current.BgPhoto, current.StickerSet
+	}
+	storeUserSettings(senderID, restored, changeUndo)
+	settingsUndoCounter.Add(ctx, 1)
+	span.SetAttributes(attribute.String("settings.undone_change", snapshot.Change))
+	log.Printf("User %d (%s) undid a settings change (%s at %s)", senderID, c.Sender().Username, snapshot.Change, snapshot.ReplacedAt.Format(time.RFC3339))
+
+	locale = userLocale(c) // The language may be restored too
+	return c.Send(tr(locale, "undo.done", settingsDigest(restored)), mainMenuFor(locale))
+}
@@ -0,0 +1,310 @@
+// kbot-app/cmd/httpapi.go
+// This file contains the HTTP render API served next to the bot when KBOT_HTTP_ADDR is set:
+// POST /v1/render turns JSON into a PNG (or a JSON link to it), GET /v1/openapi.yaml describes it.
+
+package cmd
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//go:embed openapi.yaml
+var openAPIDocument []byte
+
+// HTTP API limits
+const (
+	apiMaxBodyBytes = 64 << 10
+	apiMaxTextRunes = 4096 // Telegram's message length, so the API accepts what the bot accepts
+)
+
+// renderAPIRequest is the body of POST /v1/render
+type renderAPIRequest struct {
+	Text      string            `json:"text"`
+	TextColor string            `json:"text_color,omitempty"`
+	BgColor   string            `json:"bg_color,omitempty"`
+	Size      string            `json:"size,omitempty"`
+	FontSize  int               `json:"font_size,omitempty"`
+	Options   map[string]string `json:"options,omitempty"` // Any inline option, e.g. {"align": "left"}
+	Format    string            `json:"format,omitempty"`  // "png" (default) or "json"
+}
+
+// renderAPIResponse is the JSON answer of POST /v1/render
+type renderAPIResponse struct {
+	URL      string `json:"url"` // Link of the provider, or a data: URL with the PNG
+	Renderer string `json:"renderer"`
+	Cached   bool   `json:"cached"`
+}
+
+// apiError is the body of every error answer
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// startRenderAPI serves the HTTP render API if KBOT_HTTP_ADDR is set; the caller shuts the returned server down
+func startRenderAPI() *http.Server {
+	addr := currentConfig().HTTPAddr
+	if addr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/render", handleRenderAPI)
+	mux.HandleFunc("GET /v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPIDocument)
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           otelhttp.NewHandler(mux, "kbot.http"),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("HTTP render API listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP render API stopped: %v", err)
+		}
+	}()
+	return server
+}
+
+// stopRenderAPI lets running requests finish before the bot exits
+func stopRenderAPI(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop the HTTP render API: %v", err)
+	}
+}
+
+// handleRenderAPI handles POST /v1/render
+func handleRenderAPI(w http.ResponseWriter, r *http.Request) {
+	// otelhttp has started the server span; this one holds the render details
+	ctx, span := tracer.Start(r.Context(), "handleRenderAPI",
+		trace.WithAttributes(attribute.String("http.client", apiClient(r))))
+	defer span.End()
+
+	status := func(code int) {
+		httpRenderCounter.Add(ctx, 1, metric.WithAttributes(attribute.Int("http.status_code", code)))
+		if code >= 400 {
+			span.SetStatus(codes.Error, http.StatusText(code))
+		}
+	}
+	fail := func(code int, message string) {
+		status(code)
+		writeJSON(w, code, apiError{Error: message})
+	}
+
+	if token := currentConfig().HTTPToken; token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		fail(http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	var body renderAPIRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			fail(http.StatusRequestEntityTooLarge, "request body is larger than "+strconv.Itoa(apiMaxBodyBytes)+" bytes")
+			return
+		}
+		fail(http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+	text := strings.TrimSpace(body.Text)
+	if text == "" {
+		fail(http.StatusBadRequest, "text is required")
+		return
+	}
+	if utf8.RuneCountInString(text) > apiMaxTextRunes {
+		fail(http.StatusBadRequest, "text is longer than "+strconv.Itoa(apiMaxTextRunes)+" characters")
+		return
+	}
+	settings, err := body.settings()
+	if err != nil {
+		fail(http.StatusBadRequest, err.Error())
+		return
+	}
+	asJSON := body.Format == "json" || (body.Format == "" && strings.Contains(r.Header.Get("Accept"), "application/json"))
+	if body.Format != "" && body.Format != "json" && body.Format != "png" {
+		fail(http.StatusBadRequest, `format must be "png" or "json"`)
+		return
+	}
+
+	if ok, wait := renderLimiter.Allow(ctx, "http:"+apiClient(r), "http"); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(retrySeconds(wait)))
+		fail(http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+
+	if moderate(ctx, moderationRequest{Text: text, Source: sourceHTTP}).Decision == decisionBlock {
+		fail(http.StatusUnprocessableEntity, "text was rejected by moderation")
+		return
+	}
+
+	generator := currentGenerator()
+	span.SetAttributes(attribute.String("image.renderer", generator.Name()), attribute.String("image.size", settings.Size))
+	rendered, rerr := renderImage(ctx, generator, RenderRequest{Text: text, Settings: settings}, attribute.String("image.output", "http"))
+	if rerr != nil {
+		log.Printf("HTTP render failed: %v", rerr)
+		span.RecordError(rerr)
+		code := http.StatusInternalServerError
+		if rerr.Key == "image.error.network" || rerr.Key == "image.error.status" || rerr.Key == "image.error.no_link" {
+			code = http.StatusBadGateway // The provider failed
+		}
+		fail(code, rerr.Error())
+		return
+	}
+	span.SetAttributes(attribute.Bool("image.cached", rendered.Cached))
+	w.Header().Set("X-Kbot-Cache", map[bool]string{true: "hit", false: "miss"}[rendered.Cached])
+
+	if asJSON {
+		url := rendered.URL
+		if rendered.PNG != nil {
+			url = "data:image/png;base64," + base64.StdEncoding.EncodeToString(rendered.PNG)
+		}
+		status(http.StatusOK)
+		writeJSON(w, http.StatusOK, renderAPIResponse{URL: url, Renderer: generator.Name(), Cached: rendered.Cached})
+		return
+	}
+	data, err := renderedPNG(ctx, rendered)
+	if err != nil {
+		span.RecordError(err)
+		fail(http.StatusBadGateway, "downloading the rendered image: "+err.Error())
+		return
+	}
+	status(http.StatusOK)
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// settings applies the request to the default settings, validating every value like the bot does
+func (b renderAPIRequest) settings() (UserSettings, error) {
+	settings := defaultUserSettings()
+	values := [][2]string{{"tx_color", b.TextColor}, {"bg_color", b.BgColor}, {"size", b.Size}}
+	if b.FontSize != 0 {
+		values = append(values, [2]string{"font_size", strconv.Itoa(b.FontSize)})
+	}
+	names := make([]string, 0, len(b.Options))
+	for name := range b.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Deterministic when an option repeats a field above
+	for _, name := range names {
+		values = append(values, [2]string{name, b.Options[name]})
+	}
+
+	var options inlineOptions
+	for _, v := range values {
+		if v[1] == "" {
+			continue
+		}
+		option, err := resolveInlineOption(v[0], v[1], v[0])
+		if err != nil {
+			switch err.Key {
+			case "options.unknown":
+				return settings, errors.New("unknown option " + strconv.Quote(v[0]))
+			case "options.not_in_palette":
+				return settings, errors.New(v[0] + " " + strconv.Quote(v[1]) + " is not in the palette " + paletteList())
+			default:
+				return settings, errors.New("invalid " + v[0] + " " + strconv.Quote(v[1]))
+			}
+		}
+		options = append(options, option)
+	}
+	options.apply(&settings)
+	return settings, nil
+}
+
+// apiClient identifies the client for the rate limit and traces: its IP address. Behind a trusted
+// proxy it is the last X-Forwarded-For address that is not a trusted proxy itself; addresses left of
+// it could be forged by the client.
+func apiClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	proxies := currentConfig().HTTPTrustedProxies
+	if !proxies.Contains(host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		if hop == "" {
+			continue
+		}
+		if !proxies.Contains(hop) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+// proxyList is the set of reverse proxies trusted to report the client address in X-Forwarded-For
+type proxyList []netip.Prefix
+
+// parseProxyList parses IP addresses and CIDRs such as 10.0.0.0/8
+func parseProxyList(entries []string) (proxyList, error) {
+	var proxies proxyList
+	for _, entry := range entries {
+		prefix, err := netip.ParsePrefix(entry)
+		if !strings.Contains(entry, "/") {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(entry); err == nil {
+				prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid KBOT_HTTP_TRUSTED_PROXIES entry %q: expected an IP address or CIDR", entry)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+// Contains reports whether the address belongs to one of the proxies
+func (p proxyList) Contains(address string) bool {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range p {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSON writes a JSON answer
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,259 @@
+// kbot-app/cmd/httpapi_test.go
+// This file contains the tests of the HTTP render API: client identity behind proxies, the bearer
+// token, request validation and the rate limit.
+
+package cmd
+
+import (
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseProxyList(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string // Prefixes after parsing
+		wantErr bool
+	}{
+		{"bare IPv4", []string{"10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{"bare IPv6", []string{"2001:db8::1"}, []string{"2001:db8::1/128"}, false},
+		{"IPv4-mapped IPv6 is stored as IPv4", []string{"::ffff:10.0.0.1"}, []string{"10.0.0.1/32"}, false},
+		{"CIDRs are masked", []string{"10.1.2.3/8", "2001:db8::1/32"}, []string{"10.0.0.0/8", "2001:db8::/32"}, false},
+		{"empty", nil, nil, false},
+		{"host name", []string{"proxy.local"}, nil, true},
+		{"bad prefix length", []string{"10.0.0.0/33"}, nil, true},
+		{"one bad entry fails the list", []string{"10.0.0.1", "nope"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies, err := parseProxyList(tt.entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseProxyList(%q) error = %v, want error %v", tt.entries, err, tt.wantErr)
+			}
+			var got []string
+			for _, prefix := range proxies {
+				got = append(got, prefix.String())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("parseProxyList(%q) = %q, want %q", tt.entries, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyListContains(t *testing.T) {
+	proxies, err := parseProxyList([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"10.20.30.40", true},
+		{"::ffff:10.20.30.40", true},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"2001:db8:1::5", true},
+		{"2001:db9::5", false},
+		{"11.0.0.1", false},
+		{"not an address", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := proxies.Contains(tt.address); got != tt.want {
+			t.Errorf("Contains(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestAPIClient(t *testing.T) {
+	proxies, err := parseProxyList([]string{"10.0.0.0/8", "2001:db8::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *currentConfig()
+	cfg.HTTPTrustedProxies = proxies
+	useConfig(t, &cfg)
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string // X-Forwarded-For headers
+		want      string
+	}{
+		{"direct client", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"direct client cannot forward", "203.0.113.5:4000", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.2:4000", []string{"198.51.100.1, 10.0.0.9, 10.1.1.1"}, "198.51.100.1"},
+		{"forged address left of the client", "10.0.0.2:4000", []string{"1.2.3.4, 198.51.100.1, 10.0.0.9"}, "198.51.100.1"},
+		{"forged proxy address left of the client", "10.0.0.2:4000", []string{"10.9.9.9, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.0.0.2:4000", []string{"1.2.3.4", "198.51.100.1, 10.0.0.9"}, "198.51.100.1"},
+		{"empty hops are skipped", "10.0.0.2:4000", []string{"198.51.100.1, , "}, "198.51.100.1"},
+		{"proxy without a header", "10.0.0.2:4000", nil, "10.0.0.2"},
+		{"only proxies in the header", "10.0.0.2:4000", []string{"10.0.0.3, 10.0.0.4"}, "10.0.0.3"},
+		{"IPv6 proxy", "[2001:db8::1]:4000", []string{"2001:db8::99"}, "2001:db8::99"},
+		{"IPv4-mapped proxy", "[::ffff:10.0.0.2]:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"address without a port", "203.0.113.5", nil, "203.0.113.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/render", nil)
+			r.RemoteAddr = tt.peer
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := apiClient(r); got != tt.want {
+				t.Errorf("apiClient = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderAPIRequestSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    renderAPIRequest
+		check   func(UserSettings) bool
+		wantErr string // Start of the error message
+	}{
+		{"defaults", renderAPIRequest{Text: "Hi"}, func(s UserSettings) bool { return s == defaultUserSettings() }, ""},
+		{"fields", renderAPIRequest{TextColor: "red", BgColor: "#000", Size: "og", FontSize: 32},
+			func(s UserSettings) bool {
+				return s.TextColor == "FF0000" && s.BgColor == "000" && s.Size == "og" && s.FontSize == "32"
+			}, ""},
+		{"options", renderAPIRequest{Options: map[string]string{"align": "left", "fg": "blue"}},
+			func(s UserSettings) bool { return s.Align == "left" && s.TextColor == "0000FF" }, ""},
+		{"options override fields", renderAPIRequest{TextColor: "red", Options: map[string]string{"fg": "blue"}},
+			func(s UserSettings) bool { return s.TextColor == "0000FF" }, ""},
+		{"unknown option", renderAPIRequest{Options: map[string]string{"sparkle": "yes"}}, nil, `unknown option "sparkle"`},
+		{"invalid color", renderAPIRequest{TextColor: "pinkish"}, nil, `invalid tx_color "pinkish"`},
+		{"invalid size", renderAPIRequest{Size: "huge"}, nil, `invalid size "huge"`},
+		{"invalid font size", renderAPIRequest{FontSize: 100000}, nil, `invalid font_size "100000"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings, err := tt.body.settings()
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("settings error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.check(settings) {
+				t.Errorf("settings = %+v", settings)
+			}
+		})
+	}
+}
+
+// serveRenderAPI sends a request to handleRenderAPI from the client address peer
+func serveRenderAPI(peer, body string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/v1/render", strings.NewReader(body))
+	r.RemoteAddr = peer
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	handleRenderAPI(w, r)
+	return w
+}
+
+func TestHandleRenderAPI(t *testing.T) {
+	cfg := *currentConfig()
+	cfg.HTTPToken = "s3cret"
+	cfg.RenderRate = 0
+	useConfig(t, &cfg)
+	auth := http.Header{"Authorization": {"Bearer s3cret"}}
+
+	tests := []struct {
+		name     string
+		body     string
+		header   http.Header
+		wantCode int
+		wantErr  string // Part of the error message
+	}{
+		{"no token", `{"text":"Hi"}`, nil, http.StatusUnauthorized, "bearer token"},
+		{"wrong token", `{"text":"Hi"}`, http.Header{"Authorization": {"Bearer s3cre"}}, http.StatusUnauthorized, "bearer token"},
+		{"token without scheme", `{"text":"Hi"}`, http.Header{"Authorization": {"s3cret"}}, http.StatusUnauthorized, "bearer token"},
+		{"unknown field", `{"text":"Hi","colour":"red"}`, auth, http.StatusBadRequest, "unknown field"},
+		{"not JSON", `text=Hi`, auth, http.StatusBadRequest, "invalid JSON body"},
+		{"oversized body", `{"text":"` + strings.Repeat("a", apiMaxBodyBytes) + `"}`, auth, http.StatusRequestEntityTooLarge, "larger than"},
+		{"no text", `{"text":"  "}`, auth, http.StatusBadRequest, "text is required"},
+		{"text too long", `{"text":"` + strings.Repeat("я", apiMaxTextRunes+1) + `"}`, auth, http.StatusBadRequest, "longer than"},
+		{"invalid option", `{"text":"Hi","options":{"align":"diagonal"}}`, auth, http.StatusBadRequest, "invalid align"},
+		{"invalid format", `{"text":"Hi","format":"gif"}`, auth, http.StatusBadRequest, "format must be"},
+		{"png", `{"text":"Hi","text_color":"red"}`, auth, http.StatusOK, ""},
+		{"json", `{"text":"Hi","format":"json"}`, auth, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serveRenderAPI("203.0.113.10:4000", tt.body, tt.header)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantCode, w.Body.String())
+			}
+			if tt.wantErr != "" {
+				var answer apiError
+				if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil || !strings.Contains(answer.Error, tt.wantErr) {
+					t.Errorf("error = %q (%v), want it to contain %q", answer.Error, err, tt.wantErr)
+				}
+				return
+			}
+			switch w.Header().Get("Content-Type") {
+			case "image/png":
+				if _, err := png.Decode(w.Body); err != nil {
+					t.Errorf("body is not a PNG: %v", err)
+				}
+			case "application/json":
+				var answer renderAPIResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &answer); err != nil || !strings.HasPrefix(answer.URL, "data:image/png;base64,") || answer.Renderer != rendererLocal {
+					t.Errorf("answer = %+v (%v), want a data URL from the local renderer", answer, err)
+				}
+			default:
+				t.Errorf("Content-Type = %q", w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestHandleRenderAPIRateLimit(t *testing.T) {
+	proxies, err := parseProxyList([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := *currentConfig()
+	cfg.HTTPToken = ""
+	cfg.RenderRate = 2
+	cfg.HTTPTrustedProxies = proxies
+	useConfig(t, &cfg)
+	behindProxy := func(client string) http.Header { return http.Header{"X-Forwarded-For": {client}} }
+
+	for i := 0; i < cfg.RenderRate; i++ {
+		if w := serveRenderAPI("10.0.0.2:4000", `{"text":"Hi"}`, behindProxy("198.51.100.20")); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d, want 200", i+1, w.Code)
+		}
+	}
+	w := serveRenderAPI("10.0.0.3:4000", `{"text":"Hi"}`, behindProxy("198.51.100.20"))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status over the limit = %d, want 429", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry == "" || retry == "0" {
+		t.Errorf("Retry-After = %q, want a number of seconds", retry)
+	}
+
+	// Another client behind the same proxy has its own limit, and a forged address does not escape it
+	if w := serveRenderAPI("10.0.0.2:4000", `{"text":"Hi"}`, behindProxy("198.51.100.21")); w.Code != http.StatusOK {
+		t.Errorf("other client: status = %d, want 200", w.Code)
+	}
+	if w := serveRenderAPI("10.0.0.2:4000", `{"text":"Hi"}`, behindProxy("1.2.3.4, 198.51.100.20")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("forged address: status = %d, want 429", w.Code)
+	}
+}
@@ -0,0 +1,137 @@
+// kbot-app/cmd/i18n.go
+// This file contains the message catalog, locale resolution and per-locale keyboards.
+
+package cmd
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+const defaultLocale = "en" // Used when the user's language has no catalog
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+var (
+	// catalogs maps a locale code (e.g. "en", "uk") to its messages. Filled once by loadCatalogs.
+	catalogs map[string]map[string]string
+
+	// keyboards maps a locale code to the reply keyboards built for it. Filled once by setupKeyboards.
+	keyboards map[string]*localeKeyboards
+)
+
+// localeKeyboards holds reply keyboards and their buttons for a single locale
+type localeKeyboards struct {
+	mainMenu          *tele.ReplyMarkup
+	settingsMenu      *tele.ReplyMarkup
+	btnSettings       tele.Btn
+	btnSaveChanges    tele.Btn
+	btnCancelSettings tele.Btn
+}
+
+// loadCatalogs reads every embedded locale file into catalogs
+func loadCatalogs() {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Fatalf("Failed to read embedded locales: %v", err)
+	}
+
+	catalogs = make(map[string]map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			log.Fatalf("Failed to read locale file %s: %v", entry.Name(), err)
+		}
+		messages := make(map[string]string)
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Fatalf("Failed to parse locale file %s: %v", entry.Name(), err)
+		}
+		catalogs[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+
+	if _, ok := catalogs[defaultLocale]; !ok {
+		log.Fatalf("Default locale %q is missing from embedded locales", defaultLocale)
+	}
+	log.Printf("Message catalogs loaded: %s", strings.Join(availableLocales(), ", "))
+}
+
+// availableLocales returns the sorted list of locale codes that have a catalog
+func availableLocales() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// normalizeLocale maps a Telegram language code (e.g. "uk", "en-US") to a supported locale, or "" if unsupported
+func normalizeLocale(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if _, ok := catalogs[code]; ok {
+		return code
+	}
+	return ""
+}
+
+// userLocale picks the locale for the sender: explicit /lang override first, then Telegram's language code
+func userLocale(c tele.Context) string {
+	sender := c.Sender()
+	if sender == nil {
+		return defaultLocale
+	}
+	if settingsRaw, ok := userSettingsStore.Load(sender.ID); ok {
+		if locale := normalizeLocale(settingsRaw.(UserSettings).Lang); locale != "" {
+			return locale
+		}
+	}
+	if locale := normalizeLocale(sender.LanguageCode); locale != "" {
+		return locale
+	}
+	return defaultLocale
+}
+
+// tr returns the message for key in the given locale, formatted with args.
+// Falls back to the default locale and finally to the key itself.
+func tr(locale, key string, args ...interface{}) string {
+	msg, ok := catalogs[locale][key]
+	if !ok {
+		if msg, ok = catalogs[defaultLocale][key]; !ok {
+			log.Printf("Missing translation for key %q (locale %s)", key, locale)
+			msg = key
+		}
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// keyboardsFor returns the keyboards for locale, falling back to the default locale
+func keyboardsFor(locale string) *localeKeyboards {
+	if kb, ok := keyboards[locale]; ok {
+		return kb
+	}
+	return keyboards[defaultLocale]
+}
+
+// mainMenuFor returns the main menu keyboard for locale
+func mainMenuFor(locale string) *tele.ReplyMarkup {
+	return keyboardsFor(locale).mainMenu
+}
+
+// settingsMenuFor returns the settings menu keyboard for locale
+func settingsMenuFor(locale string) *tele.ReplyMarkup {
+	return keyboardsFor(locale).settingsMenu
+}
@@ -0,0 +1,143 @@
+// kbot-app/cmd/imgbun.go
+// This file contains the image generator backed by the Imgbun API.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ImgbunResponse struct for parsing the response from the Imgbun API
+type ImgbunResponse struct {
+	Status     string `json:"status"` // Should be "OK" on success according to API v2 docs
+	DirectLink string `json:"direct_link"`
+	Message    string `json:"message"` // For potential error messages
+}
+
+// imgbunFontSize is the font size sent to Imgbun when the user has not set one
+const imgbunFontSize = 16
+
+// imgbunGenerator renders text through the Imgbun API. The API only knows text, colors and font size:
+// explicit line breaks are passed through; other layout settings, non-solid backgrounds and text effects
+// are not supported by the provider, which always gets BgColor. With a canvas size the image is scaled
+// to fill the canvas and centered on BgColor, standing in for the font auto-fit of the local renderer.
+type imgbunGenerator struct{}
+
+func (imgbunGenerator) Name() string { return rendererImgbun }
+
+func (imgbunGenerator) Generate(ctx context.Context, req RenderRequest) (RenderedImage, error) {
+	span := trace.SpanFromContext(ctx)
+	// Ensure colors don't have '#' (they shouldn't if saved correctly)
+	textColorHex := strings.TrimPrefix(req.Settings.TextColor, "#")
+	bgColorHex := strings.TrimPrefix(req.Settings.BgColor, "#")
+	effects, _ := req.Settings.effects()
+	layout, defaultLayout := req.Settings.layout(), UserSettings{}.layout()
+	defaultLayout.Width, defaultLayout.Height = layout.Width, layout.Height // The canvas is applied by fitToCanvas
+	span.SetAttributes(
+		attribute.Bool("image.layout_ignored", layout != defaultLayout),
+		attribute.Bool("image.background_ignored", req.Settings.BgMode != "" && req.Settings.BgMode != bgSolid),
+		attribute.Bool("image.effects_ignored", effects.enabled()),
+	)
+
+	// Construct the Imgbun API URL
+	// Reference: https://api.imgbun.com/png?key={API Key}&text=some_text&color=tx_color&background=bg_color&size=16&format=json
+	fontSize := int(req.Settings.fontSize(imgbunFontSize))
+	if layout.Width > 0 {
+		fontSize = estimateFontSize(req.Text, layout.Width-2*layout.Padding, layout.Height-2*layout.Padding)
+	}
+	apiURL := fmt.Sprintf("https://api.imgbun.com/png?key=%s&text=%s&color=%s&background=%s&size=%d&format=json",
+		url.QueryEscape(ImgbunAPIKey), // API Key
+		url.QueryEscape(req.Text),     // Text from user, line breaks included
+		url.QueryEscape(textColorHex), // Text color from settings
+		url.QueryEscape(bgColorHex),   // Background color from settings
+		fontSize,                      // Font size from settings
+	)
+	span.AddEvent("Imgbun API request formed")
+
+	// Create HTTP request with OpenTelemetry transport for automatic tracing
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return RenderedImage{}, &renderError{Type: "request_creation", Key: "image.error.request", Err: err}
+	}
+	httpReq.Header.Set("User-Agent", fmt.Sprintf("kbot/%s", appVersion)) // Set User-Agent
+
+	// Wrap the default HTTP client with otelhttp transport
+	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: 20 * time.Second}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return RenderedImage{}, &renderError{Type: "network_error", Key: "image.error.network", Err: err}
+	}
+	defer resp.Body.Close() // Ensure body is closed
+
+	// Check HTTP status code
+	if resp.StatusCode != http.StatusOK {
+		return RenderedImage{}, &renderError{Type: "api_http_error", Key: "image.error.status", Args: []interface{}{resp.StatusCode},
+			Attrs: []attribute.KeyValue{attribute.Int("http.status_code", resp.StatusCode)},
+			Err:   fmt.Errorf("Imgbun API returned non-OK status: %d", resp.StatusCode)}
+	}
+
+	// Decode JSON response
+	var imgbunResp ImgbunResponse
+	if err := json.NewDecoder(resp.Body).Decode(&imgbunResp); err != nil {
+		return RenderedImage{}, &renderError{Type: "json_decode_error", Key: "image.error.decode", Err: err}
+	}
+
+	// Check 'status' field in JSON response (should be "OK")
+	if imgbunResp.Status != "OK" {
+		log.Printf("Error in Imgbun JSON response: status=%s, message=%s", imgbunResp.Status, imgbunResp.Message)
+		rerr := &renderError{Type: "api_logic_error", Key: "image.error.failed",
+			Attrs: []attribute.KeyValue{attribute.String("api.message", imgbunResp.Message)},
+			Err:   fmt.Errorf("Imgbun API status not OK: %s", imgbunResp.Message)}
+		if imgbunResp.Message != "" {
+			rerr.Key, rerr.Args = "image.error.service_message", []interface{}{imgbunResp.Message}
+		}
+		return RenderedImage{}, rerr
+	}
+
+	// Check if direct link is present
+	if imgbunResp.DirectLink == "" {
+		return RenderedImage{}, &renderError{Type: "no_image_link", Key: "image.error.no_link",
+			Err: fmt.Errorf("Imgbun API returned no direct link")}
+	}
+	span.SetAttributes(attribute.String("image.direct_link", imgbunResp.DirectLink))
+	if layout.Width == 0 {
+		return RenderedImage{URL: imgbunResp.DirectLink}, nil
+	}
+	return imgbunCanvas(ctx, RenderedImage{URL: imgbunResp.DirectLink}, layout, req.Settings.BgColor)
+}
+
+// imgbunCanvas downloads an Imgbun image and fits it to the canvas of the layout
+func imgbunCanvas(ctx context.Context, rendered RenderedImage, layout textLayout, bgColorHex string) (RenderedImage, error) {
+	data, err := renderedPNG(ctx, rendered)
+	if err != nil {
+		return RenderedImage{}, &renderError{Type: "network_error", Key: "image.error.network", Err: err}
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return RenderedImage{}, &renderError{Type: "image_decode_error", Key: "image.error.failed", Err: err}
+	}
+	bg, err := parseHexColor(strings.TrimPrefix(bgColorHex, "#"))
+	if err != nil {
+		return RenderedImage{}, &renderError{Type: "invalid_settings", Key: "image.error.failed", Err: err}
+	}
+	img := fitToCanvas(src, layout.Width, layout.Height, layout.Padding, bg)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return RenderedImage{}, &renderError{Type: "encode_error", Key: "image.error.failed", Err: err}
+	}
+	return RenderedImage{PNG: buf.Bytes()}, nil
+}
@@ -5,6 +5,7 @@ package cmd
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
@@ -13,6 +14,7 @@ import (
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
@@ -24,6 +26,9 @@ const (
 	otlpEndpoint   = "otel-collector.monitoring.svc.cluster.local:4317" // OTLP gRPC endpoint для OpenTelemetry Collector
 )
 
+// metricsReader дозволяє читати поточні значення метрик у самому процесі (наприклад, для /admin stats)
+var metricsReader *metric.ManualReader
+
 // InitTelemetry ініціалізує як MeterProvider, так і TracerProvider для OpenTelemetry.
 // Вона повертає функцію, яку слід викликати для завершення роботи провайдерів.
 func InitTelemetry() (func(), error) {
@@ -78,9 +83,11 @@ func InitTelemetry() (func(), error) {
 		log.Fatalf("Failed to create metric exporter: %v", err)
 	}
 
+	metricsReader = metric.NewManualReader()
 	meterProvider := metric.NewMeterProvider(
 		metric.WithResource(res),
 		metric.WithReader(metric.NewPeriodicReader(metricExporter, metric.WithInterval(10*time.Second))),
+		metric.WithReader(metricsReader), // Локальне читання метрик без походу в Collector
 	)
 	otel.SetMeterProvider(meterProvider)
 
@@ -99,3 +106,36 @@ func InitTelemetry() (func(), error) {
 		log.Println("OpenTelemetry shut down.")
 	}, nil
 }
+
+// collectMetricTotals повертає накопичені значення лічильників процесу: сума для counters,
+// кількість вимірювань для histograms. Ключ - назва метрики.
+func collectMetricTotals(ctx context.Context) (map[string]float64, error) {
+	if metricsReader == nil {
+		return nil, fmt.Errorf("telemetry is not initialized")
+	}
+	var rm metricdata.ResourceMetrics
+	if err := metricsReader.Collect(ctx, &rm); err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]float64)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					totals[m.Name] += float64(dp.Value)
+				}
+			case metricdata.Sum[float64]:
+				for _, dp := range data.DataPoints {
+					totals[m.Name] += dp.Value
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					totals[m.Name+".count"] += float64(dp.Count)
+				}
+			}
+		}
+	}
+	return totals, nil
+}
@@ -1,22 +1,21 @@
 package cmd
 
 import (
+	"bytes"
 	"context" // Додаємо context
-	"encoding/json"
-	"fmt"
 	"log"
-	"net/http"
-	"net/url"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode/utf16"
 
 	"github.com/spf13/cobra"
 	tele "gopkg.in/telebot.v4" // Using v4
 
 	// OpenTelemetry imports
-	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp" // Імпорт для інструментації HTTP клієнта
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes" // Додаємо імпорт codes
@@ -45,56 +44,85 @@ var (
 	unrecognizedTextCounter   metric.Int64Counter
 	waitingForInputCounter    metric.Int64Counter
 	invalidColorFormatCounter metric.Int64Counter
+	sessionTransitionCounter  metric.Int64Counter
+	sessionExpiredCounter     metric.Int64Counter
+	adminCommandCounter       metric.Int64Counter
+	accessDeniedCounter       metric.Int64Counter
+	broadcastMessageCounter   metric.Int64Counter
+	commandRejectedCounter    metric.Int64Counter
+	stickerAddedCounter       metric.Int64Counter
+	invalidOptionCounter      metric.Int64Counter
+	batchJobCounter           metric.Int64Counter
+	rateLimitedCounter        metric.Int64Counter
+	renderCacheCounter        metric.Int64Counter
+	httpRenderCounter         metric.Int64Counter
+	settingsTransferCounter   metric.Int64Counter
+	styleCounter              metric.Int64Counter
+	settingsUndoCounter       metric.Int64Counter
+	recentCounter             metric.Int64Counter
+	moderationCounter         metric.Int64Counter
 )
 
 // --- Structs ---
 
-// UserSettings stores color preferences for a user
+// UserSettings stores color, layout, background and text effect preferences for a user.
+// Layout values are normalized strings (see settingFields); empty means the default.
 type UserSettings struct {
-	TextColor string // Expects hex format without '#'
-	BgColor   string // Expects hex format without '#'
-}
-
-// ImgbunResponse struct for parsing the response from the Imgbun API
-type ImgbunResponse struct {
-	Status     string `json:"status"` // Should be "OK" on success according to API v2 docs
-	DirectLink string `json:"direct_link"`
-	Message    string `json:"message"` // For potential error messages
+	TextColor   string // Expects hex format without '#'
+	BgColor     string // Expects hex format without '#'
+	Lang        string // Locale override set via /lang; empty means use Telegram's language
+	Align       string // left, center or right
+	LineSpacing string // Line height multiplier, e.g. "1.5"
+	Padding     string // Margin around the text in pixels
+	MaxWidth    string // Image width in pixels at which text is wrapped
+	Size        string // Canvas size: a preset, "<W>x<H>" or "<W>:<H>"; empty or "auto" fits the image to the text
+	FontSize    string // Font size in pixels; empty uses the generator's default. Ignored on a fixed canvas
+	BgMode      string // Background mode: solid, linear, radial or photo
+	BgColor2    string // Second gradient color, hex without '#'
+	BgAngle     string // Linear gradient angle in degrees
+	BgPhoto     string // Telegram file_id of the background photo
+
+	OutlineColor string // Hex without '#'
+	OutlineWidth string // Pixels; empty or "0" disables the outline
+	ShadowColor  string // Hex without '#'; empty or "none" disables the shadow
+	ShadowOffset string // "dx,dy" in pixels
+	ShadowBlur   string // Blur radius in pixels
+	BoxColor     string // Hex without '#' of the box behind the text; empty or "none" disables it
+
+	StickerSet string // Name of the user's sticker pack created by /sticker; empty until the first sticker
 }
 
 // --- User State and Keyboards ---
 var (
-	// State storage (thread-safe)
-	userSettingsStore     sync.Map // Key: int64 (UserID), Value: UserSettings
-	tempUserSettingsStore sync.Map // Key: int64 (UserID), Value: UserSettings (for editing)
-	userInSettingsMode    sync.Map // Key: int64 (UserID), Value: bool
-	userWaitingFor        sync.Map // Key: int64 (UserID), Value: string ("tx_color", "bg_color", or "")
-
-	// Keyboards and Buttons
-	mainMenuMarkup     *tele.ReplyMarkup
-	settingsMenuMarkup *tele.ReplyMarkup
-	btnSettings        tele.Btn // Global var for the settings button
-	btnSaveChanges     tele.Btn // Global var for the save button
-	btnCancelSettings  tele.Btn // Global var for the cancel button
+	// Saved settings storage (thread-safe). Conversation state lives in sessions, see session.go
+	userSettingsStore sync.Map // Key: int64 (UserID), Value: UserSettings
 )
 
 // --- Keyboard Initialization ---
+// setupKeyboards builds the main and settings keyboards for every loaded locale
 func setupKeyboards() {
-	// Main Menu Keyboard
-	mainMenuMarkup = &tele.ReplyMarkup{ResizeKeyboard: true}
-	btnSettings = mainMenuMarkup.Text("⚙️ Settings") // Assign button to global variable
-	mainMenuMarkup.Reply(
-		mainMenuMarkup.Row(btnSettings),
-	)
-
-	// Settings Menu Keyboard
-	settingsMenuMarkup = &tele.ReplyMarkup{ResizeKeyboard: true}
-	btnSaveChanges = settingsMenuMarkup.Text("💾 Save Settings")     // Assign button to global variable
-	btnCancelSettings = settingsMenuMarkup.Text("◀️ Cancel & Exit") // Assign button to global variable
-	settingsMenuMarkup.Reply(
-		settingsMenuMarkup.Row(btnSaveChanges),
-		settingsMenuMarkup.Row(btnCancelSettings),
-	)
+	keyboards = make(map[string]*localeKeyboards, len(catalogs))
+	for _, locale := range availableLocales() {
+		kb := &localeKeyboards{}
+
+		// Main Menu Keyboard
+		kb.mainMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+		kb.btnSettings = kb.mainMenu.Text(tr(locale, "btn.settings"))
+		kb.mainMenu.Reply(
+			kb.mainMenu.Row(kb.btnSettings),
+		)
+
+		// Settings Menu Keyboard
+		kb.settingsMenu = &tele.ReplyMarkup{ResizeKeyboard: true}
+		kb.btnSaveChanges = kb.settingsMenu.Text(tr(locale, "btn.save"))
+		kb.btnCancelSettings = kb.settingsMenu.Text(tr(locale, "btn.cancel"))
+		kb.settingsMenu.Reply(
+			kb.settingsMenu.Row(kb.btnSaveChanges),
+			kb.settingsMenu.Row(kb.btnCancelSettings),
+		)
+
+		keyboards[locale] = kb
+	}
 	log.Println("Keyboards initialized.")
 }
 
@@ -186,9 +214,145 @@ func initMetrics() {
 		log.Fatalf("Failed to create invalidColorFormatCounter: %v", err)
 	}
 
+	sessionTransitionCounter, err = meter.Int64Counter("kbot.session.transitions.total",
+		metric.WithDescription("Total number of session state transitions, labelled by source, target and validity."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create sessionTransitionCounter: %v", err)
+	}
+
+	sessionExpiredCounter, err = meter.Int64Counter("kbot.session.expired.total",
+		metric.WithDescription("Total number of settings sessions and input prompts expired due to inactivity."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create sessionExpiredCounter: %v", err)
+	}
+
+	adminCommandCounter, err = meter.Int64Counter("kbot.admin.commands.total",
+		metric.WithDescription("Total number of /admin commands, labelled by action and result."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create adminCommandCounter: %v", err)
+	}
+
+	accessDeniedCounter, err = meter.Int64Counter("kbot.access.denied.total",
+		metric.WithDescription("Total number of updates dropped by access control, labelled by reason."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create accessDeniedCounter: %v", err)
+	}
+
+	broadcastMessageCounter, err = meter.Int64Counter("kbot.broadcast.messages.total",
+		metric.WithDescription("Total number of broadcast recipients processed, labelled by delivery status."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create broadcastMessageCounter: %v", err)
+	}
+
+	commandRejectedCounter, err = meter.Int64Counter("kbot.commands.rejected.total",
+		metric.WithDescription("Total number of commands rejected by the command registry, labelled by reason."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create commandRejectedCounter: %v", err)
+	}
+
+	stickerAddedCounter, err = meter.Int64Counter("kbot.stickers.added.total",
+		metric.WithDescription("Total number of stickers added to user sticker packs, labelled by whether the pack was created."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create stickerAddedCounter: %v", err)
+	}
+
+	invalidOptionCounter, err = meter.Int64Counter("kbot.options.invalid.total",
+		metric.WithDescription("Total number of messages rejected for invalid inline options, labelled by error type."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create invalidOptionCounter: %v", err)
+	}
+
+	batchJobCounter, err = meter.Int64Counter("kbot.batch.jobs.total",
+		metric.WithDescription("Total number of batch files turned into images, labelled by output (album or zip)."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create batchJobCounter: %v", err)
+	}
+
+	rateLimitedCounter, err = meter.Int64Counter("kbot.render.rate_limited.total",
+		metric.WithDescription("Total number of render requests rejected by the rate limit, labelled by source (telegram or http)."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create rateLimitedCounter: %v", err)
+	}
+
+	renderCacheCounter, err = meter.Int64Counter("kbot.render.cache.total",
+		metric.WithDescription("Total number of render cache lookups, labelled by cache.hit."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create renderCacheCounter: %v", err)
+	}
+
+	httpRenderCounter, err = meter.Int64Counter("kbot.http.render.total",
+		metric.WithDescription("Total number of HTTP render API requests, labelled by status code."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create httpRenderCounter: %v", err)
+	}
+
+	settingsTransferCounter, err = meter.Int64Counter("kbot.settings.transfer.total",
+		metric.WithDescription("Total number of settings exports and imports, labelled by direction and result."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create settingsTransferCounter: %v", err)
+	}
+
+	styleCounter, err = meter.Int64Counter("kbot.style.total",
+		metric.WithDescription("Total number of shared style events (share, offer, apply, not_found)."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create styleCounter: %v", err)
+	}
+
+	settingsUndoCounter, err = meter.Int64Counter("kbot.settings.undo.total",
+		metric.WithDescription("Total number of settings changes undone with /undo."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create settingsUndoCounter: %v", err)
+	}
+
+	recentCounter, err = meter.Int64Counter("kbot.recent.total",
+		metric.WithDescription("Total number of /recent lists, re-sent and regenerated images."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create recentCounter: %v", err)
+	}
+
+	moderationCounter, err = meter.Int64Counter("kbot.moderation.decisions.total",
+		metric.WithDescription("Total number of moderation decisions, by moderator, decision and source."),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create moderationCounter: %v", err)
+	}
+
 	// Гістограма (Histogram)
 	imageGenerationDuration, err = meter.Float64Histogram("kbot.image.generation.duration_seconds",
-		metric.WithDescription("Duration of image generation requests to Imgbun API."),
+		metric.WithDescription("Duration of image generation, labelled by renderer."),
 		metric.WithUnit("s"),
 	)
 	if err != nil {
@@ -204,18 +368,28 @@ var kbotCmd = &cobra.Command{
 	Aliases: []string{"start"}, // Can be run via 'go run main.go kbot' or 'go run main.go start'
 	Short:   "Starts the kbot Telegram bot",
 	Long: `Starts the kbot Telegram bot, which generates images from text
-using the Imgbun API and allows color customization.
+using the Imgbun API or a built-in renderer and allows color and layout customization.
 
 Required environment variables:
   TELE_TOKEN: Your Telegram bot token.
-  IMGBUN_API_KEY: Your API key for the Imgbun service.`,
+  IMGBUN_API_KEY: Your API key for the Imgbun service (unless KBOT_RENDERER=local).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Validate environment variables
 		if TeleToken == "" {
 			log.Fatal("Error: TELE_TOKEN environment variable not set!")
 		}
-		if ImgbunAPIKey == "" {
-			log.Fatal("Error: IMGBUN_API_KEY environment variable not set!")
+
+		// Load configuration from the environment (also checks IMGBUN_API_KEY for the imgbun renderer)
+		cfg, err := loadConfig()
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		appConfig.Store(cfg)
+		if err := loadSettingsStore(); err != nil {
+			log.Fatalf("Failed to load saved settings: %v", err)
+		}
+		if err := loadSharedStyles(); err != nil {
+			log.Fatalf("Failed to load shared styles: %v", err)
 		}
 
 		// Initialize OpenTelemetry
@@ -230,7 +404,8 @@ Required environment variables:
 		tracer = otel.Tracer(serviceName)
 		initMetrics() // Ініціалізуємо метрики після ініціалізації MeterProvider
 
-		// Initialize keyboards before creating the bot
+		// Load message catalogs and build per-locale keyboards before creating the bot
+		loadCatalogs()
 		setupKeyboards()
 
 		log.Printf("kbot %s starting...", appVersion) // appVersion should be defined in version.go
@@ -253,9 +428,41 @@ Required environment variables:
 		// --- Register Handlers ---
 		registerHandlers(kbot)
 
+		// Publish the command menu generated from the same table as the handlers
+		publishCommands(kbot)
+
+		// Close settings sessions abandoned by their users
+		startSessionJanitor(kbot)
+
+		// Persist settings and pick up a broadcast interrupted by the last shutdown
+		startSettingsPersistence()
+		resumeBroadcast(kbot)
+
+		// Serve the HTTP render API next to the bot if KBOT_HTTP_ADDR is set
+		renderAPI := startRenderAPI()
+
+		// Stop the bot gracefully on SIGINT/SIGTERM so that state is flushed to disk
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-stop
+			log.Println("Shutting down...")
+			kbot.Stop()
+		}()
+
 		// --- Start Bot ---
 		log.Println("Starting bot's main loop...")
 		kbot.Start()
+		stopRenderAPI(renderAPI)
+
+		if err := saveSettingsStore(); err != nil {
+			log.Printf("Failed to save settings store: %v", err)
+		}
+		broadcaster.mu.Lock()
+		if broadcaster.job != nil {
+			saveBroadcastJob(broadcaster.job)
+		}
+		broadcaster.mu.Unlock()
 	},
 }
 
@@ -263,16 +470,21 @@ Required environment variables:
 func registerHandlers(b *tele.Bot) {
 	// Кожен обробник тепер створює свій власний кореневий спан.
 	// Обгортка oteltelebotHandlerWrapper видалена, оскільки tele.Context не підтримує пряме вбудовування контексту.
-	b.Handle("/start", handleStart)
-	b.Handle(&btnSettings, handleSettingsEnter)
-	b.Handle("/settings", handleSettingsEnter)
-	b.Handle("/tx_color", handleSetColor)
-	b.Handle("/bg_color", handleSetColor)
-	b.Handle(&btnSaveChanges, handleSettingsSave)
-	b.Handle("/save_settings", handleSettingsSave)
-	b.Handle(&btnCancelSettings, handleSettingsCancel)
-	b.Handle("/cancel_settings", handleSettingsCancel)
+	// Middleware must be added before handlers: telebot binds it at Handle time.
+	b.Use(accessControlMiddleware)
+	registerCommands(b)
+	// Reply buttons are matched by their text, so every locale's labels are registered
+	for _, kb := range keyboards {
+		b.Handle(&kb.btnSettings, handleSettingsEnter)
+		b.Handle(&kb.btnSaveChanges, handleSettingsSave)
+		b.Handle(&kb.btnCancelSettings, handleSettingsCancel)
+	}
 	b.Handle(tele.OnText, handleTextInput)
+	b.Handle(tele.OnPhoto, handleBackgroundPhoto)
+	b.Handle(tele.OnDocument, handleBatchDocument)
+	b.Handle(&tele.Btn{Unique: styleApplyUnique}, handleApplyStyle)
+	b.Handle(&tele.Btn{Unique: recentResendUnique}, handleRecentResend)
+	b.Handle(&tele.Btn{Unique: recentRegenerateUnique}, handleRecentRegenerate)
 
 	log.Println("Handlers registered successfully.")
 }
@@ -293,12 +505,22 @@ func handleStart(c tele.Context) error {
 
 	startCmdCounter.Add(ctx, 1) // Метрика: лічильник команди /start
 	senderID := c.Sender().ID
+	locale := userLocale(c)
 	log.Printf("Received /start from %d (%s)", senderID, c.Sender().Username)
+	// Remember the user, so that announcements reach them
+	userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
 	// Reset user state in case they were in settings mode
-	exitSettingsMode(senderID) // Safely exits settings mode if user was in it
+	sessions.Exit(ctx, senderID) // Safely exits settings mode if user was in it
 	// Send welcome message with the main keyboard
-	msg := fmt.Sprintf("Hello, %s! I'm Kbot %s.\nSend me text to create an image, or press 'Settings' to customize colors.", c.Sender().FirstName, appVersion)
-	return c.Send(msg, mainMenuMarkup)
+	msg := tr(locale, "start.welcome", c.Sender().FirstName, appVersion, tr(locale, "btn.settings"))
+	if err := c.Send(msg, mainMenuFor(locale)); err != nil {
+		return err
+	}
+	// A deep link to a shared style (t.me/<bot>?start=style_<code>) is followed by the offer to apply it
+	if code, ok := strings.CutPrefix(c.Message().Payload, stylePayloadPrefix); ok {
+		return offerSharedStyle(ctx, c, span, code)
+	}
+	return nil
 }
 
 // handleSettingsEnter handles entering the settings mode (via command or button)
@@ -315,31 +537,26 @@ func handleSettingsEnter(c tele.Context) error {
 
 	settingsEnterCounter.Add(ctx, 1) // Метрика: лічильник входу в налаштування
 	senderID := c.Sender().ID
+	locale := userLocale(c)
 	log.Printf("User %d (%s) entering settings mode", senderID, c.Sender().Username)
 
 	// Load current settings or store defaults (hex without '#')
-	currentSettingsRaw, _ := userSettingsStore.LoadOrStore(senderID, UserSettings{TextColor: "000000", BgColor: "FFFFFF"})
+	currentSettingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
 	currentSettings := currentSettingsRaw.(UserSettings)
-	tempUserSettingsStore.Store(senderID, currentSettings) // Copy settings for editing
-	userInSettingsMode.Store(senderID, true)               // Set user state to 'in settings mode'
-	userWaitingFor.Store(senderID, "")                     // Reset waiting state
-
-	msg := fmt.Sprintf(`You are now in settings mode.
-Current colors: Text=#%s, Background=#%s
+	sessions.Enter(ctx, senderID, currentSettings, locale) // Copy settings into the session draft for editing
 
-Use commands or send the value after them:
-/tx_color [<value>] - text color (hex)
-/bg_color [<value>] - background color (hex)`,
-		currentSettings.TextColor, currentSettings.BgColor) // Show current colors
+	layout := currentSettings.layout()
+	msg := tr(locale, "settings.entered", currentSettings.TextColor, currentSettings.BgColor, // Show current colors and layout
+		layout.Align, layout.LineSpacing, layout.Padding, layout.MaxWidth, sizeSummary(currentSettings), fontSizeSummary(currentSettings), backgroundSummary(currentSettings), effectsSummary(currentSettings))
 
 	// Send message with the settings keyboard
-	return c.Send(msg, settingsMenuMarkup)
+	return c.Send(msg, settingsMenuFor(locale))
 }
 
-// handleSetColor handles /tx_color and /bg_color commands
-func handleSetColor(c tele.Context) error {
+// handleSetSetting handles the commands of settingFields, e.g. /tx_color or /align (registered with requireSettings)
+func handleSetSetting(c tele.Context) error {
 	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
-	ctx, span := tracer.Start(context.Background(), "handleSetColor",
+	ctx, span := tracer.Start(context.Background(), "handleSetSetting",
 		trace.WithAttributes(
 			attribute.Int64("telegram.user.id", c.Sender().ID),
 			attribute.String("telegram.user.username", c.Sender().Username),
@@ -349,85 +566,67 @@ func handleSetColor(c tele.Context) error {
 	defer span.End()
 
 	senderID := c.Sender().ID
+	locale := userLocale(c)
+
+	// The registry guarantees settings mode and tells which command (or alias) was used
+	field, _ := lookupField(invokedCommand(c))
+	commandName := "/" + field.Name
+	span.SetAttributes(attribute.String("settings.field", field.Name))
+
+	// Check if a value was provided with the command
+	if args := c.Args(); len(args) >= 1 {
+		span.SetAttributes(attribute.String("settings.value_provided", args[0]))
+		log.Printf("User %d (%s) sent command %s with value %s", senderID, c.Sender().Username, commandName, args[0])
+		return applySettingValue(ctx, c, span, field, args[0], false)
+	}
 
-	// Check if user is in settings mode
-	if !isUserInSettingsMode(senderID) {
-		log.Printf("User %d (%s) tried to set color outside settings mode.", senderID, c.Sender().Username)
-		span.AddEvent("Attempted to set color outside settings mode")
-		span.SetStatus(codes.Error, "Not in settings mode") // Виправлено: codes.Error
-		return c.Send("This command is only available in settings mode (use '⚙️ Settings' button).", mainMenuMarkup)
-	}
-
-	command := c.Message().Text
-	parts := strings.Fields(command)
-	commandName := parts[0] // e.g., /tx_color or /bg_color
-
-	var settingType string
-	var promptMsg string
-	// Determine which color is being set and prepare the prompt message
-	if strings.HasPrefix(commandName, "/tx_color") {
-		settingType = "tx_color"
-		promptMsg = "Please send the desired text color (hex, e.g., `FF0000`):"
-		span.SetAttributes(attribute.String("settings.color_type", "text_color"))
-	} else if strings.HasPrefix(commandName, "/bg_color") {
-		settingType = "bg_color"
-		promptMsg = "Please send the desired background color (hex, e.g., `FFFFFF`):"
-		span.SetAttributes(attribute.String("settings.color_type", "background_color"))
-	} else {
-		log.Printf("Unknown command '%s' received from user %d", commandName, senderID)
-		span.AddEvent("Unknown command for color setting")
-		span.SetStatus(codes.Error, "Unknown command") // Виправлено: codes.Error
-		return nil                                     // Ignore unknown command
-	}
-
-	// Check if color value was provided with the command
-	if len(parts) >= 2 {
-		colorValue := strings.TrimPrefix(parts[1], "#") // Remove '#' if present
-		span.SetAttributes(attribute.String("settings.color_value_provided", colorValue))
-		log.Printf("User %d (%s) sent command %s with value %s", senderID, c.Sender().Username, commandName, colorValue)
-
-		// Validate the hex color format
-		if !isValidHexColor(colorValue) {
-			invalidColorFormatCounter.Add(ctx, 1) // Метрика: невірний формат кольору
-			span.AddEvent("Invalid hex color format", trace.WithAttributes(attribute.String("color.value", colorValue)))
-			span.SetStatus(codes.Error, "Invalid hex color format") // Виправлено: codes.Error
-			return c.Send(fmt.Sprintf("'%s' doesn't look like a valid HEX color (3 or 6 chars, 0-9, A-F). Please try again.", colorValue), settingsMenuMarkup)
-		}
+	// If the value was NOT provided - enter waiting state
+	log.Printf("User %d (%s) sent command %s without value. Waiting for input.", senderID, c.Sender().Username, commandName)
+	waitingForInputCounter.Add(ctx, 1) // Метрика: очікування вводу
+	// Remember which setting we are waiting for
+	if _, err := sessions.Transition(ctx, senderID, field.State, nil); err != nil {
+		return handleSessionError(c, span, err)
+	}
+	span.AddEvent("Waiting for setting input from user")
+	return c.Send(tr(locale, field.promptKey()), settingsMenuFor(locale)) // Send prompt message
+}
 
-		// Load temporary settings
-		tempSettingsRaw, ok := tempUserSettingsStore.Load(senderID)
-		if !ok { // Should exist if we are in settings mode
-			log.Printf("Critical Error: Temporary settings not found for user %d in handleSetColor!", senderID)
-			span.RecordError(fmt.Errorf("temporary settings missing"))
-			span.SetStatus(codes.Error, "Internal state error") // Виправлено: codes.Error
-			exitSettingsMode(senderID)                          // Exit mode on state error
-			return c.Send("An internal state error occurred. You have been exited from settings mode.", mainMenuMarkup)
+// applySettingValue validates raw input for field and stores it in the settings draft.
+// awaited tells whether the value answers a prompt, which selects the error message.
+func applySettingValue(ctx context.Context, c tele.Context, span trace.Span, field settingField, raw string, awaited bool) error {
+	locale := userLocale(c)
+	value, ok := field.Parse(raw)
+	if !ok {
+		if field.Kind == fieldColor {
+			invalidColorFormatCounter.Add(ctx, 1) // Метрика: невірний формат кольору
 		}
-		tempSettings := tempSettingsRaw.(UserSettings)
-
-		// Update the corresponding color field
-		if settingType == "tx_color" {
-			tempSettings.TextColor = colorValue
-		} else {
-			tempSettings.BgColor = colorValue
+		span.AddEvent("Invalid setting value", trace.WithAttributes(attribute.String("settings.value", raw)))
+		span.SetStatus(codes.Error, "Invalid setting value")
+		switch {
+		case field.Kind == fieldColor && awaited:
+			return c.Send(tr(locale, "color.invalid_waiting", raw, tr(locale, "field."+field.Name)), settingsMenuFor(locale))
+		case field.Kind == fieldColor:
+			return c.Send(tr(locale, "color.invalid", raw), settingsMenuFor(locale))
 		}
+		return c.Send(tr(locale, field.invalidKey(), raw), settingsMenuFor(locale))
+	}
+	if !field.inPalette(value) {
+		span.AddEvent("Color outside the palette", trace.WithAttributes(attribute.String("settings.value", raw)))
+		span.SetStatus(codes.Error, "Color outside the palette")
+		return c.Send(tr(locale, "color.not_in_palette", field.display(value), paletteList()), settingsMenuFor(locale))
+	}
 
-		// Save updated temporary settings
-		tempUserSettingsStore.Store(senderID, tempSettings)
-		userWaitingFor.Store(senderID, "") // Reset waiting state, as value was provided
-		span.AddEvent("Color value updated in temporary settings",
-			trace.WithAttributes(attribute.String("settings.new_value", colorValue)))
-
-		return c.Send(fmt.Sprintf("Temporarily set %s: #%s. Save changes with '💾 Save Settings'.", settingType, colorValue), settingsMenuMarkup)
-
-	} else {
-		// If color value was NOT provided - enter waiting state
-		log.Printf("User %d (%s) sent command %s without value. Waiting for input.", senderID, c.Sender().Username, commandName)
-		waitingForInputCounter.Add(ctx, 1)          // Метрика: очікування вводу
-		userWaitingFor.Store(senderID, settingType) // Store which color we are waiting for
-		span.AddEvent("Waiting for color input from user")
-		return c.Send(promptMsg, settingsMenuMarkup) // Send prompt message
+	// Update the draft and reset any waiting state, as the value was provided
+	if _, err := sessions.Transition(ctx, c.Sender().ID, StateSettings, func(draft *UserSettings) {
+		field.Apply(draft, value)
+	}); err != nil {
+		return handleSessionError(c, span, err)
 	}
+	span.AddEvent("Setting value updated in temporary settings",
+		trace.WithAttributes(attribute.String("settings.new_value", value)))
+
+	log.Printf("Temporarily set %s: %s for user %d (%s)", field.Name, value, c.Sender().ID, c.Sender().Username)
+	return c.Send(tr(locale, "settings.temporarily_set", tr(locale, "field."+field.Name), field.display(value), tr(locale, "btn.save")), settingsMenuFor(locale))
 }
 
 // handleSettingsSave handles saving the settings (via command or button)
@@ -444,39 +643,29 @@ func handleSettingsSave(c tele.Context) error {
 
 	settingsSaveCounter.Add(ctx, 1) // Метрика: лічильник збереження налаштувань
 	senderID := c.Sender().ID
+	locale := userLocale(c)
 
-	// Check if user is in settings mode
-	if !isUserInSettingsMode(senderID) {
+	// Exit settings mode; the draft comes back with the session
+	session, inSettings := sessions.Exit(ctx, senderID)
+	if !inSettings {
 		log.Printf("User %d (%s) tried to save settings while not in settings mode.", senderID, c.Sender().Username)
 		span.AddEvent("Attempted to save settings outside settings mode")
 		span.SetStatus(codes.Error, "Not in settings mode") // Виправлено: codes.Error
-		return c.Send("You are not in settings mode.", mainMenuMarkup)
+		return c.Send(tr(locale, "settings.not_in_settings_mode"), mainMenuFor(locale))
 	}
 
-	// Load temporary settings
-	tempSettingsRaw, ok := tempUserSettingsStore.Load(senderID)
-	if !ok {
-		log.Printf("Error: Temporary settings not found for user %d during save.", senderID)
-		span.RecordError(fmt.Errorf("temporary settings missing during save"))
-		span.SetStatus(codes.Error, "Internal state error on save") // Виправлено: codes.Error
-		exitSettingsMode(senderID)                                  // Exit mode anyway
-		return c.Send("An internal error occurred while saving. You have been exited from settings mode.", mainMenuMarkup)
-	}
-
-	// Save temporary settings as permanent
-	savedSettings := tempSettingsRaw.(UserSettings)
-	userSettingsStore.Store(senderID, savedSettings)
-
-	// Exit settings mode
-	exitSettingsMode(senderID)
+	// Save the draft as permanent settings
+	savedSettings := session.Draft
+	storeUserSettings(senderID, savedSettings, changeSave)
 
 	span.SetAttributes(
 		attribute.String("settings.text_color.saved", savedSettings.TextColor),
 		attribute.String("settings.background_color.saved", savedSettings.BgColor),
 	)
 	log.Printf("User %d (%s) saved settings: Text=#%s, BG=#%s", senderID, c.Sender().Username, savedSettings.TextColor, savedSettings.BgColor)
-	// Send confirmation with the main keyboard
-	return c.Send("Settings saved successfully!", mainMenuMarkup)
+	// Send confirmation with the main keyboard (the saved settings may carry a new locale)
+	locale = userLocale(c)
+	return c.Send(tr(locale, "settings.saved"), mainMenuFor(locale))
 }
 
 // handleSettingsCancel handles cancelling the settings mode (via command or button)
@@ -493,18 +682,79 @@ func handleSettingsCancel(c tele.Context) error {
 
 	settingsCancelCounter.Add(ctx, 1) // Метрика: лічильник скасування налаштувань
 	senderID := c.Sender().ID
+	locale := userLocale(c)
 
-	if !isUserInSettingsMode(senderID) {
+	// Exit mode and discard the draft
+	if _, inSettings := sessions.Exit(ctx, senderID); !inSettings {
 		log.Printf("User %d (%s) tried to cancel settings while not in settings mode.", senderID, c.Sender().Username)
 		span.AddEvent("Attempted to cancel settings outside settings mode")
 		span.SetStatus(codes.Error, "Not in settings mode") // Виправлено: codes.Error
-		return c.Send("You are not currently in settings mode.", mainMenuMarkup)
+		return c.Send(tr(locale, "settings.not_currently_in_settings_mode"), mainMenuFor(locale))
 	}
 
 	log.Printf("User %d (%s) cancelled settings mode.", senderID, c.Sender().Username)
-	exitSettingsMode(senderID) // Exit mode and discard temporary changes
 	span.AddEvent("Settings mode cancelled")
-	return c.Send("Settings mode cancelled. Temporary changes have been discarded.", mainMenuMarkup)
+	return c.Send(tr(locale, "settings.cancelled"), mainMenuFor(locale))
+}
+
+// handleLang handles the /lang command: shows, sets or resets the user's locale override
+func handleLang(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleLang",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	available := strings.Join(availableLocales(), ", ")
+	args := c.Args()
+
+	// Without arguments just report the current locale
+	if len(args) == 0 {
+		return c.Send(tr(locale, "lang.current", locale, available), mainMenuFor(locale))
+	}
+
+	requested := strings.ToLower(args[0])
+	override := ""
+	if requested != "auto" {
+		override = normalizeLocale(requested)
+		if override == "" {
+			span.SetStatus(codes.Error, "Unsupported locale")
+			return c.Send(tr(locale, "lang.unsupported", args[0], available), mainMenuFor(locale))
+		}
+	}
+	span.SetAttributes(attribute.String("settings.lang", requested))
+
+	// Store the override in permanent settings and in the draft, so saving settings later keeps it
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	settings := settingsRaw.(UserSettings)
+	settings.Lang = override
+	storeUserSettings(senderID, settings, changeLang)
+	session := sessions.Get(senderID)
+	if session.State.InSettings() {
+		// Stay in the current state, only the draft changes
+		if _, err := sessions.Transition(ctx, senderID, session.State, func(draft *UserSettings) {
+			draft.Lang = override
+		}); err != nil {
+			log.Printf("Could not update language in settings draft for user %d: %v", senderID, err)
+		}
+	}
+	log.Printf("User %d (%s) set language override to %q", senderID, c.Sender().Username, override)
+
+	locale = userLocale(c)
+	markup := mainMenuFor(locale)
+	if session.State.InSettings() {
+		markup = settingsMenuFor(locale)
+	}
+	if override == "" {
+		return c.Send(tr(locale, "lang.auto"), markup)
+	}
+	return c.Send(tr(locale, "lang.set"), markup)
 }
 
 // handleTextInput is the main handler for text messages
@@ -522,223 +772,237 @@ func handleTextInput(c tele.Context) error {
 	senderID := c.Sender().ID
 	text := c.Text()
 	username := c.Sender().Username
+	locale := userLocale(c)
 
 	span.SetAttributes(attribute.String("telegram.input_text", text))
 
-	// --- 1. Check if waiting for color input ---
-	waitingForRaw, userIsWaiting := userWaitingFor.Load(senderID)
-	if userIsWaiting {
-		if waitingFor, isString := waitingForRaw.(string); isString && waitingFor != "" {
-			span.AddEvent("User is in waiting state for color input")
-			log.Printf("User %d (%s) sent value '%s', expecting input for %s", senderID, username, text, waitingFor)
-			colorValue := strings.TrimPrefix(text, "#") // Get color value, remove '#'
-			span.SetAttributes(attribute.String("settings.color_input_value", colorValue))
-
-			// Validate hex color
-			if !isValidHexColor(colorValue) {
-				invalidColorFormatCounter.Add(ctx, 1) // Метрика: невірний формат кольору
-				span.AddEvent("Invalid hex color format in waiting state", trace.WithAttributes(attribute.String("color.value", colorValue)))
-				span.SetStatus(codes.Error, "Invalid hex color format") // Виправлено: codes.Error
-				return c.Send(fmt.Sprintf("'%s' doesn't look like a valid HEX color (3 or 6 chars, 0-9, A-F). Please send a correct color value for %s:", text, waitingFor), settingsMenuMarkup)
-			}
-
-			// Load temporary settings
-			tempSettingsRaw, ok := tempUserSettingsStore.Load(senderID)
-			if !ok {
-				log.Printf("Critical Error: User %d was waiting for input, but temporary settings are missing!", senderID)
-				span.RecordError(fmt.Errorf("temporary settings missing in waiting state"))
-				span.SetStatus(codes.Error, "Internal state error") // Виправлено: codes.Error
-				exitSettingsMode(senderID)                          // Exit mode on state error
-				return c.Send("A state error occurred. You have been exited from settings mode.", mainMenuMarkup)
-			}
-			tempSettings := tempSettingsRaw.(UserSettings)
-
-			// Update the correct color field
-			settingType := waitingFor // "tx_color" or "bg_color"
-			if settingType == "tx_color" {
-				tempSettings.TextColor = colorValue
-			} else if settingType == "bg_color" {
-				tempSettings.BgColor = colorValue
-			}
-
-			// Save updated temporary settings
-			tempUserSettingsStore.Store(senderID, tempSettings)
-			userWaitingFor.Store(senderID, "") // Reset waiting state
-			span.AddEvent("Color value updated in temporary settings from input",
-				trace.WithAttributes(attribute.String("settings.new_value", colorValue)))
-
-			log.Printf("Temporarily set %s: #%s for user %d (%s)", settingType, colorValue, senderID, username)
-			return c.Send(fmt.Sprintf("Temporarily set %s: #%s. Save changes with '💾 Save Settings'.", settingType, colorValue), settingsMenuMarkup)
-		}
+	session := sessions.Get(senderID)
+	span.SetAttributes(attribute.String("session.state", string(session.State)))
+
+	// --- 1. Check if waiting for a setting value ---
+	if field, isWaiting := session.State.awaitedSetting(); isWaiting {
+		span.AddEvent("User is in waiting state for setting input")
+		log.Printf("User %d (%s) sent value '%s', expecting input for %s", senderID, username, text, field.Name)
+		span.SetAttributes(attribute.String("settings.input_value", text))
+		return applySettingValue(ctx, c, span, field, text, true)
 	}
 
 	// --- 2. Check if in settings mode (but not waiting for input) ---
-	if isUserInSettingsMode(senderID) {
+	if session.State.InSettings() {
 		unrecognizedTextCounter.Add(ctx, 1) // Метрика: нерозпізнаний текст
 		span.AddEvent("Unrecognized text while in settings mode")
 		log.Printf("User %d (%s) sent unrecognized text '%s' while in settings mode", senderID, username, text)
 		// Ignore unrecognized text or prompt user
-		return c.Send("Please use the commands /tx_color, /bg_color or the 'Save Settings' / 'Cancel & Exit' buttons.", settingsMenuMarkup)
+		return c.Send(tr(locale, "settings.unrecognized", tr(locale, "btn.save"), tr(locale, "btn.cancel")), settingsMenuFor(locale))
 	}
 
 	// --- 3. If not in settings mode and not waiting for input - generate image ---
 	log.Printf("User %d (%s) sent text '%s' for image generation", senderID, username, text)
-	return generateAndSendImage(ctx, c) // Викликаємо generateAndSendImage, передаючи контекст
+	return generateWithOptions(ctx, c, span, text) // Leading --options override the settings for this image
+}
+
+// handleBackgroundPhoto handles photos: in settings mode the photo becomes the image background
+func handleBackgroundPhoto(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleBackgroundPhoto",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	if !sessions.Get(senderID).State.InSettings() {
+		span.AddEvent("Photo received outside settings mode")
+		return c.Send(tr(locale, "background.photo_outside_settings", tr(locale, "btn.settings")), mainMenuFor(locale))
+	}
+
+	// Telegram sends several sizes; telebot keeps the largest one. Only its file_id is stored.
+	fileID := c.Message().Photo.FileID
+	span.SetAttributes(attribute.String("settings.bg_photo", fileID))
+	if _, err := sessions.Transition(ctx, senderID, StateSettings, func(draft *UserSettings) {
+		draft.BgPhoto = fileID
+		draft.BgMode = bgPhoto
+	}); err != nil {
+		return handleSessionError(c, span, err)
+	}
+	log.Printf("Temporarily set background photo for user %d (%s)", senderID, c.Sender().Username)
+	return c.Send(tr(locale, "background.photo_set", tr(locale, "btn.save")), settingsMenuFor(locale))
 }
 
-// generateAndSendImage generates image via Imgbun and sends it to the user
-func generateAndSendImage(ctx context.Context, c tele.Context) error { // Приймаємо контекст
+// generateAndSendImage renders text with the configured generator and sends it to the user.
+// adjust, if not nil, changes the saved settings for this image only (e.g. /img options).
+func generateAndSendImage(ctx context.Context, c tele.Context, text string, adjust func(s *UserSettings)) error { // Приймаємо контекст
 	// Ця функція вже викликається з контекстом, що містить батьківський спан.
 	// Тут створюємо дочірній спан для операції генерації зображення.
 	ctx, span := tracer.Start(ctx, "generateAndSendImage")
 	defer span.End()
 
-	imageGenRequestCounter.Add(ctx, 1) // Метрика: запит на генерацію зображення
-	startTime := time.Now()            // Початок вимірювання тривалості
-
 	senderID := c.Sender().ID
-	text := c.Text()
 	username := c.Sender().Username
+	locale := userLocale(c)
+	mainMenu := mainMenuFor(locale)
+	generator := currentGenerator()
+
+	if ok, wait := renderLimiter.Allow(ctx, telegramRequester(senderID), "telegram"); !ok {
+		span.AddEvent("Rate limited")
+		return c.Send(tr(locale, "image.rate_limited", retrySeconds(wait)), mainMenu)
+	}
+	if blocked, err := moderationBlocked(ctx, c, text, sourceImage); blocked {
+		span.SetStatus(codes.Error, "Blocked by moderation")
+		return err
+	}
 
 	span.SetAttributes(
 		attribute.String("image.text_input", text),
+		attribute.String("image.renderer", generator.Name()),
 	)
 
 	// Load user settings (or defaults)
-	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, UserSettings{TextColor: "000000", BgColor: "FFFFFF"})
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
 	currentSettings := settingsRaw.(UserSettings)
-	// Ensure colors don't have '#' (they shouldn't if saved correctly)
-	textColorHex := strings.TrimPrefix(currentSettings.TextColor, "#")
-	bgColorHex := strings.TrimPrefix(currentSettings.BgColor, "#")
+	if adjust != nil {
+		adjust(&currentSettings)
+	}
+	layout := currentSettings.layout()
 
 	span.SetAttributes(
-		attribute.String("image.text_color", textColorHex),
-		attribute.String("image.background_color", bgColorHex),
-	)
-
-	// Construct the Imgbun API URL
-	// Reference: https://api.imgbun.com/png?key={API Key}&text=some_text&color=tx_color&background=bg_color&size=16&format=json
-	apiURL := fmt.Sprintf("https://api.imgbun.com/png?key=%s&text=%s&color=%s&background=%s&size=%s&format=json",
-		url.QueryEscape(ImgbunAPIKey), // API Key
-		url.QueryEscape(text),         // Text from user
-		url.QueryEscape(textColorHex), // Text color from settings
-		url.QueryEscape(bgColorHex),   // Background color from settings
-		"16",                          // Font size (fixed)
+		attribute.String("image.text_color", currentSettings.TextColor),
+		attribute.String("image.background_color", currentSettings.BgColor),
+		attribute.String("image.align", layout.Align),
+		attribute.Float64("image.line_spacing", layout.LineSpacing),
+		attribute.Int("image.padding", layout.Padding),
+		attribute.Int("image.max_width", layout.MaxWidth),
+		attribute.String("image.size", currentSettings.Size),
 	)
 
-	log.Printf("Forming Imgbun API request for user %d (%s)...", senderID, username)
-	span.AddEvent("Imgbun API request formed")
-
-	// Create HTTP request with OpenTelemetry transport for automatic tracing
-	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil) // Використовуємо контекст з span
-	if err != nil {
-		log.Printf("Error creating Imgbun HTTP request for user %d: %v", senderID, err)
-		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "request_creation"))) // Метрика: помилка
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to create HTTP request") // Виправлено: codes.Error
-		return c.Send("Failed to generate image: could not create request.", mainMenuMarkup)
-	}
-	req.Header.Set("User-Agent", fmt.Sprintf("kbot/%s", appVersion)) // Set User-Agent
-
-	// Wrap the default HTTP client with otelhttp transport
-	client := &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: 20 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Error executing Imgbun HTTP request for user %d: %v", senderID, err)
-		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "network_error"))) // Метрика: помилка
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "Network error or service unavailable") // Виправлено: codes.Error
-		return c.Send("Failed to generate image: network error or service unavailable.", mainMenuMarkup)
-	}
-	defer resp.Body.Close() // Ensure body is closed
-
-	// Check HTTP status code
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("Imgbun API returned non-OK status (%d) for user %d", resp.StatusCode, senderID)
-		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "api_http_error"), attribute.Int("http.status_code", resp.StatusCode))) // Метрика: помилка
-		span.SetStatus(codes.Error, fmt.Sprintf("Imgbun API returned non-OK status: %d", resp.StatusCode))                                                              // Виправлено: codes.Error
-		return c.Send(fmt.Sprintf("Failed to generate image: service returned error %d.", resp.StatusCode), mainMenuMarkup)
-	}
-
-	// Decode JSON response
-	var imgbunResp ImgbunResponse
-	if err := json.NewDecoder(resp.Body).Decode(&imgbunResp); err != nil {
-		log.Printf("Error decoding Imgbun JSON response for user %d: %v", senderID, err)
-		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "json_decode_error"))) // Метрика: помилка
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to decode JSON response") // Виправлено: codes.Error
-		return c.Send("Failed to process response from image service.", mainMenuMarkup)
-	}
-
-	// Check 'status' field in JSON response (should be "OK")
-	if imgbunResp.Status != "OK" {
-		log.Printf("Error in Imgbun JSON response for user %d: status=%s, message=%s", senderID, imgbunResp.Status, imgbunResp.Message)
-		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "api_logic_error"), attribute.String("api.message", imgbunResp.Message))) // Метрика: помилка
-		errMsg := "Failed to generate image."
-		if imgbunResp.Message != "" {
-			errMsg += fmt.Sprintf(" Service message: %s", imgbunResp.Message)
+	span.SetAttributes(attribute.String("image.background_mode", currentSettings.BgMode))
+	req := RenderRequest{Text: text, Settings: currentSettings}
+	if currentSettings.BgMode == bgPhoto && currentSettings.BgPhoto != "" {
+		// A photo that can no longer be downloaded falls back to the background color
+		photo, err := loadBackgroundPhoto(ctx, c.Bot(), currentSettings.BgPhoto)
+		if err != nil {
+			log.Printf("Using background color for user %d: %v", senderID, err)
+			span.AddEvent("Background photo unavailable")
 		}
-		span.SetStatus(codes.Error, fmt.Sprintf("Imgbun API status not OK: %s", imgbunResp.Message)) // Виправлено: codes.Error
-		return c.Send(errMsg, mainMenuMarkup)
+		req.Photo = photo
 	}
 
-	// Check if direct link is present
-	if imgbunResp.DirectLink == "" {
-		log.Printf("Error: Imgbun API returned OK but no direct link for user %d", senderID)
-		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "no_image_link"))) // Метрика: помилка
-		span.SetStatus(codes.Error, "Imgbun API returned no direct link")                                          // Виправлено: codes.Error
-		return c.Send("Image service returned success but did not provide an image link.", mainMenuMarkup)
+	log.Printf("Generating image with %s renderer for user %d (%s)...", generator.Name(), senderID, username)
+	rendered, rerr := renderImage(ctx, generator, req)
+	if rerr != nil {
+		log.Printf("Image generation failed for user %d: %v", senderID, rerr)
+		span.RecordError(rerr)
+		span.SetStatus(codes.Error, rerr.Error())
+		return c.Send(tr(locale, rerr.Key, rerr.Args...), mainMenu)
 	}
 
-	// Метрика: тривалість генерації зображення
-	// Виправлено: Додаємо attributes як окремий аргумент
-	imageGenerationDuration.Record(ctx, time.Since(startTime).Seconds(),
-		metric.WithAttributes(attribute.Bool("success", true)),
-	)
-	imageGenSuccessCounter.Add(ctx, 1) // Метрика: успішна генерація
-
 	// Create Photo object to send
 	photoToSend := &tele.Photo{
-		File:    tele.FromURL(imgbunResp.DirectLink),
-		Caption: fmt.Sprintf("Image for: '%s'", text), // Add caption
+		File:    tele.FromURL(rendered.URL),
+		Caption: imageCaption(locale, text), // Add caption
 	}
-	// Trim caption if too long (Telegram limit is 1024)
-	if len(photoToSend.Caption) > 1024 {
-		photoToSend.Caption = photoToSend.Caption[:1020] + "..."
+	if rendered.PNG != nil {
+		photoToSend.File = tele.FromReader(bytes.NewReader(rendered.PNG))
 	}
 
-	span.SetAttributes(attribute.String("image.direct_link", imgbunResp.DirectLink))
-	log.Printf("Sending generated image %s to user %d (%s)", imgbunResp.DirectLink, senderID, username)
+	log.Printf("Sending generated image to user %d (%s)", senderID, username)
 
-	// Send the photo with the main keyboard
-	if err := c.Send(photoToSend, mainMenuMarkup); err != nil {
+	// Send the photo with the main keyboard; the sent message holds the file_id kept for /recent
+	sent, err := c.Bot().Send(c.Recipient(), photoToSend, mainMenu)
+	if err != nil {
 		log.Printf("Error sending photo to user %d: %v", senderID, err)
 		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "telegram_send_error"))) // Метрика: помилка
 		span.RecordError(err)
 		span.SetStatus(codes.Error, "Failed to send photo to Telegram") // Виправлено: codes.Error
 		// Attempt to send a text message if photo sending fails
-		return c.Send("Failed to send the generated image.", mainMenuMarkup)
+		return c.Send(tr(locale, "image.error.send"), mainMenu)
+	}
+	if sent.Photo != nil {
+		recentImages.add(senderID, generationRecord{Text: text, Settings: currentSettings, FileID: sent.Photo.FileID, CreatedAt: time.Now().UTC()})
 	}
 	return nil // Return nil on successful send
 }
 
-// isUserInSettingsMode checks if a user is currently in settings mode
-func isUserInSettingsMode(userID int64) bool {
-	inSettingsRaw, ok := userInSettingsMode.Load(userID)
-	if !ok {
-		return false // Not in map means not in settings mode
+// maxCaptionLength is Telegram's caption limit, counted in UTF-16 code units
+const maxCaptionLength = 1024
+
+// imageCaption returns the caption of a generated image, trimmed to Telegram's limit
+func imageCaption(locale, text string) string {
+	return trimCaption(tr(locale, "image.caption", text))
+}
+
+// trimCaption cuts a caption to maxCaptionLength between runes, ending it with "…"
+func trimCaption(caption string) string {
+	if len(utf16.Encode([]rune(caption))) <= maxCaptionLength {
+		return caption
+	}
+	units := 0
+	for i, r := range caption {
+		if units += utf16.RuneLen(r); units > maxCaptionLength-1 { // Room for the ellipsis
+			return caption[:i] + "…"
+		}
+	}
+	return caption
+}
+
+// renderImage generates req with generator, or takes it from renderCache, and records the generation metrics.
+// The colors are kept to the palette and the watermark is drawn before caching.
+// extra attributes (e.g. image.output) are added to every metric.
+func renderImage(ctx context.Context, generator ImageGenerator, req RenderRequest, extra ...attribute.KeyValue) (RenderedImage, *renderError) {
+	imageGenRequestCounter.Add(ctx, 1, metric.WithAttributes(extra...)) // Метрика: запит на генерацію зображення
+	startTime := time.Now()                                             // Початок вимірювання тривалості
+
+	req.Settings = brandSettings(req.Settings)
+	key, cacheable := cacheKey(generator.Name(), req)
+	if cacheable {
+		cached, hit := renderCache.Get(key)
+		renderCacheCounter.Add(ctx, 1, metric.WithAttributes(append([]attribute.KeyValue{attribute.Bool("cache.hit", hit)}, extra...)...))
+		if hit {
+			imageGenSuccessCounter.Add(ctx, 1, metric.WithAttributes(extra...))
+			cached.Cached = true
+			return cached, nil
+		}
+	}
+
+	rendered, err := generator.Generate(ctx, req)
+	if err == nil {
+		if rendered, err = watermarkRendered(ctx, rendered); err != nil {
+			err = &renderError{Type: "watermark_error", Key: "image.error.failed", Err: err}
+		}
+	}
+	if err != nil {
+		rerr, ok := err.(*renderError)
+		if !ok {
+			rerr = &renderError{Type: "unknown", Key: "image.error.failed", Err: err}
+		}
+		attrs := append([]attribute.KeyValue{attribute.String("error.type", rerr.Type)}, rerr.Attrs...)
+		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(append(attrs, extra...)...)) // Метрика: помилка
+		return RenderedImage{}, rerr
+	}
+
+	// Метрика: тривалість генерації зображення
+	imageGenerationDuration.Record(ctx, time.Since(startTime).Seconds(),
+		metric.WithAttributes(append([]attribute.KeyValue{attribute.Bool("success", true), attribute.String("image.renderer", generator.Name())}, extra...)...),
+	)
+	imageGenSuccessCounter.Add(ctx, 1, metric.WithAttributes(extra...)) // Метрика: успішна генерація
+	if cacheable {
+		renderCache.Put(key, rendered)
 	}
-	// Safely assert type to bool
-	inSettings, isBool := inSettingsRaw.(bool)
-	return isBool && inSettings
+	return rendered, nil
 }
 
-// exitSettingsMode safely transitions a user out of settings mode
-func exitSettingsMode(userID int64) {
-	userInSettingsMode.Store(userID, false) // Set mode to false
-	userWaitingFor.Store(userID, "")        // Clear waiting state
-	tempUserSettingsStore.Delete(userID)    // Remove temporary settings data
-	log.Printf("User %d exited settings mode.", userID)
+// handleSessionError reports a rejected session transition (e.g. the user left settings
+// mode concurrently) and makes sure the user ends up back in the main menu
+func handleSessionError(c tele.Context, span trace.Span, err error) error {
+	log.Printf("Session error for user %d: %v", c.Sender().ID, err)
+	span.RecordError(err)
+	span.SetStatus(codes.Error, "Invalid session transition")
+	sessions.Exit(context.Background(), c.Sender().ID)
+	locale := userLocale(c)
+	return c.Send(tr(locale, "settings.state_error"), mainMenuFor(locale))
 }
 
 // isValidHexColor performs basic validation for 3 or 6 character hex colors
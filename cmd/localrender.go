@@ -0,0 +1,203 @@
+// kbot-app/cmd/localrender.go
+// This file contains the pure-Go image generator that draws text locally, without external services.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// localFontSize is the font size of locally rendered text, in pixels
+const localFontSize = 48
+
+// localGenerator draws text with the built-in Go font and the fallback fonts (see fonts.go) and encodes the result as PNG
+type localGenerator struct{}
+
+func (localGenerator) Name() string { return rendererLocal }
+
+func (localGenerator) Generate(ctx context.Context, req RenderRequest) (RenderedImage, error) {
+	scene, err := newLocalScene(req, req.Settings.fontSize(localFontSize))
+	if err != nil {
+		return RenderedImage{}, err
+	}
+	defer scene.Close()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scene.frame(-1, 1, scene.textColor)); err != nil {
+		return RenderedImage{}, &renderError{Type: "encode_error", Key: "image.error.failed", Err: err}
+	}
+	return RenderedImage{PNG: buf.Bytes()}, nil
+}
+
+// localScene is a laid-out text with its resolved style; frames of an animation share one scene
+type localScene struct {
+	face       font.Face
+	layout     textLayout
+	lines      []string
+	textWidth  int         // Width of the widest line
+	textHeight int         // Height of all lines
+	blockWidth int         // Width lines are aligned in: the text width, or the content width of a fixed canvas
+	origin     image.Point // Top left corner of the text block
+	background *image.RGBA // Drawn once, copied into every frame
+	textColor  color.RGBA
+	effects    textEffects
+}
+
+// newLocalScene resolves the settings, lays the text out and draws the background; the caller must close it.
+// With a fixed canvas size the font size is chosen to fill the canvas and fontSize is ignored.
+func newLocalScene(req RenderRequest, fontSize float64) (*localScene, error) {
+	textColor, err := parseHexColor(req.Settings.TextColor)
+	if err != nil {
+		return nil, &renderError{Type: "invalid_settings", Key: "image.error.failed", Err: err}
+	}
+	background, err := req.Settings.background()
+	if err != nil {
+		return nil, &renderError{Type: "invalid_settings", Key: "image.error.failed", Err: err}
+	}
+	effects, err := req.Settings.effects()
+	if err != nil {
+		return nil, &renderError{Type: "invalid_settings", Key: "image.error.failed", Err: err}
+	}
+	layout := req.Settings.layout()
+	if layout.Width > 0 {
+		if fontSize, err = fitFontSize(req.Text, layout); err != nil {
+			return nil, &renderError{Type: "font_error", Key: "image.error.failed", Err: err}
+		}
+	}
+	face, err := localFace(fontSize)
+	if err != nil {
+		return nil, &renderError{Type: "font_error", Key: "image.error.failed", Err: err}
+	}
+
+	scene := &localScene{face: face, layout: layout, textColor: textColor, effects: effects}
+	bounds := scene.layoutText(req.Text)
+	scene.background = image.NewRGBA(bounds)
+	drawBackground(scene.background, background, req.Photo)
+	return scene, nil
+}
+
+// Close releases the font face
+func (s *localScene) Close() {
+	s.face.Close()
+}
+
+// frame draws the first visible runes of the text (-1 draws all) with the given opacity and color
+func (s *localScene) frame(visible int, alpha float64, textColor color.RGBA) *image.RGBA {
+	img := image.NewRGBA(s.background.Bounds())
+	copy(img.Pix, s.background.Pix)
+	mask := s.drawMask(visible)
+	if alpha < 1 {
+		for i, a := range mask.Pix {
+			mask.Pix[i] = uint8(float64(a) * alpha)
+		}
+	}
+	textRect := image.Rect(0, 0, s.blockWidth, s.textHeight).Add(s.origin)
+	drawText(img, mask, textColor, s.effects, textRect, s.layout.Padding)
+	return img
+}
+
+// layoutText wraps the text and returns the bounds of the image.
+// Explicit line breaks are kept; longer lines are word-wrapped to fit MaxWidth (or the canvas width) minus padding.
+// On a fixed canvas the text block is centered vertically. Lines are kept in logical order; drawMask reorders them for display.
+func (s *localScene) layoutText(text string) image.Rectangle {
+	text = shapeArabic(normalizeText(text))
+	contentWidth := s.layout.MaxWidth - 2*s.layout.Padding
+	if s.layout.Width > 0 {
+		contentWidth = s.layout.Width - 2*s.layout.Padding
+	}
+	if minWidth := font.MeasureString(s.face, "W").Ceil(); contentWidth < minWidth {
+		contentWidth = minWidth // Always room for at least one glyph
+	}
+	s.lines = wrapText(s.face, text, fixed.I(contentWidth))
+
+	s.textWidth = 0
+	for _, line := range s.lines {
+		if w := font.MeasureString(s.face, line).Ceil(); w > s.textWidth {
+			s.textWidth = w
+		}
+	}
+
+	metrics := s.face.Metrics()
+	s.textHeight = metrics.Ascent.Ceil() + metrics.Descent.Ceil() + (len(s.lines)-1)*s.lineStep()
+	if s.layout.Width > 0 {
+		s.blockWidth = max(contentWidth, s.textWidth)
+		s.origin = image.Pt(s.layout.Padding, (s.layout.Height-s.textHeight)/2)
+		return image.Rect(0, 0, s.layout.Width, s.layout.Height)
+	}
+	s.blockWidth = s.textWidth
+	s.origin = image.Pt(s.layout.Padding, s.layout.Padding)
+	return image.Rect(0, 0, s.textWidth+2*s.layout.Padding, s.textHeight+2*s.layout.Padding)
+}
+
+// lineStep is the distance between baselines
+func (s *localScene) lineStep() int {
+	return int(float64(s.face.Metrics().Height.Ceil()) * s.layout.LineSpacing)
+}
+
+// drawMask draws the first visible runes of the laid-out text (-1 draws all) into an alpha mask the size of the image
+func (s *localScene) drawMask(visible int) *image.Alpha {
+	mask := image.NewAlpha(s.background.Bounds())
+	drawer := &font.Drawer{Dst: mask, Src: image.Opaque, Face: s.face}
+	ascent := s.face.Metrics().Ascent.Ceil()
+	for i, line := range s.lines {
+		x := s.origin.X
+		switch lineWidth := font.MeasureString(s.face, line).Ceil(); s.layout.Align {
+		case alignCenter:
+			x += (s.blockWidth - lineWidth) / 2
+		case alignRight:
+			x += s.blockWidth - lineWidth
+		}
+		if visible >= 0 {
+			runes := []rune(line)
+			if visible < len(runes) {
+				line = string(runes[:visible])
+			}
+			visible = max(0, visible-len(runes))
+		}
+		line = visualOrder(line)
+		drawer.Dot = fixed.P(x, s.origin.Y+ascent+i*s.lineStep())
+		drawer.DrawString(line)
+	}
+	return mask
+}
+
+// wrapText splits text into lines no wider than maxWidth. Paragraphs (explicit line breaks)
+// are kept, words are wrapped greedily and words wider than a line are broken between runes.
+func wrapText(face font.Face, text string, maxWidth fixed.Int26_6) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate) <= maxWidth {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+			// The word starts a new line; break it up if it does not fit on its own
+			line = ""
+			for _, r := range word {
+				if line != "" && font.MeasureString(face, line+string(r)) > maxWidth {
+					lines = append(lines, line)
+					line = ""
+				}
+				line += string(r)
+			}
+		}
+		lines = append(lines, line) // An empty paragraph becomes an empty line
+	}
+	return lines
+}
@@ -0,0 +1,38 @@
+// kbot-app/cmd/main_test.go
+// This file contains the test setup shared by the package tests: catalogs, keyboards, no-op
+// telemetry and a default configuration with the built-in renderer.
+
+package cmd
+
+import (
+	"log"
+	"os"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+)
+
+func TestMain(m *testing.M) {
+	loadCatalogs()
+	setupKeyboards()
+	// Spans and metrics go to the global no-op providers
+	tracer = otel.Tracer(serviceName)
+	initMetrics()
+
+	os.Setenv("KBOT_RENDERER", rendererLocal)
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load the test configuration: %v", err)
+	}
+	appConfig.Store(cfg)
+
+	os.Exit(m.Run())
+}
+
+// useConfig makes cfg the active configuration until the test ends
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	previous := currentConfig()
+	appConfig.Store(cfg)
+	t.Cleanup(func() { appConfig.Store(previous) })
+}
@@ -0,0 +1,423 @@
+// kbot-app/cmd/moderation.go
+// This file contains the moderation step run on every text before it is drawn: a built-in word list
+// that sees through leet-speak and look-alike letters, and an optional webhook to an external service.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/text/unicode/norm"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Moderation decisions, from the mildest; the strictest decision of all moderators wins
+const (
+	decisionAllow = "allow"
+	decisionFlag  = "flag"  // Drawn, but logged and reported to the admins
+	decisionBlock = "block" // Refused
+)
+
+// decisionRank orders the decisions by strictness
+var decisionRank = map[string]int{decisionAllow: 0, decisionFlag: 1, decisionBlock: 2}
+
+// Sources of moderated texts, sent to the webhook and added to the metrics
+const (
+	sourceImage     = "image"
+	sourceAnimation = "animation"
+	sourceSticker   = "sticker"
+	sourceBatch     = "batch"
+	sourceHTTP      = "http"
+)
+
+// moderationRequest is a text about to be drawn
+type moderationRequest struct {
+	Text   string `json:"text"`
+	Source string `json:"source"`            // Where the text came from, e.g. "image" or "http"
+	UserID int64  `json:"user_id,omitempty"` // Telegram sender, unset for the HTTP API
+	ChatID int64  `json:"chat_id,omitempty"`
+}
+
+// moderationResult is the decision on a text
+type moderationResult struct {
+	Decision  string `json:"decision"`
+	Reason    string `json:"reason,omitempty"` // Shown to admins and in logs, never to users
+	Moderator string `json:"-"`                // Name of the moderator that decided
+}
+
+// Moderator decides whether a text may be drawn
+type Moderator interface {
+	Name() string
+	Moderate(ctx context.Context, req moderationRequest) (moderationResult, error)
+}
+
+// loadModeration reads the word list and the webhook into cfg
+func loadModeration(cfg *Config) error {
+	var err error
+	if cfg.ModerationAction, err = envChoice("KBOT_MODERATION_ACTION", decisionBlock, decisionFlag, decisionBlock); err != nil {
+		return err
+	}
+	if cfg.ModerationOnError, err = envChoice("KBOT_MODERATION_ON_ERROR", decisionAllow, decisionAllow, decisionFlag, decisionBlock); err != nil {
+		return err
+	}
+	if cfg.ModerationTimeout, err = envDuration("KBOT_MODERATION_TIMEOUT", 3*time.Second); err != nil {
+		return err
+	}
+	if cfg.ModerationNotify, err = envBool("KBOT_MODERATION_NOTIFY", true); err != nil {
+		return err
+	}
+
+	cfg.ModerationWords = envList("KBOT_MODERATION_WORDS")
+	words := cfg.ModerationWords
+	if cfg.ModerationWordsFile = strings.TrimSpace(os.Getenv("KBOT_MODERATION_WORDS_FILE")); cfg.ModerationWordsFile != "" {
+		fileWords, err := readWordList(cfg.ModerationWordsFile)
+		if err != nil {
+			return fmt.Errorf("invalid KBOT_MODERATION_WORDS_FILE: %w", err)
+		}
+		words = append(words, fileWords...)
+	}
+	if list := newWordListModerator(words, cfg.ModerationAction); len(list.entries) > 0 {
+		cfg.moderators = append(cfg.moderators, list)
+	}
+
+	cfg.ModerationToken = os.Getenv("KBOT_MODERATION_TOKEN")
+	if cfg.ModerationURL = strings.TrimSpace(os.Getenv("KBOT_MODERATION_URL")); cfg.ModerationURL != "" {
+		if !strings.HasPrefix(cfg.ModerationURL, "http://") && !strings.HasPrefix(cfg.ModerationURL, "https://") {
+			return fmt.Errorf("invalid KBOT_MODERATION_URL %q: expected an http or https URL", cfg.ModerationURL)
+		}
+		cfg.moderators = append(cfg.moderators, &webhookModerator{
+			url:    cfg.ModerationURL,
+			token:  cfg.ModerationToken,
+			client: &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport), Timeout: cfg.ModerationTimeout},
+		})
+	}
+	return nil
+}
+
+// envChoice reads a variable that must be one of choices
+func envChoice(name, def string, choices ...string) (string, error) {
+	raw := strings.ToLower(strings.TrimSpace(os.Getenv(name)))
+	if raw == "" {
+		return def, nil
+	}
+	for _, choice := range choices {
+		if raw == choice {
+			return raw, nil
+		}
+	}
+	return "", fmt.Errorf("invalid %s %q: expected %s", name, raw, strings.Join(choices, ", "))
+}
+
+// readWordList reads one entry per line; empty lines and lines starting with '#' are skipped
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, scanner.Err()
+}
+
+// moderate runs the configured moderators on the text and returns the strictest decision.
+// A moderator that fails decides KBOT_MODERATION_ON_ERROR; every decision is counted.
+func moderate(ctx context.Context, req moderationRequest) moderationResult {
+	ctx, span := tracer.Start(ctx, "moderate", trace.WithAttributes(attribute.String("moderation.source", req.Source)))
+	defer span.End()
+
+	cfg := currentConfig()
+	final := moderationResult{Decision: decisionAllow}
+	for _, moderator := range cfg.moderators {
+		result, err := moderator.Moderate(ctx, req)
+		if err != nil {
+			log.Printf("Moderator %s failed: %v", moderator.Name(), err)
+			span.RecordError(err)
+			result = moderationResult{Decision: cfg.ModerationOnError, Reason: "moderator error: " + err.Error()}
+		}
+		result.Moderator = moderator.Name()
+		moderationCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("moderation.moderator", moderator.Name()),
+			attribute.String("moderation.decision", result.Decision),
+			attribute.String("moderation.source", req.Source),
+			attribute.Bool("moderation.error", err != nil),
+		))
+		if decisionRank[result.Decision] > decisionRank[final.Decision] {
+			final = result
+		}
+		if final.Decision == decisionBlock {
+			break // Nothing is stricter
+		}
+	}
+	span.SetAttributes(attribute.String("moderation.decision", final.Decision), attribute.String("moderation.moderator", final.Moderator))
+	if final.Decision != decisionAllow {
+		log.Printf("Moderation: %s text from %s (user %d) by %s: %s", final.Decision, req.Source, req.UserID, final.Moderator, final.Reason)
+	}
+	return final
+}
+
+// moderateTelegram moderates a text sent through Telegram and reports a flagged one to the admins
+func moderateTelegram(ctx context.Context, c tele.Context, text, source string) moderationResult {
+	if len(currentConfig().moderators) == 0 {
+		return moderationResult{Decision: decisionAllow}
+	}
+	result := moderate(ctx, moderationRequest{Text: text, Source: source, UserID: c.Sender().ID, ChatID: c.Chat().ID})
+	if result.Decision == decisionFlag {
+		notifyModerators(c, text, result)
+	}
+	return result
+}
+
+// moderationBlocked moderates a text sent through Telegram and answers a blocked one with a refusal;
+// blocked is true then, and err is the result of sending the refusal
+func moderationBlocked(ctx context.Context, c tele.Context, text, source string) (blocked bool, err error) {
+	if moderateTelegram(ctx, c, text, source).Decision != decisionBlock {
+		return false, nil
+	}
+	locale := userLocale(c)
+	return true, c.Send(tr(locale, "moderation.blocked"), mainMenuFor(locale))
+}
+
+// notifyModerators sends a flagged text to the admins, each in their saved language
+func notifyModerators(c tele.Context, text string, result moderationResult) {
+	cfg := currentConfig()
+	if !cfg.ModerationNotify {
+		return
+	}
+	for _, adminID := range cfg.AdminIDs {
+		locale := defaultLocale
+		if raw, ok := userSettingsStore.Load(adminID); ok && raw.(UserSettings).Lang != "" {
+			locale = raw.(UserSettings).Lang
+		}
+		message := tr(locale, "moderation.flagged", c.Sender().ID, c.Sender().Username, c.Chat().ID, result.Moderator, result.Reason, text)
+		// The private chat of a user has the same ID as the user
+		if _, err := c.Bot().Send(tele.ChatID(adminID), message); err != nil {
+			log.Printf("Failed to report flagged text to admin %d: %v", adminID, err)
+		}
+	}
+}
+
+// --- Word list ---
+
+// wordListModerator matches the text against a list of words and phrases after normalizing both.
+// An entry ending in '*' also matches words that start with it ("spam*" matches "spammer").
+type wordListModerator struct {
+	entries []wordEntry
+	action  string // Decision on a match: flag or block
+}
+
+// wordEntry is a normalized list entry: a word, or a phrase of several words
+type wordEntry struct {
+	Raw    string
+	Words  [][]letterRun
+	Joined []letterRun // The words of a phrase written together, e.g. "badword"
+	Prefix bool        // The last word may continue
+}
+
+// letterRun is a letter and how many times it repeats, so that "baaad" can match "bad"
+type letterRun struct {
+	Letter rune
+	Count  int
+}
+
+// newWordListModerator normalizes the entries, dropping those that normalize to nothing
+func newWordListModerator(words []string, action string) *wordListModerator {
+	list := &wordListModerator{action: action}
+	for _, raw := range words {
+		prefix := strings.HasSuffix(raw, "*")
+		tokens := moderationTokens(strings.TrimSuffix(raw, "*"))
+		if len(tokens) == 0 {
+			continue
+		}
+		entry := wordEntry{Raw: raw, Prefix: prefix, Joined: letterRuns(strings.Join(tokens, ""))}
+		for _, token := range tokens {
+			entry.Words = append(entry.Words, letterRuns(token))
+		}
+		list.entries = append(list.entries, entry)
+	}
+	return list
+}
+
+func (w *wordListModerator) Name() string {
+	return "wordlist"
+}
+
+// Moderate reports the first entry found in the text
+func (w *wordListModerator) Moderate(_ context.Context, req moderationRequest) (moderationResult, error) {
+	tokens := moderationTokens(req.Text)
+	words := make([][]letterRun, len(tokens))
+	for i, token := range tokens {
+		words[i] = letterRuns(token)
+	}
+	for _, entry := range w.entries {
+		for start := range words {
+			if entry.matches(words[start:]) {
+				return moderationResult{Decision: w.action, Reason: fmt.Sprintf("matched %q", entry.Raw)}, nil
+			}
+		}
+	}
+	return moderationResult{Decision: decisionAllow}, nil
+}
+
+// matches reports whether the text starting at words spells the entry, word by word or in one word
+func (e wordEntry) matches(words [][]letterRun) bool {
+	if len(e.Words) > 1 && runsMatch(words[0], e.Joined, e.Prefix) {
+		return true
+	}
+	if len(words) < len(e.Words) {
+		return false
+	}
+	for i, want := range e.Words {
+		if !runsMatch(words[i], want, e.Prefix && i == len(e.Words)-1) {
+			return false
+		}
+	}
+	return true
+}
+
+// runsMatch reports whether word has the letters of want, each repeated at least as often.
+// With prefix, word may have more letters after them.
+func runsMatch(word, want []letterRun, prefix bool) bool {
+	if len(word) < len(want) || (!prefix && len(word) != len(want)) {
+		return false
+	}
+	for i, run := range want {
+		if word[i].Letter != run.Letter || word[i].Count < run.Count {
+			return false
+		}
+	}
+	return true
+}
+
+// letterRuns groups repeated letters
+func letterRuns(word string) []letterRun {
+	var runs []letterRun
+	for _, r := range word {
+		if n := len(runs); n > 0 && runs[n-1].Letter == r {
+			runs[n-1].Count++
+		} else {
+			runs = append(runs, letterRun{Letter: r, Count: 1})
+		}
+	}
+	return runs
+}
+
+// moderationFolds maps digits and symbols of leet-speak, and Cyrillic and Greek letters that look
+// like Latin ones, to the Latin letter they stand for. Both the text and the list are folded, so
+// entries in Cyrillic still match Cyrillic text.
+var moderationFolds = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '$': 's', '!': 'i', '|': 'l', '+': 't', '€': 'e',
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'є': 'e', 'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'м': 'm',
+	'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'ѕ': 's', 'т': 't', 'у': 'y', 'х': 'x',
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// moderationTokens normalizes text into words: compatibility forms (full-width, styled letters)
+// are decomposed, accents dropped, letters lower-cased and folded, and separators inside a word
+// removed, so that "B.4.D" and "ｂａｄ" both read "bad". Spaced-out letters ("b a d") become one word.
+func moderationTokens(text string) []string {
+	var tokens, singles []string
+	flushSingles := func() {
+		if len(singles) > 0 {
+			tokens = append(tokens, strings.Join(singles, ""))
+		}
+		singles = nil
+	}
+	for _, field := range strings.Fields(norm.NFKD.String(text)) {
+		// Punctuation around a word is not leet-speak ("word!"); '@' and '$' may start one ("$pam")
+		field = strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r) && r != '@' && r != '$'
+		})
+		var sb strings.Builder
+		for _, r := range field {
+			if unicode.Is(unicode.Mn, r) {
+				continue // Accents left by the decomposition
+			}
+			r = unicode.ToLower(r)
+			if folded, ok := moderationFolds[r]; ok {
+				r = folded
+			}
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				sb.WriteRune(r)
+			}
+		}
+		token := sb.String()
+		if token == "" {
+			continue
+		}
+		if len([]rune(token)) == 1 {
+			singles = append(singles, token)
+			continue
+		}
+		flushSingles()
+		tokens = append(tokens, token)
+	}
+	flushSingles()
+	return tokens
+}
+
+// --- Webhook ---
+
+// webhookModerator asks an external service. It POSTs the moderationRequest as JSON and expects
+// {"decision": "allow"|"flag"|"block", "reason": "..."} with status 200.
+type webhookModerator struct {
+	url    string
+	token  string // Sent as a bearer token when set
+	client *http.Client
+}
+
+func (m *webhookModerator) Name() string {
+	return "webhook"
+}
+
+func (m *webhookModerator) Moderate(ctx context.Context, req moderationRequest) (moderationResult, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return moderationResult{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", m.url, bytes.NewReader(body))
+	if err != nil {
+		return moderationResult{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("User-Agent", fmt.Sprintf("kbot/%s", appVersion))
+	if m.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+m.token)
+	}
+	resp, err := m.client.Do(httpReq)
+	if err != nil {
+		return moderationResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return moderationResult{}, fmt.Errorf("moderation service returned status %d", resp.StatusCode)
+	}
+	var result moderationResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return moderationResult{}, fmt.Errorf("decoding moderation response: %w", err)
+	}
+	if _, ok := decisionRank[result.Decision]; !ok {
+		return moderationResult{}, fmt.Errorf("moderation service returned unknown decision %q", result.Decision)
+	}
+	return result, nil
+}
@@ -0,0 +1,229 @@
+// kbot-app/cmd/moderation_test.go
+// This file contains the tests of the word list, the moderation webhook and how their decisions combine.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestModerationTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"plain", "Hello world", []string{"hello", "world"}},
+		{"leet digits", "B4D w0rd 5p4m", []string{"bad", "word", "spam"}},
+		{"leet symbols", "$pam @ss h!t", []string{"spam", "ass", "hit"}},
+		{"separators inside a word", "b.a.d w-o_r*d", []string{"bad", "word"}},
+		{"punctuation around a word", "(word)! \"bad\"?", []string{"word", "bad"}},
+		{"spaced-out letters", "b a d word", []string{"bad", "word"}},
+		{"accents", "bäd wörd", []string{"bad", "word"}},
+		{"full-width letters", "ｂａｄ", []string{"bad"}},
+		{"styled letters", "𝐛𝐚𝐝 𝓌𝑜𝓇𝒹", []string{"bad", "word"}},
+		{"cyrillic look-alikes", "ВАР сор", []string{"bap", "cop"}},
+		{"greek look-alikes", "ΑΒΕΤ ΚΟΡΑ", []string{"abet", "kopa"}},
+		{"cyrillic words stay cyrillic", "Дурень", []string{"дypehь"}},
+		{"only punctuation", "... !!! ---", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := moderationTokens(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("moderationTokens(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWordListModerator(t *testing.T) {
+	list := newWordListModerator([]string{"bad word", "spam*", "scam", "дурень", "!!!", ""}, decisionBlock)
+	if len(list.entries) != 4 {
+		t.Fatalf("%d entries, want 4: entries that normalize to nothing are dropped", len(list.entries))
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"clean", "Have a nice day", decisionAllow},
+		{"phrase", "what a bad word", decisionBlock},
+		{"phrase in leet", "B4D   w.o.r.d", decisionBlock},
+		{"phrase written together", "badword", decisionBlock},
+		{"phrase stretched", "baaad wooord", decisionBlock},
+		{"phrase with look-alikes", "bаd wоrd", decisionBlock}, // Cyrillic а and о
+		{"half a phrase", "bad day", decisionAllow},
+		{"phrase across a sentence", "word bad", decisionAllow},
+		{"wildcard", "top spammer", decisionBlock},
+		{"wildcard exact", "$PAM", decisionBlock},
+		{"wildcard needs the start", "antispam", decisionAllow},
+		{"word", "a scam!", decisionBlock},
+		{"word is not a prefix", "scampi", decisionAllow},
+		{"spaced-out word", "s c a m", decisionBlock},
+		{"letters inside another word", "escape", decisionAllow},
+		{"cyrillic entry", "ти ДУРЕНЬ", decisionBlock},
+		{"cyrillic entry in latin look-alikes", "ти дypehь", decisionBlock},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := list.Moderate(context.Background(), moderationRequest{Text: tt.text, Source: sourceImage})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Decision != tt.want {
+				t.Errorf("Moderate(%q) = %s (%s), want %s", tt.text, result.Decision, result.Reason, tt.want)
+			}
+		})
+	}
+
+	flagging := newWordListModerator([]string{"scam"}, decisionFlag)
+	if result, _ := flagging.Moderate(context.Background(), moderationRequest{Text: "scam"}); result.Decision != decisionFlag || result.Reason != `matched "scam"` {
+		t.Errorf("flagging list = %+v, want a flag naming the entry", result)
+	}
+}
+
+// moderationService is a stand-in moderation webhook
+func moderationService(t *testing.T, status int, answer string, delay time.Duration) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("webhook got %s with Authorization %q and Content-Type %q", r.Method, r.Header.Get("Authorization"), r.Header.Get("Content-Type"))
+		}
+		var req moderationRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req != (moderationRequest{Text: "some text", Source: sourceBatch, UserID: 7, ChatID: 8}) {
+			t.Errorf("webhook got request %+v (%v)", req, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(answer))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestWebhookModerator(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		answer  string
+		delay   time.Duration
+		want    moderationResult
+		wantErr bool
+	}{
+		{"allow", http.StatusOK, `{"decision": "allow"}`, 0, moderationResult{Decision: decisionAllow}, false},
+		{"flag", http.StatusOK, `{"decision": "flag", "reason": "borderline"}`, 0, moderationResult{Decision: decisionFlag, Reason: "borderline"}, false},
+		{"block", http.StatusOK, `{"decision": "block", "reason": "hate"}`, 0, moderationResult{Decision: decisionBlock, Reason: "hate"}, false},
+		{"timeout", http.StatusOK, `{"decision": "allow"}`, time.Second, moderationResult{}, true},
+		{"server error", http.StatusInternalServerError, `{"decision": "allow"}`, 0, moderationResult{}, true},
+		{"invalid JSON", http.StatusOK, `allow`, 0, moderationResult{}, true},
+		{"unknown decision", http.StatusOK, `{"decision": "maybe"}`, 0, moderationResult{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := moderationService(t, tt.status, tt.answer, tt.delay)
+			webhook := &webhookModerator{url: server.URL, token: "secret", client: &http.Client{Timeout: 100 * time.Millisecond}}
+			result, err := webhook.Moderate(context.Background(), moderationRequest{Text: "some text", Source: sourceBatch, UserID: 7, ChatID: 8})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Moderate error = %v, want error %v", err, tt.wantErr)
+			}
+			if result != tt.want {
+				t.Errorf("Moderate = %+v, want %+v", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestModerate(t *testing.T) {
+	tests := []struct {
+		name          string
+		onError       string
+		words         []string
+		status        int
+		answer        string
+		delay         time.Duration
+		wantDecision  string
+		wantModerator string
+	}{
+		{"both allow", decisionAllow, []string{"scam"}, http.StatusOK, `{"decision": "allow"}`, 0, decisionAllow, ""},
+		{"webhook flags", decisionAllow, []string{"scam"}, http.StatusOK, `{"decision": "flag"}`, 0, decisionFlag, "webhook"},
+		{"webhook blocks", decisionAllow, nil, http.StatusOK, `{"decision": "block"}`, 0, decisionBlock, "webhook"},
+		{"word list blocks first", decisionAllow, []string{"some"}, http.StatusOK, `{"decision": "flag"}`, 0, decisionBlock, "wordlist"},
+
+		{"timeout with on-error allow", decisionAllow, nil, http.StatusOK, `{"decision": "block"}`, time.Second, decisionAllow, ""},
+		{"timeout with on-error flag", decisionFlag, nil, http.StatusOK, `{"decision": "allow"}`, time.Second, decisionFlag, "webhook"},
+		{"timeout with on-error block", decisionBlock, nil, http.StatusOK, `{"decision": "allow"}`, time.Second, decisionBlock, "webhook"},
+		{"failure with on-error allow", decisionAllow, nil, http.StatusBadGateway, ``, 0, decisionAllow, ""},
+		{"failure with on-error flag", decisionFlag, nil, http.StatusBadGateway, ``, 0, decisionFlag, "webhook"},
+		{"failure with on-error block", decisionBlock, nil, http.StatusBadGateway, ``, 0, decisionBlock, "webhook"},
+		{"failure does not lift a word list block", decisionAllow, []string{"text"}, http.StatusBadGateway, ``, 0, decisionBlock, "wordlist"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := moderationService(t, tt.status, tt.answer, tt.delay)
+			cfg := &Config{ModerationOnError: tt.onError}
+			if len(tt.words) > 0 {
+				cfg.moderators = append(cfg.moderators, newWordListModerator(tt.words, decisionBlock))
+			}
+			cfg.moderators = append(cfg.moderators, &webhookModerator{url: server.URL, token: "secret", client: &http.Client{Timeout: 100 * time.Millisecond}})
+			useConfig(t, cfg)
+
+			result := moderate(context.Background(), moderationRequest{Text: "some text", Source: sourceBatch, UserID: 7, ChatID: 8})
+			if result.Decision != tt.wantDecision || result.Moderator != tt.wantModerator {
+				t.Errorf("moderate = %s by %q (%s), want %s by %q", result.Decision, result.Moderator, result.Reason, tt.wantDecision, tt.wantModerator)
+			}
+		})
+	}
+}
+
+func TestLoadModeration(t *testing.T) {
+	tests := []struct {
+		name           string
+		env            map[string]string
+		wantErr        bool
+		wantModerators []string
+		wantOnError    string
+	}{
+		{"off by default", nil, false, nil, decisionAllow},
+		{"word list", map[string]string{"KBOT_MODERATION_WORDS": "spam*, bad word"}, false, []string{"wordlist"}, decisionAllow},
+		{"webhook", map[string]string{"KBOT_MODERATION_URL": "http://localhost:9000/check", "KBOT_MODERATION_ON_ERROR": "BLOCK"}, false, []string{"webhook"}, decisionBlock},
+		{"both in order", map[string]string{"KBOT_MODERATION_WORDS": "scam", "KBOT_MODERATION_URL": "https://mod.example/check", "KBOT_MODERATION_ON_ERROR": "flag"}, false, []string{"wordlist", "webhook"}, decisionFlag},
+		{"invalid on-error", map[string]string{"KBOT_MODERATION_ON_ERROR": "ignore"}, true, nil, ""},
+		{"invalid action", map[string]string{"KBOT_MODERATION_ACTION": "allow"}, true, nil, ""},
+		{"invalid URL", map[string]string{"KBOT_MODERATION_URL": "localhost:9000"}, true, nil, ""},
+		{"missing words file", map[string]string{"KBOT_MODERATION_WORDS_FILE": "testdata/missing.txt"}, true, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"KBOT_MODERATION_WORDS", "KBOT_MODERATION_WORDS_FILE", "KBOT_MODERATION_ACTION", "KBOT_MODERATION_URL", "KBOT_MODERATION_ON_ERROR"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg := &Config{}
+			err := loadModeration(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadModeration error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			var names []string
+			for _, moderator := range cfg.moderators {
+				names = append(names, moderator.Name())
+			}
+			if !reflect.DeepEqual(names, tt.wantModerators) || cfg.ModerationOnError != tt.wantOnError {
+				t.Errorf("moderators = %q with on-error %s, want %q with %s", names, cfg.ModerationOnError, tt.wantModerators, tt.wantOnError)
+			}
+		})
+	}
+}
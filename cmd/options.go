@@ -0,0 +1,212 @@
+// kbot-app/cmd/options.go
+// This file contains inline options: a prefix such as "--fg=red --bg=#000 --size=32 Hello" that
+// overrides the saved settings for one image, in plain messages and after /img.
+//
+// Grammar: options come before the text and each one is a single word "--<name>=<value>".
+// <name> is a setting of settingFields (tx_color, align, size, ...) or an alias of inlineOptionAliases;
+// values are validated like the setting's command. A lone "--" ends the options, so the text
+// itself may start with "--". Option names are case-insensitive; a repeated option overrides
+// the earlier one.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// inlineOptionAliases are short names for settings in inline options
+var inlineOptionAliases = map[string]string{
+	"fg":         "tx_color",
+	"color":      "tx_color",
+	"text_color": "tx_color",
+	"bg":         "bg_color",
+	"background": "bg_color",
+	"ratio":      "size",
+	"font":       "font_size",
+}
+
+// inlineOption is a setting overridden by an inline option, with its normalized value
+type inlineOption struct {
+	Field settingField
+	Value string
+}
+
+// inlineOptions are the options of one message, in the order they were given
+type inlineOptions []inlineOption
+
+// apply overrides the settings with the options
+func (o inlineOptions) apply(s *UserSettings) {
+	for _, option := range o {
+		option.Field.Apply(s, option.Value)
+	}
+}
+
+// names lists the overridden settings, for logs and span attributes
+func (o inlineOptions) names() []string {
+	names := make([]string, len(o))
+	for i, option := range o {
+		names[i] = option.Field.Name
+	}
+	return names
+}
+
+// optionError is an inline option that cannot be applied
+type optionError struct {
+	Option string // The option as typed, e.g. "--fg=pinkish"
+	Key    string // Catalog key of the message shown to the user
+	Field  string // Setting of the option, for "options.invalid"
+	Value  string // Rejected value, for "options.invalid"
+}
+
+func (e *optionError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Option)
+}
+
+// message formats the error for the user
+func (e *optionError) message(locale string) string {
+	switch e.Key {
+	case "options.invalid":
+		return tr(locale, e.Key, e.Value, e.Option, tr(locale, "field."+e.Field))
+	case "options.unknown":
+		return tr(locale, e.Key, e.Option, strings.Join(inlineOptionNames(), " "))
+	case "options.not_in_palette":
+		return tr(locale, e.Key, e.Option, paletteList())
+	}
+	return tr(locale, e.Key, e.Option)
+}
+
+// parseInlineOptions takes the leading options off a message and returns them with the remaining text.
+// Text without a leading "--" has no options. Line breaks in the text are kept.
+func parseInlineOptions(body string) (inlineOptions, string, error) {
+	var options inlineOptions
+	body = strings.TrimSpace(body)
+	for {
+		word, rest := firstWord(body)
+		// Clients with smart punctuation turn "--" into an em dash. A dash without "=" starts the text (e.g. dialogue).
+		if strings.HasPrefix(word, "—") && strings.Contains(word, "=") {
+			word = "--" + strings.TrimPrefix(word, "—")
+		}
+		if !strings.HasPrefix(word, "--") {
+			return options, body, nil
+		}
+		body = rest
+		if word == "--" {
+			return options, body, nil
+		}
+
+		key, value, _ := strings.Cut(strings.TrimPrefix(word, "--"), "=")
+		option, err := resolveInlineOption(key, value, word)
+		if err != nil {
+			return nil, "", err
+		}
+		options = append(options, option)
+	}
+}
+
+// resolveInlineOption validates the value of the option named key; word is the option as shown in errors.
+// Batch files use it for their CSV columns too.
+func resolveInlineOption(key, value, word string) (inlineOption, *optionError) {
+	key = strings.ToLower(strings.TrimSpace(key))
+	name := key
+	if alias, ok := inlineOptionAliases[key]; ok {
+		name = alias
+	}
+	// Imgbun's "size" is the font size, so "--size=32" sets the font size rather than the canvas
+	if key == "size" {
+		if _, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "px")); err == nil {
+			name = "font_size"
+		}
+	}
+	field, ok := lookupField(name)
+	if !ok {
+		return inlineOption{}, &optionError{Option: word, Key: "options.unknown"}
+	}
+	if strings.TrimSpace(value) == "" {
+		return inlineOption{}, &optionError{Option: word, Key: "options.missing_value"}
+	}
+	normalized, ok := field.Parse(value)
+	if !ok {
+		return inlineOption{}, &optionError{Option: word, Key: "options.invalid", Field: field.Name, Value: value}
+	}
+	if !field.inPalette(normalized) {
+		return inlineOption{}, &optionError{Option: word, Key: "options.not_in_palette"}
+	}
+	return inlineOption{Field: field, Value: normalized}, nil
+}
+
+// firstWord splits off the first whitespace-separated word of s; the rest keeps its line breaks
+func firstWord(s string) (string, string) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	return fields[0], strings.TrimSpace(s[strings.Index(s, fields[0])+len(fields[0]):])
+}
+
+// inlineOptionNames lists the accepted option names, settings first and then aliases
+func inlineOptionNames() []string {
+	names := make([]string, 0, len(settingFields)+len(inlineOptionAliases))
+	for _, field := range settingFields {
+		names = append(names, "--"+field.Name)
+	}
+	aliases := make([]string, 0, len(inlineOptionAliases))
+	for alias := range inlineOptionAliases {
+		aliases = append(aliases, "--"+alias)
+	}
+	sort.Strings(aliases)
+	return append(names, aliases...)
+}
+
+// handleImg handles /img [--<option>=<value> ...] <text>, which works in groups too
+func handleImg(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleImg",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	if strings.TrimSpace(commandText(c.Message().Text)) == "" {
+		locale := userLocale(c)
+		return c.Send(tr(locale, "cmd.usage", commandUsage("img")), mainMenuFor(locale))
+	}
+	log.Printf("User %d (%s) requested an image via /img", c.Sender().ID, c.Sender().Username)
+	return generateWithOptions(ctx, c, span, commandText(c.Message().Text))
+}
+
+// generateWithOptions parses the inline options of a message and generates the image of the remaining text;
+// invalid options are answered with what is wrong instead
+func generateWithOptions(ctx context.Context, c tele.Context, span trace.Span, body string) error {
+	locale := userLocale(c)
+	options, text, err := parseInlineOptions(body)
+	if err != nil {
+		oerr := err.(*optionError)
+		invalidOptionCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", oerr.Key)))
+		span.SetStatus(codes.Error, err.Error())
+		log.Printf("User %d (%s) sent invalid options: %v", c.Sender().ID, c.Sender().Username, err)
+		return c.Send(oerr.message(locale), mainMenuFor(locale))
+	}
+	if len(options) == 0 {
+		return generateAndSendImage(ctx, c, text, nil)
+	}
+	span.SetAttributes(attribute.StringSlice("image.options", options.names()))
+	if text == "" {
+		return c.Send(tr(locale, "options.no_text"), mainMenuFor(locale))
+	}
+	return generateAndSendImage(ctx, c, text, options.apply)
+}
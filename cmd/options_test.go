@@ -0,0 +1,134 @@
+// kbot-app/cmd/options_test.go
+// This file contains the tests of the inline option grammar.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseInlineOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantOptions []string // "setting=value" in the order given
+		wantText    string
+		wantErr     string // Catalog key of the error, empty for none
+	}{
+		{"no options", "Hello world", nil, "Hello world", ""},
+		{"options and text", "--fg=red --bg=#000 Hello", []string{"tx_color=FF0000", "bg_color=000"}, "Hello", ""},
+		{"setting names", "--tx_color=fff --align=left Hi", []string{"tx_color=fff", "align=left"}, "Hi", ""},
+		{"upper-case names", "--FG=red Hi", []string{"tx_color=FF0000"}, "Hi", ""},
+		{"options only", "--fg=red", []string{"tx_color=FF0000"}, "", ""},
+		{"line breaks in the text are kept", "--fg=red first\nsecond", []string{"tx_color=FF0000"}, "first\nsecond", ""},
+		{"surrounding spaces", "  --fg=red   Hello  ", []string{"tx_color=FF0000"}, "Hello", ""},
+
+		{"double dash ends the options", "--fg=red -- --bg=blue is text", []string{"tx_color=FF0000"}, "--bg=blue is text", ""},
+		{"double dash alone", "-- --fg=red", nil, "--fg=red", ""},
+		{"em dash option", "—fg=red Hello", []string{"tx_color=FF0000"}, "Hello", ""},
+		{"em dash size", "—size=og Hello", []string{"size=og"}, "Hello", ""},
+		{"em dash without value is text", "—Hello, he said", nil, "—Hello, he said", ""},
+		{"single dash is text", "-fg=red Hello", nil, "-fg=red Hello", ""},
+
+		{"repeated options are kept in order", "--fg=red --fg=blue Hi", []string{"tx_color=FF0000", "tx_color=0000FF"}, "Hi", ""},
+		{"alias and name repeated", "--color=red --tx_color=green Hi", []string{"tx_color=FF0000", "tx_color=008000"}, "Hi", ""},
+
+		{"size number is the font size", "--size=32 Hi", []string{"font_size=32"}, "Hi", ""},
+		{"size in pixels is the font size", "--size=32px Hi", []string{"font_size=32"}, "Hi", ""},
+		{"size preset is the canvas", "--size=og Hi", []string{"size=og"}, "Hi", ""},
+		{"size dimensions are the canvas", "--size=800x600 Hi", []string{"size=800x600"}, "Hi", ""},
+		{"size ratio is the canvas", "--size=16:9 Hi", []string{"size=16:9"}, "Hi", ""},
+		{"ratio alias", "--ratio=9:16 Hi", []string{"size=9:16"}, "Hi", ""},
+		{"font alias", "--font=48 Hi", []string{"font_size=48"}, "Hi", ""},
+
+		{"text containing double dashes", "Hello --fg=red", nil, "Hello --fg=red", ""},
+		{"text with a dash pair", "wait -- what", nil, "wait -- what", ""},
+		{"option after the text is text", "--fg=red Hello --bg=blue", []string{"tx_color=FF0000"}, "Hello --bg=blue", ""},
+
+		{"unknown option", "--sparkle=yes Hi", nil, "", "options.unknown"},
+		{"unknown option without value", "--verbose Hi", nil, "", "options.unknown"},
+		{"missing value", "--fg Hi", nil, "", "options.missing_value"},
+		{"empty value", "--fg= Hi", nil, "", "options.missing_value"},
+		{"invalid value", "--fg=pinkish Hi", nil, "", "options.invalid"},
+		{"invalid size", "--size=huge Hi", nil, "", "options.invalid"},
+		{"font size out of range", "--size=100000 Hi", nil, "", "options.invalid"},
+		{"error after a valid option", "--fg=red --bg=nope Hi", nil, "", "options.invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, text, err := parseInlineOptions(tt.body)
+			if tt.wantErr != "" {
+				optErr, ok := err.(*optionError)
+				if !ok || optErr.Key != tt.wantErr {
+					t.Fatalf("parseInlineOptions(%q) error = %v, want %s", tt.body, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseInlineOptions(%q) error = %v", tt.body, err)
+			}
+			var got []string
+			for _, option := range options {
+				got = append(got, option.Field.Name+"="+option.Value)
+			}
+			if !reflect.DeepEqual(got, tt.wantOptions) {
+				t.Errorf("options = %q, want %q", got, tt.wantOptions)
+			}
+			if text != tt.wantText {
+				t.Errorf("text = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestResolveInlineOption(t *testing.T) {
+	tests := []struct {
+		key, value string
+		wantField  string
+		wantValue  string
+		wantErr    string
+	}{
+		{"fg", "red", "tx_color", "FF0000", ""},
+		{" BG ", "#123", "bg_color", "123", ""},
+		{"size", "32", "font_size", "32", ""},
+		{"size", "32PX", "font_size", "32", ""},
+		{"size", "og", "size", "og", ""},
+		{"size", "1200×630", "size", "1200x630", ""},
+		{"ratio", "32", "size", "", "options.invalid"},
+		{"font", "og", "font_size", "", "options.invalid"},
+		{"shadow_color", "none", "shadow_color", "none", ""},
+		{"nope", "1", "", "", "options.unknown"},
+		{"fg", " ", "", "", "options.missing_value"},
+	}
+	for _, tt := range tests {
+		option, err := resolveInlineOption(tt.key, tt.value, "--"+tt.key+"="+tt.value)
+		if tt.wantErr != "" {
+			if err == nil || err.Key != tt.wantErr {
+				t.Errorf("resolveInlineOption(%q, %q) error = %v, want %s", tt.key, tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("resolveInlineOption(%q, %q) error = %v", tt.key, tt.value, err)
+			continue
+		}
+		if option.Field.Name != tt.wantField || option.Value != tt.wantValue {
+			t.Errorf("resolveInlineOption(%q, %q) = %s=%s, want %s=%s", tt.key, tt.value, option.Field.Name, option.Value, tt.wantField, tt.wantValue)
+		}
+	}
+}
+
+func TestInlineOptionsApply(t *testing.T) {
+	options, _, err := parseInlineOptions("--fg=red --size=og --fg=blue --ratio=16:9 Hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := UserSettings{TextColor: "000000", BgColor: "FFFFFF"}
+	options.apply(&settings)
+	// The last of repeated options wins
+	want := UserSettings{TextColor: "0000FF", BgColor: "FFFFFF", Size: "16:9"}
+	if settings != want {
+		t.Errorf("applied settings = %+v, want %+v", settings, want)
+	}
+}
@@ -0,0 +1,163 @@
+// kbot-app/cmd/ratelimit.go
+// This file contains the limits shared by every way of rendering an image (Telegram and the HTTP API):
+// a per-requester rate limit and a cache of recently rendered images.
+
+package cmd
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// rateLimiter is a token bucket per requester: KBOT_RENDER_RATE images per minute, in bursts of the same size
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// renderLimiter limits renders; keys are "telegram:<user ID>" or "http:<client address>"
+var renderLimiter = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+// telegramRequester is the rate limit key of a Telegram user
+func telegramRequester(userID int64) string {
+	return fmt.Sprintf("telegram:%d", userID)
+}
+
+// retrySeconds rounds a wait up to whole seconds for messages and Retry-After
+func retrySeconds(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// Allow takes a token from the requester's bucket; when it is empty it returns how long to wait for the next one
+func (l *rateLimiter) Allow(ctx context.Context, key, source string) (bool, time.Duration) {
+	perMinute := currentConfig().RenderRate
+	if perMinute <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	rate := float64(perMinute) / 60 // Tokens per second
+	l.sweep(now, rate, float64(perMinute))
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(perMinute), updated: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(perMinute), bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+	if bucket.tokens < 1 {
+		rateLimitedCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("request.source", source)))
+		return false, time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep forgets, at most once a minute, the buckets that have refilled completely
+func (l *rateLimiter) sweep(now time.Time, rate, capacity float64) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*rate >= capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// imageCache keeps recently rendered images within KBOT_RENDER_CACHE_MB, evicting the least recently used
+type imageCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // Front is the most recently used
+	bytes   int
+}
+
+type cacheEntry struct {
+	key   string
+	image RenderedImage
+}
+
+// renderCache is shared by the bot and the HTTP API
+var renderCache = &imageCache{entries: make(map[string]*list.Element), order: list.New()}
+
+// cacheKey identifies a render request; ok is false for requests that cannot be cached
+// (a background image that is not a Telegram photo, as with kbot render --bg-image)
+func cacheKey(generator string, req RenderRequest) (string, bool) {
+	if req.Photo != nil && req.Settings.BgPhoto == "" {
+		return "", false
+	}
+	if req.Settings.BgMode == bgPhoto && req.Settings.BgPhoto != "" && req.Photo == nil {
+		return "", false // The photo could not be downloaded: do not keep the fallback in place of the photo
+	}
+	settings := req.Settings
+	settings.Lang, settings.StickerSet = "", "" // Not used for drawing
+	if settings.BgMode != bgPhoto {
+		settings.BgPhoto = ""
+	}
+	data, err := json.Marshal(struct {
+		Generator string
+		Text      string
+		Settings  UserSettings
+		Watermark string
+	}{generator, req.Text, settings, watermarkKey()})
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// Get returns a cached image and marks it as recently used
+func (c *imageCache) Get(key string) (RenderedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return RenderedImage{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).image, true
+}
+
+// Put stores an image, evicting the least recently used images beyond the configured size
+func (c *imageCache) Put(key string, img RenderedImage) {
+	limit := currentConfig().RenderCacheMB << 20
+	size := len(img.PNG) + len(img.URL)
+	if size > limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, image: img})
+	c.bytes += size
+	for c.bytes > limit {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.bytes -= len(entry.image.PNG) + len(entry.image.URL)
+	}
+}
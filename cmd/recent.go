@@ -0,0 +1,232 @@
+// kbot-app/cmd/recent.go
+// This file contains the record of generated images: /recent lists a user's last images with buttons
+// to send one again or to draw its text again with the current colors.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	recentShown            = 10 // Images listed by /recent
+	recentTextRunes        = 40 // Length of the text shown for an image
+	recentResendUnique     = "recent_resend"
+	recentRegenerateUnique = "recent_regenerate"
+)
+
+// generationRecord is an image sent to a user
+type generationRecord struct {
+	ID        int64        `json:"id"` // Creation time in nanoseconds, unique per user
+	Text      string       `json:"text"`
+	Settings  UserSettings `json:"settings"` // Settings the image was drawn with, one-off options included
+	FileID    string       `json:"file_id"`  // Telegram file_id of the sent photo
+	CreatedAt time.Time    `json:"created_at"`
+}
+
+// recentStore keeps the generated images of every user, oldest first (thread-safe)
+type recentStore struct {
+	mu      sync.Mutex
+	entries map[int64][]generationRecord
+}
+
+var recentImages = &recentStore{entries: make(map[int64][]generationRecord)}
+
+// retain drops the records beyond KBOT_RECENT_IMAGES and older than KBOT_RECENT_DAYS. Callers hold mu.
+func (r *recentStore) retain(userID int64, now time.Time) []generationRecord {
+	cfg := currentConfig()
+	entries := r.entries[userID]
+	if cfg.RecentDays > 0 {
+		cutoff := now.AddDate(0, 0, -cfg.RecentDays)
+		for len(entries) > 0 && entries[0].CreatedAt.Before(cutoff) {
+			entries = entries[1:]
+		}
+	}
+	if len(entries) > cfg.RecentImages {
+		entries = entries[len(entries)-cfg.RecentImages:]
+	}
+	if len(entries) == 0 {
+		delete(r.entries, userID)
+		return nil
+	}
+	r.entries[userID] = entries
+	return entries
+}
+
+// add records an image
+func (r *recentStore) add(userID int64, record generationRecord) {
+	if currentConfig().RecentImages == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	record.ID = record.CreatedAt.UnixNano()
+	if entries := r.entries[userID]; len(entries) > 0 && record.ID <= entries[len(entries)-1].ID {
+		record.ID = entries[len(entries)-1].ID + 1 // Keep IDs unique on coarse clocks
+	}
+	r.entries[userID] = append(r.entries[userID], record)
+	r.retain(userID, record.CreatedAt)
+}
+
+// list returns a copy of the user's records, oldest first
+func (r *recentStore) list(userID int64) []generationRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]generationRecord(nil), r.retain(userID, time.Now())...)
+}
+
+// find returns the user's record with the given ID
+func (r *recentStore) find(userID, id int64) (generationRecord, bool) {
+	for _, record := range r.list(userID) {
+		if record.ID == id {
+			return record, true
+		}
+	}
+	return generationRecord{}, false
+}
+
+// forget drops the user's records
+func (r *recentStore) forget(userID int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.entries, userID)
+}
+
+// withCurrentColors returns the recorded settings with the colors (and language) of current
+func withCurrentColors(recorded, current UserSettings) UserSettings {
+	recorded.TextColor, recorded.BgColor, recorded.BgColor2 = current.TextColor, current.BgColor, current.BgColor2
+	recorded.OutlineColor, recorded.ShadowColor, recorded.BoxColor = current.OutlineColor, current.ShadowColor, current.BoxColor
+	recorded.Lang, recorded.StickerSet = current.Lang, current.StickerSet
+	return recorded
+}
+
+// shortText returns the text on one line, cut to recentTextRunes
+func shortText(text string) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) > recentTextRunes {
+		return string(runes[:recentTextRunes-1]) + "…"
+	}
+	return string(runes)
+}
+
+// handleRecent handles /recent: lists the last images with buttons to re-send or regenerate them
+func handleRecent(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleRecent",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	locale := userLocale(c)
+	if currentConfig().RecentImages == 0 {
+		return c.Send(tr(locale, "recent.disabled"), mainMenuFor(locale))
+	}
+	records := recentImages.list(c.Sender().ID)
+	span.SetAttributes(attribute.Int("recent.images", len(records)))
+	recentCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("recent.action", "list")))
+	if len(records) == 0 {
+		return c.Send(tr(locale, "recent.empty"), mainMenuFor(locale))
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(locale, "recent.header"))
+	markup := &tele.ReplyMarkup{}
+	var rows []tele.Row
+	for n := 1; n <= recentShown && n <= len(records); n++ {
+		record := records[len(records)-n]
+		id := strconv.FormatInt(record.ID, 36)
+		fmt.Fprintf(&sb, "\n%d. %s - %s", n, record.CreatedAt.Format("2006-01-02 15:04 UTC"), shortText(record.Text))
+		rows = append(rows, markup.Row(
+			markup.Data(tr(locale, "btn.recent_resend", n), recentResendUnique, id),
+			markup.Data(tr(locale, "btn.recent_regenerate", n), recentRegenerateUnique, id),
+		))
+	}
+	markup.Inline(rows...)
+	return c.Send(sb.String(), markup)
+}
+
+// recentRecord returns the record of a /recent button, answering the callback if it is gone
+func recentRecord(c tele.Context, span trace.Span) (generationRecord, bool) {
+	id, err := strconv.ParseInt(c.Data(), 36, 64)
+	record, ok := recentImages.find(c.Sender().ID, id)
+	if err != nil || !ok {
+		span.SetStatus(codes.Error, "Unknown image")
+		c.Respond(&tele.CallbackResponse{Text: tr(userLocale(c), "recent.not_found"), ShowAlert: true})
+		return record, false
+	}
+	return record, true
+}
+
+// handleRecentResend sends a recorded image again by its file_id
+func handleRecentResend(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleRecentResend",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.String("recent.id", c.Data()),
+		))
+	defer span.End()
+
+	record, ok := recentRecord(c, span)
+	if !ok {
+		return nil
+	}
+	locale := userLocale(c)
+	recentCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("recent.action", "resend")))
+	c.Respond()
+	photo := &tele.Photo{File: tele.File{FileID: record.FileID}, Caption: imageCaption(locale, record.Text)}
+	if err := c.Send(photo, mainMenuFor(locale)); err != nil {
+		log.Printf("Failed to re-send image to user %d: %v", c.Sender().ID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to send photo to Telegram")
+		return c.Send(tr(locale, "image.error.send"), mainMenuFor(locale))
+	}
+	return nil
+}
+
+// handleRecentRegenerate draws a recorded text again with its size and layout and the current colors
+func handleRecentRegenerate(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleRecentRegenerate",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.String("recent.id", c.Data()),
+		))
+	defer span.End()
+
+	record, ok := recentRecord(c, span)
+	if !ok {
+		return nil
+	}
+	// The main keyboard sent with the image would hide the settings keyboard
+	if sessions.Get(c.Sender().ID).State.InSettings() {
+		locale := userLocale(c)
+		return c.Respond(&tele.CallbackResponse{
+			Text:      tr(locale, "recent.in_settings", tr(locale, "btn.save"), tr(locale, "btn.cancel")),
+			ShowAlert: true,
+		})
+	}
+	recentCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("recent.action", "regenerate")))
+	c.Respond()
+	return generateAndSendImage(ctx, c, record.Text, func(s *UserSettings) {
+		*s = withCurrentColors(record.Settings, *s)
+	})
+}
@@ -0,0 +1,139 @@
+// kbot-app/cmd/render.go
+// This file contains the image generator abstraction shared by the Imgbun and local renderers.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Renderer names accepted in KBOT_RENDERER
+const (
+	rendererImgbun = "imgbun"
+	rendererLocal  = "local"
+)
+
+// Text alignment values of UserSettings.Align
+const (
+	alignLeft   = "left"
+	alignCenter = "center"
+	alignRight  = "right"
+)
+
+// Layout defaults used while a setting is unset (zero)
+const (
+	defaultAlign       = alignCenter
+	defaultLineSpacing = 1.2
+	defaultPadding     = 32
+	defaultMaxWidth    = 800
+)
+
+// RenderRequest is the text to draw and the settings to draw it with
+type RenderRequest struct {
+	Text     string // May contain explicit line breaks
+	Settings UserSettings
+	Photo    image.Image // Decoded background photo for the photo background mode; nil falls back to BgColor
+}
+
+// RenderedImage is a generated image: either encoded PNG data or a link to an image hosted by the provider
+type RenderedImage struct {
+	PNG    []byte
+	URL    string
+	Cached bool // Served from renderCache
+}
+
+// ImageGenerator turns text into an image
+type ImageGenerator interface {
+	Name() string
+	Generate(ctx context.Context, req RenderRequest) (RenderedImage, error)
+}
+
+// imageGenerators holds the available renderers by their KBOT_RENDERER name
+var imageGenerators = map[string]ImageGenerator{
+	rendererImgbun: imgbunGenerator{},
+	rendererLocal:  localGenerator{},
+}
+
+// currentGenerator returns the renderer selected in the configuration
+func currentGenerator() ImageGenerator {
+	return imageGenerators[currentConfig().Renderer]
+}
+
+// renderError is a generation failure with the details needed for metrics and the user message
+type renderError struct {
+	Type  string               // Metric attribute error.type
+	Key   string               // Catalog key of the message shown to the user
+	Args  []interface{}        // Arguments of the message
+	Attrs []attribute.KeyValue // Extra metric attributes
+	Err   error
+}
+
+func (e *renderError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Type, e.Err)
+	}
+	return e.Type
+}
+
+func (e *renderError) Unwrap() error {
+	return e.Err
+}
+
+// textLayout is UserSettings' layout with defaults applied
+type textLayout struct {
+	Align       string
+	LineSpacing float64
+	Padding     int
+	MaxWidth    int
+	Width       int // Fixed canvas size from the size setting; 0 fits the image to the text
+	Height      int
+}
+
+// layout resolves the layout settings, replacing unset (empty) values with defaults.
+// Stored values were validated by settingFields, so parse errors fall back to defaults too.
+func (s UserSettings) layout() textLayout {
+	l := textLayout{Align: s.Align, LineSpacing: defaultLineSpacing, Padding: defaultPadding, MaxWidth: defaultMaxWidth}
+	if l.Align == "" {
+		l.Align = defaultAlign
+	}
+	if value, err := strconv.ParseFloat(s.LineSpacing, 64); err == nil {
+		l.LineSpacing = value
+	}
+	if value, err := strconv.Atoi(s.Padding); err == nil {
+		l.Padding = value
+	}
+	if value, err := strconv.Atoi(s.MaxWidth); err == nil {
+		l.MaxWidth = value
+	}
+	l.Width, l.Height = canvasSize(s.Size)
+	return l
+}
+
+// fontSize returns the font size setting, or fallback (the generator's default) when it is not set
+func (s UserSettings) fontSize(fallback float64) float64 {
+	if value, err := strconv.Atoi(s.FontSize); err == nil {
+		return float64(value)
+	}
+	return fallback
+}
+
+// parseHexColor converts a 3 or 6 digit hex color (without '#') to a color
+func parseHexColor(hex string) (color.RGBA, error) {
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", hex)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid hex color %q", hex)
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 0xff}, nil
+}
@@ -0,0 +1,227 @@
+// kbot-app/cmd/rendercmd.go
+// This file contains the "kbot render" command, which generates images offline with the same
+// generators as the bot, for scripts and tests.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// renderFlags are the options of "kbot render"
+var renderFlags struct {
+	text     string
+	fg       string
+	bg       string
+	set      []string
+	renderer string
+	fonts    []string
+	bgImage  string
+	output   string
+	csv      bool
+}
+
+// renderCmd represents the render command
+var renderCmd = &cobra.Command{
+	Use:   "render [text]",
+	Short: "Renders text to PNG files without running the bot",
+	Long: `Renders text to a PNG image with the bot's image generators, without Telegram.
+
+The text comes from --text, the arguments, or standard input. From standard input every
+non-empty line becomes an image (write \n for a line break inside an image); with --csv the
+input is read like a batch CSV file sent to the bot.
+
+Settings start from the bot's defaults, including KBOT_DEFAULT_TEXT_COLOR, KBOT_DEFAULT_BG_COLOR,
+KBOT_PALETTE and the KBOT_WATERMARK settings. --fg and --bg set the colors; --set takes any inline
+option of the bot, e.g. --set size=og --set align=left --set shadow_color=000.
+
+Examples:
+  kbot render --text "Hello" --fg red --bg 000 -o hello.png
+  kbot render --set size=story "Big news" -o - > story.png
+  printf 'One\nTwo\n' | kbot render -o card-%03d.png`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		generator, ok := imageGenerators[renderFlags.renderer]
+		if !ok {
+			return fmt.Errorf("invalid --renderer %q: expected %s or %s", renderFlags.renderer, rendererLocal, rendererImgbun)
+		}
+		if renderFlags.renderer == rendererImgbun && ImgbunAPIKey == "" {
+			return fmt.Errorf("IMGBUN_API_KEY environment variable not set (required by the %s renderer)", rendererImgbun)
+		}
+		for _, path := range renderFlags.fonts {
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("invalid --font: %w", err)
+			}
+		}
+		if currentConfig() == nil {
+			cfg := &Config{Renderer: renderFlags.renderer, FontPaths: renderFlags.fonts}
+			if err := loadBranding(cfg); err != nil {
+				return err
+			}
+			appConfig.Store(cfg)
+		}
+		settings, err := renderSettings()
+		if err != nil {
+			return err
+		}
+		var photo image.Image
+		if renderFlags.bgImage != "" {
+			if photo, err = decodeImageFile(renderFlags.bgImage); err != nil {
+				return err
+			}
+			settings.BgMode = bgPhoto
+		}
+
+		items, err := renderInput(cmd.InOrStdin(), args)
+		if err != nil {
+			return err
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("no text to render: use --text, an argument or standard input")
+		}
+		if len(items) > 1 && renderFlags.output == "-" {
+			return fmt.Errorf("cannot write %d images to standard output", len(items))
+		}
+		cmd.SilenceUsage = true // Errors from here on are not about the command line
+
+		for i, item := range items {
+			s := settings
+			item.Options.apply(&s)
+			path := renderOutputPath(renderFlags.output, i+1, len(items))
+			if err := renderToFile(cmd.Context(), generator, RenderRequest{Text: item.Text, Settings: s, Photo: photo}, path, cmd.OutOrStdout()); err != nil {
+				return fmt.Errorf("line %d: %w", item.Line, err)
+			}
+			if path != "-" {
+				fmt.Fprintln(cmd.ErrOrStderr(), path)
+			}
+		}
+		return nil
+	},
+}
+
+// renderSettings builds the settings of the render command from the defaults and the flags
+func renderSettings() (UserSettings, error) {
+	settings := defaultUserSettings()
+	var options inlineOptions
+	for _, flag := range []struct{ name, value string }{{"fg", renderFlags.fg}, {"bg", renderFlags.bg}} {
+		if flag.value == "" {
+			continue
+		}
+		option, err := resolveInlineOption(flag.name, flag.value, "--"+flag.name)
+		if err != nil {
+			return settings, fmt.Errorf("invalid --%s %q", flag.name, flag.value)
+		}
+		options = append(options, option)
+	}
+	for _, set := range renderFlags.set {
+		key, value, _ := strings.Cut(set, "=")
+		option, err := resolveInlineOption(key, value, set)
+		if err != nil {
+			return settings, fmt.Errorf("invalid --set %q (%s)", set, strings.TrimPrefix(err.Key, "options."))
+		}
+		options = append(options, option)
+	}
+	options.apply(&settings)
+	return settings, nil
+}
+
+// renderInput returns the texts to render: --text, the arguments joined by spaces, or standard input
+func renderInput(stdin io.Reader, args []string) ([]batchItem, error) {
+	if renderFlags.text != "" {
+		return []batchItem{{Line: 1, Text: strings.ReplaceAll(renderFlags.text, `\n`, "\n")}}, nil
+	}
+	if len(args) > 0 {
+		return []batchItem{{Line: 1, Text: strings.ReplaceAll(strings.Join(args, " "), `\n`, "\n")}}, nil
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return nil, fmt.Errorf("reading standard input: %w", err)
+	}
+	var items []batchItem
+	if renderFlags.csv {
+		items, err = parseBatchCSV(data)
+	} else {
+		items, err = parseBatchText(data)
+	}
+	if lerr, ok := err.(*batchLineError); ok && lerr.Option != nil {
+		return nil, fmt.Errorf("line %d: invalid %s %q", lerr.Line, lerr.Option.Option, lerr.Option.Value)
+	} else if ok {
+		return nil, fmt.Errorf("line %d: the text is longer than %d characters", lerr.Line, batchMaxTextRunes)
+	}
+	return items, err
+}
+
+// renderOutputPattern matches the number verb of an output pattern: %d with optional flags and width
+var renderOutputPattern = regexp.MustCompile(`%[-+ 0]*[0-9]*d`)
+
+// renderOutputPath returns the file of the n-th of count images. An output with a %d verb, such as
+// "card-%03d.png", gets the number there (other "%" are kept as typed); otherwise several images get
+// "-<n>" before the extension.
+func renderOutputPath(output string, n, count int) string {
+	if loc := renderOutputPattern.FindStringIndex(output); loc != nil {
+		return output[:loc[0]] + fmt.Sprintf(output[loc[0]:loc[1]], n) + output[loc[1]:]
+	}
+	if count == 1 {
+		return output
+	}
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(output, ext), n, ext)
+}
+
+// renderToFile generates req and writes the PNG to path, or to stdout if path is "-"
+func renderToFile(ctx context.Context, generator ImageGenerator, req RenderRequest, path string, stdout io.Writer) error {
+	req.Settings = brandSettings(req.Settings)
+	rendered, err := generator.Generate(ctx, req)
+	if err != nil {
+		return err
+	}
+	if rendered, err = watermarkRendered(ctx, rendered); err != nil {
+		return err
+	}
+	data, err := renderedPNG(ctx, rendered)
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		_, err = stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// decodeImageFile reads a background image from disk
+func decodeImageFile(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	return img, nil
+}
+
+func init() {
+	rootCmd.AddCommand(renderCmd)
+
+	renderCmd.Flags().StringVarP(&renderFlags.text, "text", "t", "", `Text to render; \n is a line break (default: the arguments or standard input)`)
+	renderCmd.Flags().StringVar(&renderFlags.fg, "fg", "", "Text color: hex or a color name")
+	renderCmd.Flags().StringVar(&renderFlags.bg, "bg", "", "Background color: hex or a color name")
+	renderCmd.Flags().StringArrayVar(&renderFlags.set, "set", nil, "Setting as name=value, like the bot's inline options (repeatable)")
+	renderCmd.Flags().StringVar(&renderFlags.renderer, "renderer", rendererLocal, "Image generator: local or imgbun (needs IMGBUN_API_KEY)")
+	renderCmd.Flags().StringArrayVar(&renderFlags.fonts, "font", envList("KBOT_FONT_PATHS"), "Fallback font file tried after the built-in font (repeatable)")
+	renderCmd.Flags().StringVar(&renderFlags.bgImage, "bg-image", "", "Image file to use as the background")
+	renderCmd.Flags().StringVarP(&renderFlags.output, "output", "o", "kbot.png", `Output PNG file, "-" for standard output; with several images a pattern like card-%03d.png`)
+	renderCmd.Flags().BoolVar(&renderFlags.csv, "csv", false, "Read standard input as CSV: text, text color, background color, or named columns")
+}
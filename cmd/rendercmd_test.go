@@ -0,0 +1,122 @@
+// kbot-app/cmd/rendercmd_test.go
+// This file contains the tests of the "kbot render" command with the built-in renderer.
+
+package cmd
+
+import (
+	"bytes"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runRender runs "kbot render" with args and stdin, and returns its standard output and error
+func runRender(t *testing.T, stdin string, args ...string) (string, string, error) {
+	t.Helper()
+	// Flags keep their values between runs of the same command
+	renderFlags.text, renderFlags.fg, renderFlags.bg, renderFlags.bgImage = "", "", "", ""
+	renderFlags.renderer, renderFlags.output, renderFlags.csv = rendererLocal, "kbot.png", false
+
+	var stdout, stderr bytes.Buffer
+	rootCmd.SetArgs(append([]string{"render"}, args...))
+	rootCmd.SetIn(strings.NewReader(stdin))
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	t.Cleanup(func() {
+		rootCmd.SetArgs(nil)
+		rootCmd.SetIn(nil)
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+	})
+	err := rootCmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+// checkPNG fails the test unless path holds a PNG image
+func checkPNG(t *testing.T, path string) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := png.Decode(file); err != nil {
+		t.Errorf("%s: %v", path, err)
+	}
+}
+
+func TestRenderCommandStdin(t *testing.T) {
+	dir := t.TempDir()
+	_, stderr, err := runRender(t, "One\n\nTwo\n", "--renderer", "local", "--fg", "red", "-o", filepath.Join(dir, "card-%02d.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "card-01.png"), filepath.Join(dir, "card-02.png")}
+	for _, path := range want {
+		checkPNG(t, path)
+	}
+	if got := strings.Fields(stderr); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("listed files %q, want %q", got, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != len(want) {
+		t.Errorf("wrote %d files, want %d", len(entries), len(want))
+	}
+}
+
+func TestRenderCommandStdout(t *testing.T) {
+	stdout, _, err := runRender(t, "", "--text", `Hello\nworld`, "-o", "-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(strings.NewReader(stdout)); err != nil {
+		t.Errorf("standard output is not a PNG: %v", err)
+	}
+}
+
+func TestRenderCommandErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		stdin string
+		args  []string
+		want  string
+	}{
+		{"unknown renderer", "", []string{"--renderer", "paint", "Hi"}, "invalid --renderer"},
+		{"invalid color", "", []string{"--fg", "pinkish", "Hi"}, "invalid --fg"},
+		{"no text", "\n\n", nil, "no text to render"},
+		{"several images to stdout", "One\nTwo\n", []string{"-o", "-"}, "cannot write 2 images"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := runRender(t, tt.stdin, tt.args...)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderOutputPath(t *testing.T) {
+	tests := []struct {
+		output   string
+		n, count int
+		want     string
+	}{
+		{"hello.png", 1, 1, "hello.png"},
+		{"hello.png", 2, 3, "hello-002.png"},
+		{"out/hello", 1, 2, "out/hello-001"},
+		{"card-%03d.png", 7, 9, "card-007.png"},
+		{"card-%d.png", 12, 20, "card-12.png"},
+		{"card-%03d.png", 1, 1, "card-001.png"},
+		{"100%.png", 1, 1, "100%.png"},
+		{"100%.png", 2, 2, "100%-002.png"},
+		{"50%-off-%d.png", 3, 3, "50%-off-3.png"},
+		{"%s.png", 1, 2, "%s-001.png"},
+	}
+	for _, tt := range tests {
+		if got := renderOutputPath(tt.output, tt.n, tt.count); got != tt.want {
+			t.Errorf("renderOutputPath(%q, %d, %d) = %q, want %q", tt.output, tt.n, tt.count, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// kbot-app/cmd/session.go
+// This file contains the per-user conversation session and its state machine.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	tele "gopkg.in/telebot.v4"
+)
+
+// SessionState is the position of a user in the conversation
+type SessionState string
+
+const (
+	StateIdle                SessionState = "idle"                  // Not in settings mode; text generates images
+	StateSettings            SessionState = "settings"              // In settings mode, editing a draft
+	StateAwaitingTextColor   SessionState = "awaiting_text_color"   // In settings mode, next text is the text color
+	StateAwaitingBgColor     SessionState = "awaiting_bg_color"     // In settings mode, next text is the background color
+	StateAwaitingAlign       SessionState = "awaiting_align"        // In settings mode, next text is the text alignment
+	StateAwaitingLineSpacing SessionState = "awaiting_line_spacing" // In settings mode, next text is the line spacing
+	StateAwaitingPadding     SessionState = "awaiting_padding"      // In settings mode, next text is the padding
+	StateAwaitingMaxWidth    SessionState = "awaiting_max_width"    // In settings mode, next text is the maximum width
+	StateAwaitingSize        SessionState = "awaiting_size"         // In settings mode, next text is the canvas size
+	StateAwaitingFontSize    SessionState = "awaiting_font_size"    // In settings mode, next text is the font size
+	StateAwaitingBgMode      SessionState = "awaiting_bg_mode"      // In settings mode, next text is the background mode
+	StateAwaitingBgColor2    SessionState = "awaiting_bg_color2"    // In settings mode, next text is the second gradient color
+	StateAwaitingBgAngle     SessionState = "awaiting_bg_angle"     // In settings mode, next text is the gradient angle
+
+	StateAwaitingOutlineColor SessionState = "awaiting_outline_color" // In settings mode, next text is the outline color
+	StateAwaitingOutlineWidth SessionState = "awaiting_outline_width" // In settings mode, next text is the outline width
+	StateAwaitingShadowColor  SessionState = "awaiting_shadow_color"  // In settings mode, next text is the shadow color
+	StateAwaitingShadowOffset SessionState = "awaiting_shadow_offset" // In settings mode, next text is the shadow offset
+	StateAwaitingShadowBlur   SessionState = "awaiting_shadow_blur"   // In settings mode, next text is the shadow blur
+	StateAwaitingBoxColor     SessionState = "awaiting_box_color"     // In settings mode, next text is the text box color
+)
+
+// sessionTransitions lists the allowed target states for every state.
+// Every settings-mode state may move to any other settings-mode state or leave to StateIdle.
+var sessionTransitions = map[SessionState][]SessionState{
+	StateIdle: {StateSettings},
+}
+
+func init() {
+	settingsStates := []SessionState{StateSettings}
+	for _, field := range settingFields {
+		settingsStates = append(settingsStates, field.State)
+	}
+	for _, from := range settingsStates {
+		sessionTransitions[from] = append(append([]SessionState{}, settingsStates...), StateIdle)
+	}
+}
+
+// awaitedSetting returns the setting the state is waiting for, if any
+func (s SessionState) awaitedSetting() (settingField, bool) {
+	for _, field := range settingFields {
+		if field.State == s {
+			return field, true
+		}
+	}
+	return settingField{}, false
+}
+
+// InSettings reports whether the state belongs to settings mode
+func (s SessionState) InSettings() bool {
+	return s != StateIdle
+}
+
+// canTransition reports whether moving from one state to another is allowed
+func canTransition(from, to SessionState) bool {
+	for _, allowed := range sessionTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Session is a snapshot of a user's conversation state.
+// Draft holds the settings being edited and is only meaningful outside StateIdle.
+type Session struct {
+	UserID    int64
+	State     SessionState
+	Draft     UserSettings
+	Saved     UserSettings // Settings at the moment settings mode was entered, to detect unsaved changes
+	Locale    string       // Locale captured on entry, used for messages sent outside a handler
+	UpdatedAt time.Time    // Last user activity in this session
+}
+
+// sessionStore keeps sessions for users that are not idle; an absent entry means StateIdle.
+// All reads and writes go through the store, so state and draft always change together.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[int64]*Session
+}
+
+// sessions is the global conversation state storage
+var sessions = &sessionStore{sessions: make(map[int64]*Session)}
+
+// Get returns a copy of the user's session (StateIdle if the user has none)
+func (s *sessionStore) Get(userID int64) Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[userID]; ok {
+		return *session
+	}
+	return Session{UserID: userID, State: StateIdle}
+}
+
+// Count returns the number of users currently in settings mode
+func (s *sessionStore) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Enter puts the user into settings mode with a fresh draft copied from saved.
+// Re-entering from any settings state discards the previous draft.
+func (s *sessionStore) Enter(ctx context.Context, userID int64, saved UserSettings, locale string) Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	from := StateIdle
+	if session, ok := s.sessions[userID]; ok {
+		from = session.State
+	}
+	session := &Session{UserID: userID, State: StateSettings, Draft: saved, Saved: saved, Locale: locale, UpdatedAt: time.Now()}
+	s.sessions[userID] = session
+	recordTransition(ctx, from, StateSettings, true)
+	return *session
+}
+
+// Transition moves a user in settings mode to another settings state,
+// optionally applying edit to the draft. Use Enter and Exit to leave or reach StateIdle.
+func (s *sessionStore) Transition(ctx context.Context, userID int64, to SessionState, edit func(draft *UserSettings)) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[userID]
+	if !ok {
+		recordTransition(ctx, StateIdle, to, false)
+		return Session{UserID: userID, State: StateIdle}, fmt.Errorf("invalid session transition %s -> %s", StateIdle, to)
+	}
+	if to == StateIdle || !canTransition(session.State, to) {
+		recordTransition(ctx, session.State, to, false)
+		return *session, fmt.Errorf("invalid session transition %s -> %s", session.State, to)
+	}
+	if edit != nil {
+		edit(&session.Draft)
+	}
+	recordTransition(ctx, session.State, to, true)
+	session.State = to
+	session.UpdatedAt = time.Now()
+	return *session, nil
+}
+
+// Exit returns the user to StateIdle, discarding the session.
+// It returns the session as it was before exiting and whether the user was in settings mode.
+func (s *sessionStore) Exit(ctx context.Context, userID int64) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[userID]
+	if !ok {
+		return Session{UserID: userID, State: StateIdle}, false
+	}
+	delete(s.sessions, userID)
+	recordTransition(ctx, session.State, StateIdle, true)
+	log.Printf("User %d exited settings mode.", userID)
+	return *session, true
+}
+
+// Expire applies idle timeouts at time now: sessions idle longer than settingsTTL are closed
+// and returned, pending input prompts idle longer than inputTTL fall back to StateSettings.
+// A zero TTL disables the corresponding timeout.
+func (s *sessionStore) Expire(ctx context.Context, now time.Time, settingsTTL, inputTTL time.Duration) []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []Session
+	for userID, session := range s.sessions {
+		idle := now.Sub(session.UpdatedAt)
+		if settingsTTL > 0 && idle > settingsTTL {
+			delete(s.sessions, userID)
+			recordTransition(ctx, session.State, StateIdle, true)
+			sessionExpiredCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("session.state", string(session.State))))
+			expired = append(expired, *session)
+			continue
+		}
+		if _, isWaiting := session.State.awaitedSetting(); isWaiting && inputTTL > 0 && idle > inputTTL {
+			// Keep UpdatedAt, so the settings timeout still counts from the last user activity
+			recordTransition(ctx, session.State, StateSettings, true)
+			sessionExpiredCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("session.state", string(session.State))))
+			session.State = StateSettings
+		}
+	}
+	return expired
+}
+
+// startSessionJanitor periodically expires idle sessions and, if configured,
+// notifies users whose settings session was closed
+func startSessionJanitor(b *tele.Bot) {
+	go func() {
+		for {
+			cfg := currentConfig()
+			time.Sleep(janitorInterval(cfg.SettingsTTL, cfg.InputTTL))
+			sweepSessions(sessions, currentConfig(), time.Now(), func(to tele.Recipient, what interface{}, opts ...interface{}) error {
+				_, err := b.Send(to, what, opts...)
+				return err
+			})
+		}
+	}()
+	log.Println("Session janitor started.")
+}
+
+// sweepSessions is one round of the janitor: it expires the store's idle sessions at time now and
+// sends the expiry notices through send. It returns the closed sessions.
+func sweepSessions(store *sessionStore, cfg *Config, now time.Time, send func(to tele.Recipient, what interface{}, opts ...interface{}) error) []Session {
+	ctx, span := tracer.Start(context.Background(), "sessionJanitor")
+	defer span.End()
+	expired := store.Expire(ctx, now, cfg.SettingsTTL, cfg.InputTTL)
+	for _, session := range expired {
+		log.Printf("Settings session of user %d expired in state %s", session.UserID, session.State)
+		if !cfg.NotifyOnSessionExpiry {
+			continue
+		}
+		msg := tr(session.Locale, "session.expired")
+		if session.Draft != session.Saved {
+			msg = tr(session.Locale, "session.expired_discarded")
+		}
+		if err := send(&tele.User{ID: session.UserID}, msg, mainMenuFor(session.Locale)); err != nil {
+			log.Printf("Failed to notify user %d about expired session: %v", session.UserID, err)
+			span.RecordError(err)
+		}
+	}
+	span.SetAttributes(attribute.Int("session.expired_count", len(expired)))
+	return expired
+}
+
+// janitorInterval picks how often to look for idle sessions: a fraction of the
+// shortest enabled timeout, kept between one second and one minute
+func janitorInterval(ttls ...time.Duration) time.Duration {
+	interval := time.Minute
+	for _, ttl := range ttls {
+		if ttl > 0 && ttl/4 < interval {
+			interval = ttl / 4
+		}
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// recordTransition counts a session state transition attempt
+func recordTransition(ctx context.Context, from, to SessionState, valid bool) {
+	sessionTransitionCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("session.from", string(from)),
+		attribute.String("session.to", string(to)),
+		attribute.Bool("session.valid", valid),
+	))
+}
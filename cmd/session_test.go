@@ -0,0 +1,256 @@
+// kbot-app/cmd/session_test.go
+// This file contains the tests of the session state machine, its timeouts and the janitor.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// settingsModeStates returns StateSettings followed by every state waiting for input
+func settingsModeStates() []SessionState {
+	states := []SessionState{StateSettings}
+	for _, field := range settingFields {
+		states = append(states, field.State)
+	}
+	return states
+}
+
+func TestCanTransition(t *testing.T) {
+	type transition struct {
+		from, to SessionState
+		want     bool
+	}
+	tests := []transition{
+		{StateIdle, StateSettings, true},
+		{StateIdle, StateIdle, false},
+		{StateIdle, "unknown", false},
+		{"unknown", StateSettings, false},
+		{StateSettings, "unknown", false},
+	}
+	for _, from := range settingsModeStates() {
+		for _, to := range settingsModeStates() {
+			tests = append(tests, transition{from, to, true})
+		}
+		tests = append(tests, transition{from, StateIdle, true})
+		if from != StateSettings {
+			// Input prompts are only reached from settings mode
+			tests = append(tests, transition{StateIdle, from, false})
+		}
+	}
+
+	for _, tt := range tests {
+		if got := canTransition(tt.from, tt.to); got != tt.want {
+			t.Errorf("canTransition(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestSessionStoreTransition(t *testing.T) {
+	saved := UserSettings{TextColor: "000000", BgColor: "FFFFFF"}
+	tests := []struct {
+		name      string
+		from      SessionState // StateIdle: the user never entered settings mode
+		to        SessionState
+		wantErr   bool
+		wantState SessionState
+		wantDraft string // Draft text color afterwards
+	}{
+		{"settings to prompt", StateSettings, StateAwaitingTextColor, false, StateAwaitingTextColor, "FF0000"},
+		{"prompt to prompt", StateAwaitingTextColor, StateAwaitingBgColor, false, StateAwaitingBgColor, "FF0000"},
+		{"prompt back to settings", StateAwaitingShadowBlur, StateSettings, false, StateSettings, "FF0000"},
+		{"idle to prompt", StateIdle, StateAwaitingTextColor, true, StateIdle, ""},
+		{"idle to settings needs Enter", StateIdle, StateSettings, true, StateIdle, ""},
+		{"settings to idle needs Exit", StateSettings, StateIdle, true, StateSettings, "000000"},
+		{"prompt to unknown", StateAwaitingBgColor, "unknown", true, StateAwaitingBgColor, "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &sessionStore{sessions: make(map[int64]*Session)}
+			if tt.from != StateIdle {
+				store.Enter(ctx, 1, saved, "en")
+				store.sessions[1].State = tt.from
+			}
+
+			session, err := store.Transition(ctx, 1, tt.to, func(draft *UserSettings) { draft.TextColor = "FF0000" })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Transition error = %v, want error %v", err, tt.wantErr)
+			}
+			if session.State != tt.wantState {
+				t.Errorf("returned state = %s, want %s", session.State, tt.wantState)
+			}
+			if got := store.Get(1); got.State != tt.wantState || got.Draft.TextColor != tt.wantDraft {
+				t.Errorf("stored session = %s with text color %q, want %s with %q", got.State, got.Draft.TextColor, tt.wantState, tt.wantDraft)
+			}
+			if got := store.Get(1).Saved; tt.from != StateIdle && got != saved {
+				t.Errorf("saved settings changed to %+v", got)
+			}
+		})
+	}
+}
+
+func TestSessionStoreEnterExit(t *testing.T) {
+	ctx := context.Background()
+	store := &sessionStore{sessions: make(map[int64]*Session)}
+	saved := UserSettings{TextColor: "000000", BgColor: "FFFFFF"}
+
+	if _, ok := store.Exit(ctx, 1); ok {
+		t.Fatal("Exit of an idle user reported a session")
+	}
+
+	session := store.Enter(ctx, 1, saved, "uk")
+	if session.State != StateSettings || session.Draft != saved || session.Saved != saved || session.Locale != "uk" {
+		t.Fatalf("Enter = %+v, want settings mode with the saved settings as draft", session)
+	}
+	if _, err := store.Transition(ctx, 1, StateAwaitingBgColor, func(draft *UserSettings) { draft.BgColor = "00FF00" }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Entering again discards the draft and the pending prompt
+	if session := store.Enter(ctx, 1, saved, "uk"); session.State != StateSettings || session.Draft != saved {
+		t.Fatalf("re-Enter = %+v, want a fresh draft in settings mode", session)
+	}
+	if _, err := store.Transition(ctx, 1, StateAwaitingBgColor, func(draft *UserSettings) { draft.BgColor = "00FF00" }); err != nil {
+		t.Fatal(err)
+	}
+	if store.Count() != 1 {
+		t.Fatalf("Count = %d, want 1", store.Count())
+	}
+
+	session, ok := store.Exit(ctx, 1)
+	if !ok || session.State != StateAwaitingBgColor || session.Draft.BgColor != "00FF00" {
+		t.Fatalf("Exit = %+v, %v, want the session as it was before exiting", session, ok)
+	}
+	if got := store.Get(1); got.State != StateIdle || store.Count() != 0 {
+		t.Fatalf("after Exit the user is %s with %d sessions, want idle and none", got.State, store.Count())
+	}
+}
+
+func TestSessionStoreExpire(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		state       SessionState
+		idle        time.Duration
+		settingsTTL time.Duration
+		inputTTL    time.Duration
+		wantExpired bool
+		wantState   SessionState // State afterwards when not expired
+	}{
+		{"active settings", StateSettings, time.Minute, 30 * time.Minute, 5 * time.Minute, false, StateSettings},
+		{"idle settings", StateSettings, 31 * time.Minute, 30 * time.Minute, 5 * time.Minute, true, StateIdle},
+		{"idle prompt closes the session", StateAwaitingTextColor, 31 * time.Minute, 30 * time.Minute, 5 * time.Minute, true, StateIdle},
+		{"stale prompt falls back", StateAwaitingTextColor, 6 * time.Minute, 30 * time.Minute, 5 * time.Minute, false, StateSettings},
+		{"fresh prompt stays", StateAwaitingTextColor, 4 * time.Minute, 30 * time.Minute, 5 * time.Minute, false, StateAwaitingTextColor},
+		{"exactly at the timeout", StateSettings, 30 * time.Minute, 30 * time.Minute, 5 * time.Minute, false, StateSettings},
+		{"settings timeout disabled", StateSettings, 24 * time.Hour, 0, 5 * time.Minute, false, StateSettings},
+		{"input timeout disabled", StateAwaitingBoxColor, 6 * time.Minute, 30 * time.Minute, 0, false, StateAwaitingBoxColor},
+		{"both disabled", StateAwaitingBoxColor, 24 * time.Hour, 0, 0, false, StateAwaitingBoxColor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := &sessionStore{sessions: make(map[int64]*Session)}
+			store.Enter(ctx, 1, UserSettings{TextColor: "000000", BgColor: "FFFFFF"}, "en")
+			updatedAt := now.Add(-tt.idle)
+			store.sessions[1].State = tt.state
+			store.sessions[1].UpdatedAt = updatedAt
+
+			expired := store.Expire(ctx, now, tt.settingsTTL, tt.inputTTL)
+			if tt.wantExpired {
+				if len(expired) != 1 || expired[0].UserID != 1 || expired[0].State != tt.state {
+					t.Fatalf("Expire = %+v, want the session of user 1 in %s", expired, tt.state)
+				}
+			} else if len(expired) != 0 {
+				t.Fatalf("Expire = %+v, want nothing expired", expired)
+			}
+			got := store.Get(1)
+			if got.State != tt.wantState {
+				t.Errorf("state after Expire = %s, want %s", got.State, tt.wantState)
+			}
+			if !tt.wantExpired && !got.UpdatedAt.Equal(updatedAt) {
+				t.Errorf("Expire moved UpdatedAt to %v", got.UpdatedAt)
+			}
+		})
+	}
+}
+
+func TestSweepSessions(t *testing.T) {
+	saved := UserSettings{TextColor: "000000", BgColor: "FFFFFF"}
+	tests := []struct {
+		name     string
+		notify   bool
+		edited   bool
+		sendErr  error
+		wantSent string // Message sent to the user, empty for none
+	}{
+		{"notify", true, false, nil, tr("en", "session.expired")},
+		{"notify unsaved changes", true, true, nil, tr("en", "session.expired_discarded")},
+		{"notifications off", false, true, nil, ""},
+		{"send failure", true, false, errors.New("blocked by the user"), tr("en", "session.expired")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Now()
+			store := &sessionStore{sessions: make(map[int64]*Session)}
+			store.Enter(ctx, 1, saved, "en")
+			if tt.edited {
+				store.sessions[1].Draft.TextColor = "FF0000"
+			}
+			store.sessions[1].UpdatedAt = now.Add(-time.Hour)
+			store.Enter(ctx, 2, saved, "en") // Active, must survive
+
+			cfg := &Config{SettingsTTL: 30 * time.Minute, InputTTL: 5 * time.Minute, NotifyOnSessionExpiry: tt.notify}
+			var sent []string
+			expired := sweepSessions(store, cfg, now, func(to tele.Recipient, what interface{}, opts ...interface{}) error {
+				if to.Recipient() != "1" {
+					t.Errorf("notified %s, want user 1", to.Recipient())
+				}
+				sent = append(sent, what.(string))
+				return tt.sendErr
+			})
+
+			if len(expired) != 1 || expired[0].UserID != 1 {
+				t.Fatalf("sweepSessions = %+v, want the session of user 1", expired)
+			}
+			if store.Get(1).State != StateIdle || store.Get(2).State != StateSettings {
+				t.Errorf("after the sweep user 1 is %s and user 2 is %s, want idle and settings", store.Get(1).State, store.Get(2).State)
+			}
+			switch {
+			case tt.wantSent == "" && len(sent) != 0:
+				t.Errorf("sent %q, want no notification", sent)
+			case tt.wantSent != "" && (len(sent) != 1 || sent[0] != tt.wantSent):
+				t.Errorf("sent %q, want %q", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestJanitorInterval(t *testing.T) {
+	tests := []struct {
+		ttls []time.Duration
+		want time.Duration
+	}{
+		{[]time.Duration{30 * time.Minute, 5 * time.Minute}, time.Minute},
+		{[]time.Duration{2 * time.Minute, 0}, 30 * time.Second},
+		{[]time.Duration{0, 20 * time.Second}, 5 * time.Second},
+		{[]time.Duration{time.Second}, time.Second},
+		{[]time.Duration{0, 0}, time.Minute},
+		{nil, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := janitorInterval(tt.ttls...); got != tt.want {
+			t.Errorf("janitorInterval(%v) = %v, want %v", tt.ttls, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// kbot-app/cmd/size.go
+// This file contains image size presets and aspect ratios, the font auto-fit of the local renderer
+// on a fixed canvas and the fitting of Imgbun images to it.
+
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Values of UserSettings.Size besides "<W>x<H>" and "<W>:<H>"
+const (
+	sizeAuto = "auto" // The image fits the text (same as empty)
+)
+
+// sizePresets are the named canvas sizes
+var sizePresets = map[string][2]int{
+	"square": {1080, 1080},
+	"story":  {1080, 1920}, // 9:16
+	"banner": {1920, 1080}, // 16:9
+	"og":     {1200, 630},  // Open Graph link preview
+}
+
+// Canvas limits accepted from users
+const (
+	minCanvasSide  = 64
+	maxCanvasSide  = 2048
+	ratioShortSide = 1080 // Shorter side of a canvas given as an aspect ratio
+	maxRatio       = 4    // Aspect ratios may be at most 4:1 or 1:4
+	minFitFontSize = 8    // Font sizes tried by the auto-fit, in pixels
+	maxFitFontSize = 400
+)
+
+// canvasSize resolves a normalized size setting to pixels; 0, 0 means the image fits the text
+func canvasSize(value string) (int, int) {
+	if preset, ok := sizePresets[value]; ok {
+		return preset[0], preset[1]
+	}
+	if w, h, ok := splitSize(value, "x"); ok {
+		return w, h
+	}
+	if a, b, ok := splitSize(value, ":"); ok {
+		w, h := ratioShortSide*a/b, ratioShortSide
+		if a < b {
+			w, h = ratioShortSide, ratioShortSide*b/a
+		}
+		if longer := max(w, h); longer > maxCanvasSide {
+			w, h = w*maxCanvasSide/longer, h*maxCanvasSide/longer
+		}
+		return w, h
+	}
+	return 0, 0
+}
+
+// parseSizeValue accepts a preset, a size "<W>x<H>" in pixels, an aspect ratio "<W>:<H>" or auto
+func parseSizeValue(raw string) (string, bool) {
+	value := strings.ToLower(strings.TrimSpace(raw))
+	value = strings.ReplaceAll(strings.ReplaceAll(value, "×", "x"), " ", "")
+	if _, ok := sizePresets[value]; ok || value == sizeAuto {
+		return value, true
+	}
+	if w, h, ok := splitSize(value, "x"); ok {
+		valid := w >= minCanvasSide && w <= maxCanvasSide && h >= minCanvasSide && h <= maxCanvasSide
+		return fmt.Sprintf("%dx%d", w, h), valid
+	}
+	if a, b, ok := splitSize(value, ":"); ok {
+		valid := a > 0 && b > 0 && a <= maxRatio*b && b <= maxRatio*a
+		return fmt.Sprintf("%d:%d", a, b), valid
+	}
+	return raw, false
+}
+
+// splitSize parses two positive integers separated by sep
+func splitSize(value, sep string) (int, int, bool) {
+	first, second, found := strings.Cut(value, sep)
+	if !found {
+		return 0, 0, false
+	}
+	a, errA := strconv.Atoi(first)
+	b, errB := strconv.Atoi(second)
+	return a, b, errA == nil && errB == nil && a > 0 && b > 0
+}
+
+// sizeSummary describes the size setting for the settings mode greeting
+func sizeSummary(s UserSettings) string {
+	w, h := canvasSize(s.Size)
+	if w == 0 {
+		return sizeAuto
+	}
+	if _, ok := sizePresets[s.Size]; ok || strings.Contains(s.Size, ":") {
+		return fmt.Sprintf("%s (%dx%d)", s.Size, w, h)
+	}
+	return s.Size
+}
+
+// fontSizeSummary describes the font size setting for the settings mode greeting
+func fontSizeSummary(s UserSettings) string {
+	if s.FontSize == "" {
+		return sizeAuto
+	}
+	return s.FontSize + "px"
+}
+
+// fitFontSize finds the largest font size at which the text, wrapped at the canvas width, fits the canvas
+func fitFontSize(text string, layout textLayout) (float64, error) {
+	contentWidth, contentHeight := layout.Width-2*layout.Padding, layout.Height-2*layout.Padding
+	lo, hi := minFitFontSize, maxFitFontSize+1 // lo always fits (or is the smallest size), hi never does
+	for hi-lo > 1 {
+		mid := (lo + hi) / 2
+		face, err := localFace(float64(mid))
+		if err != nil {
+			return 0, err
+		}
+		probe := &localScene{face: face, layout: layout}
+		probe.layoutText(text)
+		face.Close()
+		if probe.textWidth <= contentWidth && probe.textHeight <= contentHeight {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return float64(lo), nil
+}
+
+// estimateFontSize guesses the font size at which the text fills a content area of width x height, from
+// its line count and longest line. Imgbun cannot measure text for us, so its image is requested at this
+// size and scaled to the canvas by fitToCanvas, which then only corrects the guess.
+func estimateFontSize(text string, width, height int) int {
+	lines := strings.Split(text, "\n")
+	longest := 1
+	for _, line := range lines {
+		longest = max(longest, utf8.RuneCountInString(line))
+	}
+	// A line is about 1.2 font sizes high and an average glyph 0.6 font sizes wide
+	size := min(height*10/(12*len(lines)), width*10/(6*longest))
+	return min(max(size, minFitFontSize), maxFitFontSize)
+}
+
+// fitToCanvas scales src to the largest size that fits the canvas within padding, keeping its aspect ratio,
+// and centers it on a canvas of width x height filled with bg
+func fitToCanvas(src image.Image, width, height, padding int, bg color.Color) *image.RGBA {
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	cw, ch := max(1, width-2*padding), max(1, height-2*padding)
+	if sw == 0 || sh == 0 {
+		return canvas
+	}
+	w, h := max(1, sw*ch/sh), ch
+	if sw*ch > sh*cw {
+		// The image is wider than the content area: its width is the limit
+		w, h = cw, max(1, sh*cw/sw)
+	}
+	x, y := (width-w)/2, (height-h)/2
+	xdraw.CatmullRom.Scale(canvas, image.Rect(x, y, x+w, y+h), src, src.Bounds(), draw.Over, nil)
+	return canvas
+}
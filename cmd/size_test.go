@@ -0,0 +1,123 @@
+// kbot-app/cmd/size_test.go
+// This file contains the tests of size parsing, canvas sizes and the fitting of Imgbun images to a canvas.
+
+package cmd
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestParseSizeValue(t *testing.T) {
+	tests := []struct {
+		raw       string
+		want      string
+		wantValid bool
+	}{
+		{"og", "og", true},
+		{" Story ", "story", true},
+		{"auto", "auto", true},
+		{"800x600", "800x600", true},
+		{"800 × 600", "800x600", true},
+		{"64x2048", "64x2048", true},
+		{"63x600", "63x600", false},
+		{"800x2049", "800x2049", false},
+		{"16:9", "16:9", true},
+		{"4:1", "4:1", true},
+		{"5:1", "5:1", false},
+		{"0:9", "0:9", false},
+		{"huge", "huge", false},
+	}
+	for _, tt := range tests {
+		got, valid := parseSizeValue(tt.raw)
+		if got != tt.want || valid != tt.wantValid {
+			t.Errorf("parseSizeValue(%q) = %q, %v, want %q, %v", tt.raw, got, valid, tt.want, tt.wantValid)
+		}
+	}
+}
+
+func TestCanvasSize(t *testing.T) {
+	tests := []struct {
+		value string
+		w, h  int
+	}{
+		{"", 0, 0},
+		{"auto", 0, 0},
+		{"og", 1200, 630},
+		{"story", 1080, 1920},
+		{"800x600", 800, 600},
+		{"16:9", 1920, 1080},
+		{"9:16", 1080, 1920},
+		{"1:1", 1080, 1080},
+		{"4:1", 2048, 512}, // The longer side is capped at maxCanvasSide
+	}
+	for _, tt := range tests {
+		if w, h := canvasSize(tt.value); w != tt.w || h != tt.h {
+			t.Errorf("canvasSize(%q) = %dx%d, want %dx%d", tt.value, w, h, tt.w, tt.h)
+		}
+	}
+}
+
+func TestEstimateFontSize(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		width, height int
+		want          int
+	}{
+		{"short text is limited by the height", "Hi", 1000, 120, 100},
+		{"long line is limited by the width", "0123456789", 600, 1000, 100},
+		{"lines share the height", "a\nb\nc", 1000, 360, 100},
+		{"longest line counts", "ab\n0123456789", 600, 1000, 100},
+		{"runes, not bytes", "привіт", 360, 1000, 100},
+		{"tiny canvas keeps the smallest size", "a long line of text", 64, 64, minFitFontSize},
+		{"huge canvas keeps the largest size", "a", 2048, 2048, maxFitFontSize},
+	}
+	for _, tt := range tests {
+		if got := estimateFontSize(tt.text, tt.width, tt.height); got != tt.want {
+			t.Errorf("%s: estimateFontSize(%q, %d, %d) = %d, want %d", tt.name, tt.text, tt.width, tt.height, got, tt.want)
+		}
+	}
+}
+
+func TestFitToCanvas(t *testing.T) {
+	bg := color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	fg := color.RGBA{0xE6, 0x39, 0x46, 0xFF}
+	tests := []struct {
+		name          string
+		src           image.Point
+		width, height int
+		padding       int
+		want          image.Rectangle // Area covered by the scaled image
+	}{
+		{"wide image on a square", image.Pt(200, 50), 400, 400, 0, image.Rect(0, 150, 400, 250)},
+		{"tall image on a wide canvas", image.Pt(50, 100), 400, 200, 0, image.Rect(150, 0, 250, 200)},
+		{"padding is kept free", image.Pt(100, 100), 300, 200, 20, image.Rect(70, 20, 230, 180)},
+		{"large image is scaled down", image.Pt(1000, 500), 200, 200, 0, image.Rect(0, 50, 200, 150)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rectangle{Max: tt.src})
+			draw.Draw(src, src.Bounds(), image.NewUniform(fg), image.Point{}, draw.Src)
+			got := fitToCanvas(src, tt.width, tt.height, tt.padding, bg)
+			if got.Bounds() != image.Rect(0, 0, tt.width, tt.height) {
+				t.Fatalf("canvas = %v, want %dx%d", got.Bounds(), tt.width, tt.height)
+			}
+			// Sample inside the image and around it, away from the edges blended by the scaling
+			inside := tt.want.Inset(2)
+			for _, p := range []image.Point{inside.Min, inside.Max.Sub(image.Pt(1, 1)), image.Pt((tt.want.Min.X+tt.want.Max.X)/2, (tt.want.Min.Y+tt.want.Max.Y)/2)} {
+				if c := got.RGBAAt(p.X, p.Y); c != fg {
+					t.Errorf("pixel %v = %v, want the image color", p, c)
+				}
+			}
+			outside := tt.want.Inset(-2)
+			for _, p := range []image.Point{image.Pt(outside.Min.X, outside.Min.Y), image.Pt(outside.Max.X, outside.Max.Y)} {
+				if p.In(got.Bounds()) && got.RGBAAt(p.X, p.Y) != bg {
+					t.Errorf("pixel %v = %v, want the background", p, got.RGBAAt(p.X, p.Y))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+// kbot-app/cmd/sticker.go
+// This file contains /sticker and /sticker_pack: text rendered as a transparent sticker and kept in a per-user sticker pack.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+	tele "gopkg.in/telebot.v4"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sticker limits from the Telegram Bot API and the renderer
+const (
+	stickerSize     = 512       // The longer side of a static sticker must be exactly this many pixels
+	stickerMaxBytes = 512 << 10 // Upload limit of a static sticker
+	stickerMaxScale = 4         // Short texts are drawn at most this many times larger than images
+	stickerEmoji    = "💬"       // Emoji every sticker is associated with
+	stickerTitleMax = 64        // Sticker pack title limit
+	stickerPackLink = "https://t.me/addstickers/%s"
+	stickersTooMuch = "STICKERS_TOO_MUCH" // Telegram error when a pack has no room left
+)
+
+// handleSticker handles /sticker <text>: renders the text as a sticker, adds it to the sender's
+// sticker pack (creating the pack on first use) and sends it back
+func handleSticker(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleSticker",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	mainMenu := mainMenuFor(locale)
+	text := commandText(c.Message().Text)
+	if text == "" {
+		return c.Send(tr(locale, "cmd.usage", commandUsage("sticker")), mainMenu)
+	}
+	if ok, wait := renderLimiter.Allow(ctx, telegramRequester(senderID), "telegram"); !ok {
+		span.AddEvent("Rate limited")
+		return c.Send(tr(locale, "image.rate_limited", retrySeconds(wait)), mainMenu)
+	}
+	if blocked, err := moderationBlocked(ctx, c, text, sourceSticker); blocked {
+		span.SetStatus(codes.Error, "Blocked by moderation")
+		return err
+	}
+
+	imageGenRequestCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("image.output", "sticker")))
+	startTime := time.Now()
+
+	// Stickers are always drawn by the local renderer: they need a transparent background
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	settings := settingsRaw.(UserSettings)
+	data, err := renderSticker(ctx, RenderRequest{Text: text, Settings: settings})
+	if err != nil {
+		log.Printf("Sticker rendering failed for user %d: %v", senderID, err)
+		rerr, ok := err.(*renderError)
+		if !ok {
+			rerr = &renderError{Type: "unknown", Key: "image.error.failed", Err: err}
+		}
+		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", rerr.Type), attribute.String("image.output", "sticker")))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return c.Send(tr(locale, rerr.Key, rerr.Args...), mainMenu)
+	}
+	imageGenerationDuration.Record(ctx, time.Since(startTime).Seconds(),
+		metric.WithAttributes(attribute.Bool("success", true), attribute.String("image.renderer", rendererLocal), attribute.String("image.output", "sticker")))
+	imageGenSuccessCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("image.output", "sticker")))
+
+	// Static stickers can only be uploaded as part of a pack; the sent sticker is taken from the pack
+	name, created, err := addToStickerPack(ctx, c, settings.StickerSet, data)
+	if err != nil {
+		log.Printf("Failed to add sticker to the pack of user %d: %v", senderID, err)
+		imageGenFailureCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("error.type", "sticker_pack_error"), attribute.String("image.output", "sticker")))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to add sticker to the pack")
+		if strings.Contains(err.Error(), stickersTooMuch) {
+			return c.Send(tr(locale, "sticker.error.pack_full"), mainMenu)
+		}
+		return c.Send(tr(locale, "sticker.error.pack"), mainMenu)
+	}
+	if name != settings.StickerSet {
+		storeStickerSet(ctx, senderID, name)
+	}
+	stickerAddedCounter.Add(ctx, 1, metric.WithAttributes(attribute.Bool("sticker.pack_created", created)))
+	span.SetAttributes(attribute.String("sticker.set", name), attribute.Bool("sticker.pack_created", created))
+
+	set, err := c.Bot().StickerSet(name)
+	if err != nil || len(set.Stickers) == 0 {
+		log.Printf("Could not load sticker pack %s of user %d: %v", name, senderID, err)
+		span.AddEvent("Sticker pack unavailable after adding")
+		return c.Send(tr(locale, "sticker.pack", 0, fmt.Sprintf(stickerPackLink, name)), mainMenu)
+	}
+	sticker := set.Stickers[len(set.Stickers)-1]
+	log.Printf("Sending sticker from pack %s (%d bytes) to user %d (%s)", name, len(data), senderID, c.Sender().Username)
+	if err := c.Send(&sticker, mainMenu); err != nil {
+		log.Printf("Error sending sticker to user %d: %v", senderID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to send sticker to Telegram")
+		return c.Send(tr(locale, "image.error.send"), mainMenu)
+	}
+	if created {
+		return c.Send(tr(locale, "sticker.pack_created", fmt.Sprintf(stickerPackLink, name)), mainMenu)
+	}
+	return nil
+}
+
+// handleStickerPack handles /sticker_pack: shows the link to the sender's sticker pack
+func handleStickerPack(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	_, span := tracer.Start(context.Background(), "handleStickerPack",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	locale := userLocale(c)
+	mainMenu := mainMenuFor(locale)
+	var name string
+	if settingsRaw, ok := userSettingsStore.Load(c.Sender().ID); ok {
+		name = settingsRaw.(UserSettings).StickerSet
+	}
+	if name == "" {
+		return c.Send(tr(locale, "sticker.no_pack"), mainMenu)
+	}
+	set, err := c.Bot().StickerSet(name)
+	if err != nil {
+		// The pack was deleted with @Stickers; the next /sticker creates it again
+		log.Printf("Sticker pack %s of user %d is unavailable: %v", name, c.Sender().ID, err)
+		span.RecordError(err)
+		return c.Send(tr(locale, "sticker.no_pack"), mainMenu)
+	}
+	return c.Send(tr(locale, "sticker.pack", len(set.Stickers), fmt.Sprintf(stickerPackLink, name)), mainMenu)
+}
+
+// commandText returns the text after the command in a raw message, keeping line breaks
+func commandText(raw string) string {
+	if i := strings.IndexAny(raw, " \n"); i >= 0 {
+		return strings.TrimSpace(raw[i:]) // Drop "/command" (or "/command@botname")
+	}
+	return ""
+}
+
+// renderSticker draws the text on a transparent background and encodes a PNG whose longer side is stickerSize.
+// Short texts are laid out again at a larger scale, so that they are not blurred by upscaling.
+func renderSticker(ctx context.Context, req RenderRequest) ([]byte, error) {
+	_, span := tracer.Start(ctx, "renderSticker")
+	defer span.End()
+
+	req.Settings = brandSettings(req.Settings) // Stickers keep to the palette but carry no watermark
+	req.Settings.BgMode = bgTransparent
+	req.Settings.Size = "" // Stickers have their own size
+	req.Settings.FontSize = ""
+	req.Photo = nil
+	img, err := renderStickerScene(req, 1)
+	if err != nil {
+		return nil, err
+	}
+	if scale := float64(stickerSize) / float64(max(img.Bounds().Dx(), img.Bounds().Dy())); scale > 1 {
+		if img, err = renderStickerScene(req, math.Min(scale, stickerMaxScale)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Fit the longer side to stickerSize exactly
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	longer := max(w, h)
+	out := image.NewRGBA(image.Rect(0, 0, max(1, w*stickerSize/longer), max(1, h*stickerSize/longer)))
+	xdraw.CatmullRom.Scale(out, out.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, out); err != nil {
+		return nil, &renderError{Type: "encode_error", Key: "image.error.failed", Err: err}
+	}
+	span.SetAttributes(attribute.Int("sticker.bytes", buf.Len()), attribute.Int("sticker.width", out.Bounds().Dx()), attribute.Int("sticker.height", out.Bounds().Dy()))
+	if buf.Len() > stickerMaxBytes {
+		return nil, &renderError{Type: "too_large", Key: "sticker.error.too_large", Err: fmt.Errorf("sticker is %d bytes", buf.Len())}
+	}
+	return buf.Bytes(), nil
+}
+
+// renderStickerScene draws the whole text with the font, layout and effects enlarged by scale
+func renderStickerScene(req RenderRequest, scale float64) (*image.RGBA, error) {
+	req.Settings = scaledSettings(req.Settings, scale)
+	scene, err := newLocalScene(req, localFontSize*scale)
+	if err != nil {
+		return nil, err
+	}
+	defer scene.Close()
+	return scene.frame(-1, 1, scene.textColor), nil
+}
+
+// scaledSettings multiplies the pixel sizes of the layout and the effects by scale
+func scaledSettings(s UserSettings, scale float64) UserSettings {
+	if scale == 1 {
+		return s
+	}
+	px := func(v int) string { return strconv.Itoa(int(math.Round(float64(v) * scale))) }
+	layout := s.layout()
+	s.Padding, s.MaxWidth = px(layout.Padding), px(layout.MaxWidth)
+	if fx, err := s.effects(); err == nil {
+		s.OutlineWidth, s.ShadowBlur = px(fx.OutlineWidth), px(fx.ShadowBlur)
+		s.ShadowOffset = px(fx.ShadowOffset.X) + "," + px(fx.ShadowOffset.Y)
+	}
+	return s
+}
+
+// addToStickerPack adds a PNG sticker to the user's pack. Without a pack, or if the pack was deleted,
+// a new one is created. It returns the pack name and whether the pack was created.
+func addToStickerPack(ctx context.Context, c tele.Context, name string, data []byte) (string, bool, error) {
+	_, span := tracer.Start(ctx, "addToStickerPack")
+	defer span.End()
+
+	input := tele.InputSticker{
+		File:   tele.FromReader(bytes.NewReader(data)),
+		Format: tele.StickerStatic,
+		Emojis: []string{stickerEmoji},
+	}
+	if name != "" {
+		err := c.Bot().AddStickerToSet(c.Sender(), name, input)
+		if !errors.Is(err, tele.ErrStickerSetInvalid) {
+			return name, false, err
+		}
+		span.AddEvent("Sticker pack no longer exists")
+		input.File = tele.FromReader(bytes.NewReader(data))
+	}
+
+	bot, ok := c.Bot().(*tele.Bot)
+	if !ok {
+		return "", false, errors.New("sticker packs need a *tele.Bot to learn the bot username")
+	}
+	name = fmt.Sprintf("kbot_%d_by_%s", c.Sender().ID, bot.Me.Username)
+	title := tr(userLocale(c), "sticker.set_title", c.Sender().FirstName)
+	if runes := []rune(title); len(runes) > stickerTitleMax {
+		title = string(runes[:stickerTitleMax])
+	}
+	err := c.Bot().CreateStickerSet(c.Sender(), &tele.StickerSet{
+		Type:  tele.StickerRegular,
+		Name:  name,
+		Title: title,
+		Input: []tele.InputSticker{input},
+	})
+	if errors.Is(err, tele.ErrStickerSetNameOccupied) {
+		// The pack exists, but its name was lost (e.g. settings were reset): keep using it
+		span.AddEvent("Sticker pack already exists")
+		input.File = tele.FromReader(bytes.NewReader(data))
+		return name, false, c.Bot().AddStickerToSet(c.Sender(), name, input)
+	}
+	if err != nil {
+		span.RecordError(err)
+		return "", false, err
+	}
+	return name, true, nil
+}
+
+// storeStickerSet remembers the user's sticker pack in permanent settings and in the draft,
+// so saving settings later keeps it
+func storeStickerSet(ctx context.Context, userID int64, name string) {
+	settingsRaw, _ := userSettingsStore.LoadOrStore(userID, defaultUserSettings())
+	settings := settingsRaw.(UserSettings)
+	settings.StickerSet = name
+	userSettingsStore.Store(userID, settings)
+	if session := sessions.Get(userID); session.State.InSettings() {
+		// Stay in the current state, only the draft changes
+		if _, err := sessions.Transition(ctx, userID, session.State, func(draft *UserSettings) {
+			draft.StickerSet = name
+		}); err != nil {
+			log.Printf("Could not update sticker pack in settings draft for user %d: %v", userID, err)
+		}
+	}
+	log.Printf("User %d now uses sticker pack %s", userID, name)
+}
@@ -0,0 +1,158 @@
+// kbot-app/cmd/storage.go
+// This file contains file-based persistence of bot state in KBOT_DATA_DIR.
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	settingsFileName     = "settings.json"  // Saved user settings
+	settingsSaveInterval = 30 * time.Second // How often the settings store is flushed to disk
+)
+
+// persistedState is the on-disk layout of the settings file
+type persistedState struct {
+	Settings map[string]UserSettings       `json:"settings"`          // Key: user ID as a decimal string
+	History  map[string][]settingsSnapshot `json:"history,omitempty"` // Earlier settings, oldest first; same keys
+	Recent   map[string][]generationRecord `json:"recent,omitempty"`  // Generated images, oldest first; same keys
+}
+
+// dataPath returns the path of a file in the data directory, or "" if persistence is disabled
+func dataPath(name string) string {
+	dir := currentConfig().DataDir
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+// writeJSONFile atomically replaces path with the JSON encoding of v
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readJSONFile decodes path into v. It returns false without an error if the file does not exist.
+func readJSONFile(path string, v interface{}) (bool, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// loadSettingsStore fills userSettingsStore from the data directory
+func loadSettingsStore() error {
+	path := dataPath(settingsFileName)
+	if path == "" {
+		log.Println("KBOT_DATA_DIR is not set: user settings will not survive a restart.")
+		return nil
+	}
+	var state persistedState
+	found, err := readJSONFile(path, &state)
+	if err != nil || !found {
+		return err
+	}
+	for key, settings := range state.Settings {
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			log.Printf("Skipping settings with invalid user ID %q in %s", key, path)
+			continue
+		}
+		userSettingsStore.Store(userID, settings)
+	}
+	for key, entries := range state.History {
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			log.Printf("Skipping settings history with invalid user ID %q in %s", key, path)
+			continue
+		}
+		settingsHistory.entries[userID] = entries
+	}
+	for key, records := range state.Recent {
+		userID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			log.Printf("Skipping generated images with invalid user ID %q in %s", key, path)
+			continue
+		}
+		recentImages.entries[userID] = records
+	}
+	log.Printf("Loaded settings of %d users from %s", len(state.Settings), path)
+	return nil
+}
+
+// saveSettingsStore writes userSettingsStore to the data directory
+func saveSettingsStore() error {
+	path := dataPath(settingsFileName)
+	if path == "" {
+		return nil
+	}
+	state := persistedState{
+		Settings: make(map[string]UserSettings),
+		History:  make(map[string][]settingsSnapshot),
+		Recent:   make(map[string][]generationRecord),
+	}
+	userSettingsStore.Range(func(key, value interface{}) bool {
+		state.Settings[strconv.FormatInt(key.(int64), 10)] = value.(UserSettings)
+		return true
+	})
+	settingsHistory.mu.Lock()
+	for userID, entries := range settingsHistory.entries {
+		state.History[strconv.FormatInt(userID, 10)] = entries
+	}
+	settingsHistory.mu.Unlock()
+	recentImages.mu.Lock()
+	now := time.Now()
+	for userID := range recentImages.entries {
+		if records := recentImages.retain(userID, now); len(records) > 0 {
+			state.Recent[strconv.FormatInt(userID, 10)] = records
+		}
+	}
+	recentImages.mu.Unlock()
+	return writeJSONFile(path, state)
+}
+
+// startSettingsPersistence flushes the settings store to disk periodically
+func startSettingsPersistence() {
+	if dataPath(settingsFileName) == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(settingsSaveInterval) {
+			if err := saveSettingsStore(); err != nil {
+				log.Printf("Failed to save settings store: %v", err)
+			}
+		}
+	}()
+}
+
+// knownUserIDs returns the IDs of all users in the settings store
+func knownUserIDs() []int64 {
+	var ids []int64
+	userSettingsStore.Range(func(key, _ interface{}) bool {
+		ids = append(ids, key.(int64))
+		return true
+	})
+	return ids
+}
@@ -0,0 +1,208 @@
+// kbot-app/cmd/style.go
+// This file contains shared styles: /share_style publishes the user's look as a t.me deep link,
+// and /start with a "style_<code>" payload offers to apply it.
+
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v4"
+)
+
+const (
+	stylesFileName     = "styles.json" // Shared styles by code
+	stylePayloadPrefix = "style_"      // /start payload of a shared style
+	styleCodeLength    = 10            // base64url characters of the style hash
+	styleApplyUnique   = "apply_style" // Callback of the apply button
+)
+
+// sharedStyles holds the published styles; a code is derived from the style, so sharing it again gives the same link
+var sharedStyles sync.Map // Key: string (code), Value: map[string]string (setting name -> value)
+
+// stylesSaveMu serializes writes of the styles file
+var stylesSaveMu sync.Mutex
+
+// persistedStyles is the on-disk layout of the styles file
+type persistedStyles struct {
+	Styles map[string]map[string]string `json:"styles"`
+}
+
+// loadSharedStyles fills sharedStyles from the data directory
+func loadSharedStyles() error {
+	path := dataPath(stylesFileName)
+	if path == "" {
+		return nil
+	}
+	var state persistedStyles
+	found, err := readJSONFile(path, &state)
+	if err != nil || !found {
+		return err
+	}
+	for code, style := range state.Styles {
+		sharedStyles.Store(code, style)
+	}
+	log.Printf("Loaded %d shared styles from %s", len(state.Styles), path)
+	return nil
+}
+
+// saveSharedStyles writes sharedStyles to the data directory
+func saveSharedStyles() error {
+	path := dataPath(stylesFileName)
+	if path == "" {
+		return nil
+	}
+	stylesSaveMu.Lock()
+	defer stylesSaveMu.Unlock()
+	state := persistedStyles{Styles: make(map[string]map[string]string)}
+	sharedStyles.Range(func(key, value interface{}) bool {
+		state.Styles[key.(string)] = value.(map[string]string)
+		return true
+	})
+	return writeJSONFile(path, state)
+}
+
+// styleOf returns the look of the settings: the exported settings without the language
+func styleOf(s UserSettings) map[string]string {
+	style := exportSettings(s).Settings
+	delete(style, "lang")
+	return style
+}
+
+// styleCode derives the code of a style from its content
+func styleCode(style map[string]string) string {
+	data, _ := json.Marshal(style) // Map keys are sorted, so equal styles give equal codes
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:styleCodeLength]
+}
+
+// styleSummary lists the values of a style, one per line, in the order of the settings
+func styleSummary(locale string, style map[string]string) string {
+	var lines []string
+	for _, field := range settingFields {
+		if value, ok := style[field.Name]; ok {
+			lines = append(lines, tr(locale, "field."+field.Name)+": "+field.display(value))
+		}
+	}
+	if len(lines) == 0 {
+		return tr(locale, "style.defaults")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// handleShareStyle handles /share_style: publishes the saved style and replies with its deep link
+func handleShareStyle(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleShareStyle",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	markup := mainMenuFor(locale)
+	if sessions.Get(senderID).State.InSettings() {
+		markup = settingsMenuFor(locale)
+	}
+	bot, ok := c.Bot().(*tele.Bot)
+	if !ok {
+		span.SetStatus(codes.Error, "Bot username unknown")
+		return c.Send(tr(locale, "style.error"), markup)
+	}
+
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	style := styleOf(settingsRaw.(UserSettings))
+	code := styleCode(style)
+	if _, known := sharedStyles.LoadOrStore(code, style); !known {
+		if err := saveSharedStyles(); err != nil {
+			log.Printf("Failed to save shared styles: %v", err)
+			span.RecordError(err)
+		}
+	}
+	span.SetAttributes(attribute.String("style.code", code))
+	styleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("style.action", "share")))
+	log.Printf("User %d (%s) shared style %s", senderID, c.Sender().Username, code)
+
+	link := fmt.Sprintf("https://t.me/%s?start=%s%s", bot.Me.Username, stylePayloadPrefix, code)
+	return c.Send(tr(locale, "style.shared", styleSummary(locale, style), link), markup)
+}
+
+// offerSharedStyle answers /start style_<code> with the style and a button to apply it
+func offerSharedStyle(ctx context.Context, c tele.Context, span trace.Span, code string) error {
+	locale := userLocale(c)
+	span.SetAttributes(attribute.String("style.code", code))
+	raw, ok := sharedStyles.Load(code)
+	if !ok {
+		styleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("style.action", "not_found")))
+		span.SetStatus(codes.Error, "Unknown style")
+		return c.Send(tr(locale, "style.not_found"), mainMenuFor(locale))
+	}
+	styleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("style.action", "offer")))
+	markup := &tele.ReplyMarkup{}
+	markup.Inline(markup.Row(markup.Data(tr(locale, "btn.apply_style"), styleApplyUnique, code)))
+	return c.Send(tr(locale, "style.offer", styleSummary(locale, raw.(map[string]string))), markup)
+}
+
+// handleApplyStyle handles the apply button of a shared style: it replaces the saved style, keeping the language
+func handleApplyStyle(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleApplyStyle",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.String("style.code", c.Data()),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	raw, ok := sharedStyles.Load(c.Data())
+	if !ok {
+		span.SetStatus(codes.Error, "Unknown style")
+		return c.Respond(&tele.CallbackResponse{Text: tr(locale, "style.not_found"), ShowAlert: true})
+	}
+	// Saving a draft would overwrite the applied style
+	if sessions.Get(senderID).State.InSettings() {
+		return c.Respond(&tele.CallbackResponse{
+			Text:      tr(locale, "style.in_settings", tr(locale, "btn.save"), tr(locale, "btn.cancel")),
+			ShowAlert: true,
+		})
+	}
+
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	applied, ierr := settingsExport{Version: settingsExportVersion, Settings: raw.(map[string]string)}.apply(settingsRaw.(UserSettings))
+	if ierr != nil {
+		// Styles are validated again, as the file may have been edited by hand
+		log.Printf("Shared style %s is invalid: %v", c.Data(), ierr)
+		span.SetStatus(codes.Error, ierr.Error())
+		return c.Respond(&tele.CallbackResponse{Text: ierr.message(locale), ShowAlert: true})
+	}
+	storeUserSettings(senderID, applied, changeStyle)
+	styleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("style.action", "apply")))
+	log.Printf("User %d (%s) applied shared style %s", senderID, c.Sender().Username, c.Data())
+
+	if err := c.Respond(); err != nil {
+		span.RecordError(err)
+	}
+	// Replace the offer, so that the button cannot be pressed again
+	if err := c.Edit(tr(locale, "style.applied")); err != nil {
+		span.RecordError(err)
+		return c.Send(tr(locale, "style.applied"), mainMenuFor(locale))
+	}
+	return nil
+}
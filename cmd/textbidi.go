@@ -0,0 +1,311 @@
+// kbot-app/cmd/textbidi.go
+// This file contains the bidirectional reordering of lines drawn by the local renderer.
+//
+// It implements the implicit part of the Unicode Bidirectional Algorithm (UAX #9: rules W1-W7,
+// N0-N2, I1-I2 and L1-L2) on a single line. Explicit embeddings, overrides and isolates are
+// ignored; they cannot be typed in Telegram without special input methods.
+
+package cmd
+
+import (
+	"unicode"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+// visualOrder reorders a line from logical to display order, so that right-to-left text
+// (Hebrew, Arabic) reads correctly when drawn from left to right.
+// Lines are wrapped in logical order before this is applied.
+func visualOrder(line string) string {
+	runes := []rune(line)
+	classes := make([]bidi.Class, len(runes))
+	hasRTL := false
+	for i, r := range runes {
+		props, _ := bidi.LookupRune(r)
+		classes[i] = props.Class()
+		if classes[i] == bidi.R || classes[i] == bidi.AL || classes[i] == bidi.AN {
+			hasRTL = true
+		}
+	}
+	if !hasRTL {
+		return line
+	}
+
+	base := bidiBaseLevel(classes)
+	levels := bidiLevels(runes, classes, base)
+
+	// L1: trailing whitespace is drawn at the paragraph level
+	for i := len(runes) - 1; i >= 0 && (classes[i] == bidi.WS || classes[i] == bidi.S); i-- {
+		levels[i] = base
+	}
+
+	// Combining marks move together with their base character. Without mark positioning, fonts draw the
+	// marks of right-to-left scripts to the right of the pen, so in right-to-left text they precede the base.
+	var clusters [][]rune
+	var clusterLevels []int
+	for i, r := range runes {
+		if len(clusters) > 0 && unicode.In(r, unicode.Mn, unicode.Me) {
+			clusters[len(clusters)-1] = append(clusters[len(clusters)-1], r)
+			continue
+		}
+		if levels[i]%2 == 1 {
+			r = mirrorRune(r) // Mirrored glyphs in right-to-left text, e.g. "(" drawn as ")"
+		}
+		clusters = append(clusters, []rune{r})
+		clusterLevels = append(clusterLevels, levels[i])
+	}
+
+	// L2: from the highest level down to the lowest odd level, reverse every run at that level or higher
+	highest, lowestOdd := 0, 1<<30
+	for _, level := range clusterLevels {
+		highest = max(highest, level)
+		if level%2 == 1 {
+			lowestOdd = min(lowestOdd, level)
+		}
+	}
+	for level := highest; level >= lowestOdd; level-- {
+		for start := 0; start < len(clusters); {
+			if clusterLevels[start] < level {
+				start++
+				continue
+			}
+			end := start
+			for end < len(clusters) && clusterLevels[end] >= level {
+				end++
+			}
+			for i, j := start, end-1; i < j; i, j = i+1, j-1 {
+				clusters[i], clusters[j] = clusters[j], clusters[i]
+				clusterLevels[i], clusterLevels[j] = clusterLevels[j], clusterLevels[i]
+			}
+			start = end
+		}
+	}
+
+	out := make([]rune, 0, len(runes))
+	for i, cluster := range clusters {
+		if clusterLevels[i]%2 == 1 {
+			out = append(append(out, cluster[1:]...), cluster[0])
+			continue
+		}
+		out = append(out, cluster...)
+	}
+	return string(out)
+}
+
+// bidiBaseLevel applies P2-P3: the first strong character sets the line direction, left-to-right by default
+func bidiBaseLevel(classes []bidi.Class) int {
+	for _, class := range classes {
+		switch class {
+		case bidi.L:
+			return 0
+		case bidi.R, bidi.AL:
+			return 1
+		}
+	}
+	return 0
+}
+
+// bidiLevels resolves the embedding level of every rune of a line with the given base level
+func bidiLevels(runes []rune, classes []bidi.Class, base int) []int {
+	n := len(classes)
+	types := append([]bidi.Class(nil), classes...)
+	sos := bidi.L
+	if base == 1 {
+		sos = bidi.R
+	}
+	for i, t := range types {
+		switch t {
+		case bidi.LRE, bidi.RLE, bidi.LRO, bidi.RLO, bidi.PDF, bidi.LRI, bidi.RLI, bidi.FSI, bidi.PDI:
+			types[i] = bidi.BN // Explicit formatting is not supported
+		}
+	}
+
+	// W1: a non-spacing mark takes the type of the previous character
+	for i, t := range types {
+		if t == bidi.NSM {
+			types[i] = sos
+			if i > 0 {
+				types[i] = types[i-1]
+			}
+		}
+	}
+	// W2-W3: European numbers after Arabic letters are Arabic numbers; Arabic letters are right-to-left
+	lastStrong := sos
+	for i, t := range types {
+		switch t {
+		case bidi.L, bidi.R:
+			lastStrong = t
+		case bidi.AL:
+			lastStrong = t
+			types[i] = bidi.R
+		case bidi.EN:
+			if lastStrong == bidi.AL {
+				types[i] = bidi.AN
+			}
+		}
+	}
+	// W4: a single separator between two numbers of the same kind joins them
+	for i := 1; i+1 < n; i++ {
+		prev, next := types[i-1], types[i+1]
+		switch {
+		case types[i] == bidi.ES && prev == bidi.EN && next == bidi.EN:
+			types[i] = bidi.EN
+		case types[i] == bidi.CS && prev == next && (prev == bidi.EN || prev == bidi.AN):
+			types[i] = prev
+		}
+	}
+	// W5: terminators (currency, percent) next to European numbers are numbers
+	for i := 0; i < n; i++ {
+		if types[i] != bidi.ET {
+			continue
+		}
+		end := i
+		for end < n && types[end] == bidi.ET {
+			end++
+		}
+		if (i > 0 && types[i-1] == bidi.EN) || (end < n && types[end] == bidi.EN) {
+			for j := i; j < end; j++ {
+				types[j] = bidi.EN
+			}
+		}
+		i = end
+	}
+	// W6-W7: remaining separators are neutral; European numbers in left-to-right context are left-to-right
+	lastStrong = sos
+	for i, t := range types {
+		switch t {
+		case bidi.ES, bidi.ET, bidi.CS:
+			types[i] = bidi.ON
+		case bidi.L, bidi.R:
+			lastStrong = t
+		case bidi.EN:
+			if lastStrong == bidi.L {
+				types[i] = bidi.L
+			}
+		}
+	}
+
+	// N0: paired brackets take the direction of their content, or of the context before them
+	embedding := sos
+	for _, pair := range bracketPairs(runes, classes) {
+		inside := bidi.ON
+		for _, t := range types[pair[0]+1 : pair[1]] {
+			if dir := strongDirection(t); dir == embedding {
+				inside = dir
+				break
+			} else if dir != bidi.ON {
+				inside = dir
+			}
+		}
+		if inside == bidi.ON {
+			continue // No strong type inside: resolved as ordinary neutrals
+		}
+		if inside != embedding {
+			context := sos
+			for i := pair[0] - 1; i >= 0; i-- {
+				if dir := strongDirection(types[i]); dir != bidi.ON {
+					context = dir
+					break
+				}
+			}
+			if context != inside {
+				inside = embedding
+			}
+		}
+		types[pair[0]], types[pair[1]] = inside, inside
+	}
+
+	// N1-N2: neutrals between characters of the same direction take it, the others take the embedding direction
+	for i := 0; i < n; i++ {
+		if strongDirection(types[i]) != bidi.ON {
+			continue
+		}
+		end := i
+		for end < n && strongDirection(types[end]) == bidi.ON {
+			end++
+		}
+		before, after := sos, sos
+		if i > 0 {
+			before = strongDirection(types[i-1])
+		}
+		if end < n {
+			after = strongDirection(types[end])
+		}
+		dir := embedding
+		if before == after {
+			dir = before
+		}
+		for j := i; j < end; j++ {
+			types[j] = dir
+		}
+		i = end
+	}
+
+	// I1-I2: implicit levels
+	levels := make([]int, n)
+	for i, t := range types {
+		levels[i] = base
+		switch {
+		case base == 0 && t == bidi.R:
+			levels[i] = 1
+		case base == 0 && (t == bidi.EN || t == bidi.AN):
+			levels[i] = 2
+		case base == 1 && (t == bidi.L || t == bidi.EN || t == bidi.AN):
+			levels[i] = 2
+		}
+	}
+	return levels
+}
+
+// strongDirection maps resolved types to L or R for the neutral rules (numbers count as R); ON means neutral
+func strongDirection(t bidi.Class) bidi.Class {
+	switch t {
+	case bidi.L:
+		return bidi.L
+	case bidi.R, bidi.AL, bidi.EN, bidi.AN:
+		return bidi.R
+	}
+	return bidi.ON
+}
+
+// bracketPairs returns the positions of matching bracket pairs (BD16), ordered by the opening bracket
+func bracketPairs(runes []rune, classes []bidi.Class) [][2]int {
+	var pairs [][2]int
+	var stack []int
+	for i, r := range runes {
+		if classes[i] != bidi.ON {
+			continue
+		}
+		props, _ := bidi.LookupRune(r)
+		if !props.IsBracket() {
+			continue
+		}
+		if props.IsOpeningBracket() {
+			stack = append(stack, i)
+			continue
+		}
+		for j := len(stack) - 1; j >= 0; j-- {
+			if mirrorRune(runes[stack[j]]) == r {
+				pairs = append(pairs, [2]int{stack[j], i})
+				stack = stack[:j]
+				break
+			}
+		}
+	}
+	// Sort by opening position (pairs close in order, so an insertion sort is enough)
+	for i := 1; i < len(pairs); i++ {
+		for j := i; j > 0 && pairs[j][0] < pairs[j-1][0]; j-- {
+			pairs[j], pairs[j-1] = pairs[j-1], pairs[j]
+		}
+	}
+	return pairs
+}
+
+// mirrorRune returns the mirrored bracket of r, or r itself
+func mirrorRune(r rune) rune {
+	props, _ := bidi.LookupRune(r)
+	if !props.IsBracket() {
+		return r
+	}
+	return []rune(bidi.ReverseString(string(r)))[0]
+}
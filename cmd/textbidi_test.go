@@ -0,0 +1,90 @@
+// kbot-app/cmd/textbidi_test.go
+// This file contains the tests of the bidirectional reordering of the local renderer.
+
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"latin", "Hello, world", "Hello, world"},
+		{"cyrillic", "Привіт, світе!", "Привіт, світе!"},
+		{"cjk", "你好，世界", "你好，世界"},
+		{"emoji", "I ❤️ Go \U0001f44d\U0001f3fd", "I ❤️ Go \U0001f44d\U0001f3fd"},
+		{"hebrew", "שלום", "םולש"},
+		{"hebrew words", "שלום עולם", "םלוע םולש"},
+		{"hebrew and digits", "שלום 123", "123 םולש"},
+		{"hebrew and numbers", "עם 3.14 ו-25%", "25%-ו 3.14 םע"},
+		{"hebrew in latin", "abc שלום def", "abc םולש def"},
+		{"latin in hebrew", "שלום abc def עולם", "םלוע abc def םולש"},
+		{"mirrored brackets", "(שלום)", "(םולש)"},
+		{"brackets in latin", "abc (אב) 12", "abc (בא) 12"},
+		{"trailing spaces take the line direction", "שלום  ", "  םולש"},
+		{"trailing spaces after hebrew in latin", "abc אב  ", "abc בא  "},
+		{"arabic", "مرحبا بالعالم", "ملاعلاب ابحرم"},
+		{"arabic and digits", "السلام 2024", "2024 مالسلا"},
+		{"arabic-indic digits", "عام ٢٠٢٤", "٢٠٢٤ ماع"},
+		// Marks stay with their base letter and are drawn before it in right-to-left text
+		{"hebrew points", "שָׁלוֹם", "םֹולָׁש"},
+		{"arabic harakat", "مَر", "رَم"},
+		{"latin marks", "abc שלום é", "abc םולש é"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := visualOrder(tt.line); got != tt.want {
+				t.Errorf("visualOrder(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBidiLevels(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		wantBase int
+		want     []int
+	}{
+		{"latin", "ab c", 0, []int{0, 0, 0, 0}},
+		{"cyrillic", "жук", 0, []int{0, 0, 0}},
+		{"cjk", "你好", 0, []int{0, 0}},
+		{"digits only", "123", 0, []int{0, 0, 0}},
+		{"hebrew", "אב", 1, []int{1, 1}},
+		{"hebrew then digits", "אב 12", 1, []int{1, 1, 1, 2, 2}},
+		{"latin then hebrew", "ab אב", 0, []int{0, 0, 0, 1, 1}},
+		{"digits after latin", "ab 12", 0, []int{0, 0, 0, 0, 0}},
+		{"digits after hebrew in latin", "ab אב 12", 0, []int{0, 0, 0, 1, 1, 1, 2, 2}},
+		{"latin in hebrew", "אב ab", 1, []int{1, 1, 1, 2, 2}},
+		{"neutral between hebrew", "א - ב", 1, []int{1, 1, 1, 1, 1}},
+		{"arabic letter then digits", "ب 1", 1, []int{1, 1, 2}},
+		{"arabic-indic digits", "ب ١٢", 1, []int{1, 1, 2, 2}},
+		{"brackets around hebrew in latin", "a (א)", 0, []int{0, 0, 0, 1, 0}},
+		{"brackets in hebrew", "(א)", 1, []int{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runes := []rune(tt.line)
+			classes := make([]bidi.Class, len(runes))
+			for i, r := range runes {
+				props, _ := bidi.LookupRune(r)
+				classes[i] = props.Class()
+			}
+			base := bidiBaseLevel(classes)
+			if base != tt.wantBase {
+				t.Errorf("bidiBaseLevel(%q) = %d, want %d", tt.line, base, tt.wantBase)
+			}
+			if got := bidiLevels(runes, classes, base); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("bidiLevels(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
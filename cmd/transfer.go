@@ -0,0 +1,287 @@
+// kbot-app/cmd/transfer.go
+// This file contains /export and /import, which carry a user's settings to another account or device
+// as a JSON document or a compact share code.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	tele "gopkg.in/telebot.v4"
+)
+
+// Settings transfer format
+const (
+	settingsExportVersion  = 1
+	settingsCodePrefix     = "kbot1:" // Share codes are the export JSON in unpadded base64url after this prefix
+	settingsExportFileName = "kbot-settings.json"
+	settingsMaxImportBytes = 8 << 10
+)
+
+// settingsExport is the exported document: every non-default setting by its command name, plus "lang".
+// The background photo and the sticker pack belong to the account and are not exported. There are no
+// per-user presets to carry: the size presets (square, story, ...) are built in, and a saved preset
+// name travels as the "size" setting. Named user presets would need a new document version.
+type settingsExport struct {
+	Version  int               `json:"version"`
+	Settings map[string]string `json:"settings"`
+}
+
+// exportSettings builds the export document of the settings
+func exportSettings(s UserSettings) settingsExport {
+	values := make(map[string]string)
+	for _, field := range settingFields {
+		if value := field.Value(s); value != "" {
+			values[field.Name] = value
+		}
+	}
+	if s.Lang != "" {
+		values["lang"] = s.Lang
+	}
+	return settingsExport{Version: settingsExportVersion, Settings: values}
+}
+
+// code encodes the document as a share code
+func (e settingsExport) code() (string, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return settingsCodePrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// importError is an export document or share code that cannot be imported
+type importError struct {
+	Key   string // Catalog key under "import."
+	Name  string // Setting of import.unknown, import.invalid and import.not_in_palette
+	Value string // Rejected value of import.invalid and import.not_in_palette
+	Found int    // Version of import.version
+}
+
+func (e *importError) Error() string {
+	return e.Key
+}
+
+// message returns the localized reason
+func (e *importError) message(locale string) string {
+	switch e.Key {
+	case "import.unknown":
+		return tr(locale, e.Key, e.Name)
+	case "import.invalid":
+		return tr(locale, e.Key, e.Value, e.Name)
+	case "import.not_in_palette":
+		return tr(locale, e.Key, e.Value, e.Name, paletteList())
+	case "import.version":
+		return tr(locale, e.Key, e.Found)
+	case "import.too_large":
+		return tr(locale, e.Key, settingsMaxImportBytes>>10)
+	}
+	return tr(locale, e.Key)
+}
+
+// parseSettingsImport reads a share code or an export JSON document
+func parseSettingsImport(raw []byte) (settingsExport, *importError) {
+	var doc settingsExport
+	if len(raw) > settingsMaxImportBytes {
+		return doc, &importError{Key: "import.too_large"}
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(string(raw), "\ufeff"))
+	data := []byte(text)
+	if rest, ok := strings.CutPrefix(text, settingsCodePrefix); ok {
+		// Copying a long code may add line breaks
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.Join(strings.Fields(rest), ""))
+		if err != nil {
+			return doc, &importError{Key: "import.invalid_code"}
+		}
+		data = decoded
+	} else if !strings.HasPrefix(text, "{") {
+		return doc, &importError{Key: "import.invalid_code"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return doc, &importError{Key: "import.invalid_code"}
+	}
+	if doc.Version != settingsExportVersion {
+		return doc, &importError{Key: "import.version", Found: doc.Version}
+	}
+	return doc, nil
+}
+
+// apply validates every value like the settings commands and returns the imported settings.
+// Settings missing from the document are reset to the defaults; the background photo, the sticker
+// pack and, unless the document has one, the language of current are kept.
+func (e settingsExport) apply(current UserSettings) (UserSettings, *importError) {
+	result := defaultUserSettings()
+	result.Lang, result.BgPhoto, result.StickerSet = current.Lang, current.BgPhoto, current.StickerSet
+	names := make([]string, 0, len(e.Settings))
+	for name := range e.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names) // Report the same error for the same document
+	for _, name := range names {
+		value := e.Settings[name]
+		if name == "lang" {
+			locale := normalizeLocale(value)
+			if locale == "" {
+				return current, &importError{Key: "import.invalid", Name: name, Value: value}
+			}
+			result.Lang = locale
+			continue
+		}
+		field, ok := lookupField(name)
+		if !ok {
+			return current, &importError{Key: "import.unknown", Name: name}
+		}
+		normalized, ok := field.Parse(value)
+		if !ok {
+			return current, &importError{Key: "import.invalid", Name: name, Value: value}
+		}
+		if !field.inPalette(normalized) {
+			return current, &importError{Key: "import.not_in_palette", Name: name, Value: value}
+		}
+		field.Apply(&result, normalized)
+	}
+	return result, nil
+}
+
+// handleExport sends the saved settings as a JSON document and a share code
+func handleExport(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleExport",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+			attribute.String("telegram.message.text", c.Message().Text),
+		))
+	defer span.End()
+
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+	markup := mainMenuFor(locale)
+	if sessions.Get(senderID).State.InSettings() {
+		markup = settingsMenuFor(locale)
+	}
+
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	export := exportSettings(settingsRaw.(UserSettings))
+	code, err := export.code()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to encode settings")
+		return c.Send(tr(locale, "export.error"), markup)
+	}
+	document, _ := json.MarshalIndent(export, "", "  ")
+	span.SetAttributes(attribute.Int("settings.exported", len(export.Settings)))
+	settingsTransferCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("transfer.direction", "export"), attribute.String("transfer.result", "ok")))
+	log.Printf("User %d (%s) exported %d settings", senderID, c.Sender().Username, len(export.Settings))
+
+	if err := c.Send(&tele.Document{
+		File:     tele.FromReader(bytes.NewReader(document)),
+		FileName: settingsExportFileName,
+		MIME:     "application/json",
+		Caption:  tr(locale, "export.caption"),
+	}); err != nil {
+		log.Printf("Failed to send settings export to user %d: %v", senderID, err)
+		span.RecordError(err)
+	}
+	// The code goes alone in its own message, so that it can be copied as is
+	return c.Send(code, markup)
+}
+
+// handleImport imports settings from the share code or JSON after the command
+func handleImport(c tele.Context) error {
+	// Створюємо кореневий спан для обробки цього Telegram-повідомлення
+	ctx, span := tracer.Start(context.Background(), "handleImport",
+		trace.WithAttributes(
+			attribute.Int64("telegram.user.id", c.Sender().ID),
+			attribute.String("telegram.user.username", c.Sender().Username),
+			attribute.Int64("telegram.chat.id", c.Chat().ID),
+		))
+	defer span.End()
+
+	raw := commandText(c.Message().Text)
+	if raw == "" {
+		locale := userLocale(c)
+		return c.Send(tr(locale, "import.usage", settingsExportFileName), mainMenuFor(locale))
+	}
+	return importSettings(ctx, c, span, []byte(raw))
+}
+
+// isSettingsDocument reports whether a document sent to the bot is a settings export
+func isSettingsDocument(doc *tele.Document) bool {
+	return strings.EqualFold(filepath.Ext(doc.FileName), ".json") || doc.MIME == "application/json"
+}
+
+// importSettingsDocument imports settings from an exported JSON document
+func importSettingsDocument(ctx context.Context, c tele.Context, span trace.Span) error {
+	locale := userLocale(c)
+	doc := c.Message().Document
+	if doc.FileSize > settingsMaxImportBytes {
+		return c.Send((&importError{Key: "import.too_large"}).message(locale), mainMenuFor(locale))
+	}
+	reader, err := c.Bot().File(&doc.File)
+	if err != nil {
+		log.Printf("Failed to download settings file of user %d: %v", c.Sender().ID, err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to download the document")
+		return c.Send(tr(locale, "batch.error.download"), mainMenuFor(locale))
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(io.LimitReader(reader, settingsMaxImportBytes+1))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to download the document")
+		return c.Send(tr(locale, "batch.error.download"), mainMenuFor(locale))
+	}
+	return importSettings(ctx, c, span, data)
+}
+
+// importSettings validates raw and replaces the user's saved settings with it
+func importSettings(ctx context.Context, c tele.Context, span trace.Span, raw []byte) error {
+	senderID := c.Sender().ID
+	locale := userLocale(c)
+
+	// A draft would overwrite the imported settings when it is saved
+	if sessions.Get(senderID).State.InSettings() {
+		return c.Send(tr(locale, "import.in_settings", tr(locale, "btn.save"), tr(locale, "btn.cancel")), settingsMenuFor(locale))
+	}
+
+	fail := func(ierr *importError) error {
+		settingsTransferCounter.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("transfer.direction", "import"), attribute.String("transfer.result", strings.TrimPrefix(ierr.Key, "import."))))
+		span.SetStatus(codes.Error, ierr.Error())
+		return c.Send(ierr.message(locale), mainMenuFor(locale))
+	}
+	doc, ierr := parseSettingsImport(raw)
+	if ierr != nil {
+		return fail(ierr)
+	}
+	settingsRaw, _ := userSettingsStore.LoadOrStore(senderID, defaultUserSettings())
+	imported, ierr := doc.apply(settingsRaw.(UserSettings))
+	if ierr != nil {
+		return fail(ierr)
+	}
+	storeUserSettings(senderID, imported, changeImport)
+	span.SetAttributes(attribute.Int("settings.imported", len(doc.Settings)))
+	settingsTransferCounter.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("transfer.direction", "import"), attribute.String("transfer.result", "ok")))
+	log.Printf("User %d (%s) imported %d settings", senderID, c.Sender().Username, len(doc.Settings))
+
+	locale = userLocale(c) // The import may change the language
+	return c.Send(tr(locale, "import.done", len(doc.Settings)), mainMenuFor(locale))
+}